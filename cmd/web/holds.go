@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/zahnah/study-app/repository"
+	"time"
+)
+
+// holdSweepInterval is how often expired room holds are released
+const holdSweepInterval = time.Minute
+
+// listenForExpiredHolds releases the rooms guests held but never booked
+func listenForExpiredHolds(db repository.DatabaseRepo) {
+	go func() {
+		ticker := time.NewTicker(holdSweepInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweepExpiredHolds(db)
+		}
+	}()
+}
+
+func sweepExpiredHolds(db repository.DatabaseRepo) {
+	released, err := db.DeleteExpiredHolds()
+	if err != nil {
+		errorLog.Println(err)
+		return
+	}
+
+	if released > 0 {
+		infoLog.Printf("Released %d expired room holds", released)
+	}
+}
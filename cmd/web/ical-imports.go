@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/zahnah/study-app/internal/handlers"
+	"time"
+)
+
+// icalSyncInterval is how often the calendars imported by address are fetched again
+const icalSyncInterval = 30 * time.Minute
+
+// listenForICalImports keeps the rooms blocked for the bookings of the other channels they are listed on
+func listenForICalImports(repo *handlers.Repository) {
+	go func() {
+		ticker := time.NewTicker(icalSyncInterval)
+		defer ticker.Stop()
+
+		for {
+			syncICalImports(repo)
+			<-ticker.C
+		}
+	}()
+}
+
+func syncICalImports(repo *handlers.Repository) {
+	imports, err := repo.DB.AllICalImports()
+	if err != nil {
+		errorLog.Println(err)
+		return
+	}
+
+	for _, imp := range imports {
+		if imp.URL == "" {
+			continue
+		}
+
+		sync, err := repo.SyncICalImport(imp)
+		if err != nil {
+			errorLog.Printf("Importing calendar %q of %s: %v", imp.Name, imp.Room.RoomName, err)
+			continue
+		}
+
+		if sync.Added > 0 || sync.Updated > 0 || sync.Removed > 0 || len(sync.Conflicts) > 0 {
+			infoLog.Printf("Imported calendar %q of %s: %s", imp.Name, imp.Room.RoomName, sync.Summary())
+		}
+	}
+}
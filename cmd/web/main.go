@@ -10,11 +10,14 @@ import (
 	"github.com/zahnah/study-app/internal/config"
 	"github.com/zahnah/study-app/internal/handlers"
 	"github.com/zahnah/study-app/internal/helpers"
+	"github.com/zahnah/study-app/internal/mailer"
 	"github.com/zahnah/study-app/internal/models"
 	"github.com/zahnah/study-app/internal/render"
+	"github.com/zahnah/study-app/internal/storage"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -38,10 +41,20 @@ func main() {
 			_, _ = fmt.Fprintf(os.Stderr, "Unable to close database: %v\n", err)
 		}
 	}(db)
-	defer close(app.MailChan)
 
-	fmt.Println("Starting mail listener...")
-	listenForMain()
+	defer func(mail mailer.Mailer) {
+		_ = mail.Close()
+	}(app.Mailer)
+
+	fmt.Println("Starting mail workers...")
+	wrapQueuedMail(handlers.Repo.DB)
+	listenForMail(handlers.Repo.DB, app.Mailer)
+
+	fmt.Println("Starting hold sweeper...")
+	listenForExpiredHolds(handlers.Repo.DB)
+
+	fmt.Println("Starting calendar imports...")
+	listenForICalImports(handlers.Repo)
 
 	fmt.Println(fmt.Sprintf("Starting application on port: %s", portNumber))
 
@@ -58,12 +71,25 @@ func run() (*sql.DB, error) {
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
-	gob.Register(map[string]int{})
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
+	app.MailQueued = make(chan struct{}, 1)
 
 	app.InProduction = false
+	app.HoldDuration = 15 * time.Minute
+	app.Storage = storage.NewLocalDisk("./static/uploads", "/static/uploads")
+	app.PropertyName = envOr("PROPERTY_NAME", "Fort Smythe Bed and Breakfast")
+	app.PropertyAddress = os.Getenv("PROPERTY_ADDRESS")
+	app.BaseURL = strings.TrimSuffix(envOr("BASE_URL", "http://localhost"+portNumber), "/")
+
+	mailFrom, mailConfig, err := mailConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	app.MailFrom = mailFrom
+	app.Mailer, err = mailer.New(mailConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -88,6 +114,12 @@ func run() (*sql.DB, error) {
 	app.TemplateCache = tc
 	app.UseCache = false
 
+	app.EmailTemplateDir = "./email-templates"
+	app.EmailTemplateCache, app.EmailTextTemplateCache, err = render.CreateEmailTemplateCache(app.EmailTemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
 	log.Println("connection to database..")
 	os.LookupEnv("DATABASE_URL")
 	fmt.Println("db: ", os.Getenv("DATABASE_URL"))
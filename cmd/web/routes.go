@@ -19,11 +19,14 @@ func routes() http.Handler {
 	mux.Get("/generals", handlers.Repo.Generals)
 	mux.Get("/contact", handlers.Repo.Contact)
 	mux.Get("/majors", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{id}", handlers.Repo.Room)
 
 	mux.Get("/search-availability", handlers.Repo.SearchAvailability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.PostAvailabilityJSON)
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+	mux.Post("/choose-room", handlers.Repo.PostChooseRooms)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
 
 	mux.Get("/user/login", handlers.Repo.Login)
@@ -34,6 +37,14 @@ func routes() http.Handler {
 	mux.Post("/make-reservation", handlers.Repo.PostMakeReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
+	mux.Get("/my-reservation", handlers.Repo.MyReservation)
+	mux.Post("/my-reservation", handlers.Repo.PostMyReservation)
+	mux.Get("/my-reservation/manage", handlers.Repo.MyReservationManage)
+	mux.Post("/my-reservation/dates", handlers.Repo.PostMyReservationDates)
+	mux.Post("/my-reservation/cancel", handlers.Repo.PostMyReservationCancel)
+
+	mux.Get("/ical/rooms/{id}.ics", handlers.Repo.RoomICalFeed)
+
 	mux.Route("/admin", func(r chi.Router) {
 		// temporary disable
 		// r.Use(Auth)
@@ -42,10 +53,59 @@ func routes() http.Handler {
 		r.Get("/reservations/new", handlers.Repo.AdminReservationsNew)
 		r.Get("/reservations/calendar", handlers.Repo.AdminReservationsCalendar)
 		r.Post("/reservations/calendar", handlers.Repo.AdminPostReservationsCalendar)
+		r.Get("/reservations/calendar-json", handlers.Repo.AdminReservationsCalendarJSON)
+		r.Get("/reservations/timeline", handlers.Repo.AdminReservationsTimeline)
+		r.Get("/blocks", handlers.Repo.AdminBlocks)
+		r.Post("/blocks", handlers.Repo.AdminPostBlock)
+		r.Post("/blocks/remove", handlers.Repo.AdminRemoveBlocks)
+		r.Post("/blocks/{id}/delete", handlers.Repo.AdminDeleteBlock)
+		r.Get("/ical-imports", handlers.Repo.AdminICalImports)
+		r.Post("/ical-imports", handlers.Repo.AdminPostICalImport)
+		r.Post("/ical-imports/{id}/sync", handlers.Repo.AdminSyncICalImport)
+		r.Post("/ical-imports/{id}/delete", handlers.Repo.AdminDeleteICalImport)
+		r.Get("/mail-outbox", handlers.Repo.AdminMailOutbox)
+		r.Post("/mail-outbox/{id}/resend", handlers.Repo.AdminResendMail)
+		r.Get("/email-templates", handlers.Repo.AdminEmailTemplates)
+		r.Get("/email-templates/{name}", handlers.Repo.AdminEmailTemplate)
+		r.Post("/email-templates/{name}", handlers.Repo.AdminPostEmailTemplate)
+		r.Post("/email-templates/{name}/preview", handlers.Repo.AdminPreviewEmailTemplate)
+		r.Post("/email-templates/{name}/reset", handlers.Repo.AdminResetEmailTemplate)
 		r.Get("/reservations/{src}/{id}", handlers.Repo.AdminReservation)
 		r.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostReservation)
-		r.Post("/reservations/{src}/{id}/processed", handlers.Repo.AdminProcessedReservation)
-		r.Post("/reservations/{src}/{id}/delete", handlers.Repo.AdminDeleteReservation)
+		r.Post("/reservations/{src}/{id}/status", handlers.Repo.AdminReservationStatus)
+		r.Post("/reservations/{src}/{id}/cancel", handlers.Repo.AdminCancelReservation)
+		r.Post("/reservations/{src}/{id}/rooms/{stayID}", handlers.Repo.AdminReassignReservationRoom)
+		r.Post("/reservations/{src}/{id}/move", handlers.Repo.AdminMoveReservation)
+
+		r.Get("/rooms", handlers.Repo.AdminRooms)
+		r.Get("/rooms/{id}", handlers.Repo.AdminRoom)
+		r.Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+		r.Get("/rooms/{id}/calendar", handlers.Repo.AdminRoomCalendar)
+		r.Post("/rooms/{id}/ical-token", handlers.Repo.AdminRoomICalToken)
+		r.Post("/rooms/{id}/deactivate", handlers.Repo.AdminDeactivateRoom)
+		r.Post("/rooms/{id}/move", handlers.Repo.AdminMoveRoom)
+		r.Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhotos)
+		r.Post("/rooms/{id}/photos/{photoID}/delete", handlers.Repo.AdminDeleteRoomPhoto)
+
+		r.Get("/room-types", handlers.Repo.AdminRoomTypes)
+		r.Get("/room-types/{id}", handlers.Repo.AdminRoomType)
+		r.Post("/room-types/{id}", handlers.Repo.AdminPostRoomType)
+		r.Post("/room-types/{id}/delete", handlers.Repo.AdminDeleteRoomType)
+
+		r.Get("/rates", handlers.Repo.AdminRates)
+		r.Get("/rates/{id}", handlers.Repo.AdminRate)
+		r.Post("/rates/{id}", handlers.Repo.AdminPostRate)
+		r.Post("/rates/{id}/delete", handlers.Repo.AdminDeleteRate)
+
+		r.Get("/stay-rules", handlers.Repo.AdminStayRules)
+		r.Get("/stay-rules/{id}", handlers.Repo.AdminStayRule)
+		r.Post("/stay-rules/{id}", handlers.Repo.AdminPostStayRule)
+		r.Post("/stay-rules/{id}/delete", handlers.Repo.AdminDeleteStayRule)
+
+		r.Get("/cancellation-policies", handlers.Repo.AdminCancellationPolicies)
+		r.Get("/cancellation-policies/{id}", handlers.Repo.AdminCancellationPolicy)
+		r.Post("/cancellation-policies/{id}", handlers.Repo.AdminPostCancellationPolicy)
+		r.Post("/cancellation-policies/{id}/delete", handlers.Repo.AdminDeleteCancellationPolicy)
 	})
 
 	fileServer := http.FileServer(http.Dir("./static"))
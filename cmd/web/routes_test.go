@@ -3,14 +3,11 @@ package main
 import (
 	"fmt"
 	"github.com/go-chi/chi/v5"
-	"github.com/zahnah/study-app/internal/config"
 	"testing"
 )
 
 func TestRoutes(t *testing.T) {
-	var app config.AppConfig
-
-	mux := routes(&app)
+	mux := routes()
 	switch v := mux.(type) {
 	case *chi.Mux:
 	default:
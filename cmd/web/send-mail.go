@@ -2,58 +2,151 @@ package main
 
 import (
 	"fmt"
-	mail "github.com/xhit/go-simple-mail/v2"
+	"github.com/zahnah/study-app/internal/mailer"
 	"github.com/zahnah/study-app/internal/models"
-	"log"
+	"github.com/zahnah/study-app/internal/render"
+	"github.com/zahnah/study-app/repository"
 	"os"
-	"strings"
+	"strconv"
 	"time"
 )
 
-func listenForMain() {
+const (
+	// mailWorkers is how many emails are sent at the same time
+	mailWorkers = 4
+	// mailPollInterval is how often the outbox is checked for mail that is due again
+	mailPollInterval = 30 * time.Second
+	// mailLease is how long a worker has to send a message before another one may try
+	mailLease = 5 * time.Minute
+	// mailMaxAttempts is how many times an email is tried before it is dead-lettered
+	mailMaxAttempts = 10
+	// mailRetryDelay is the wait after the first failure, it doubles with every attempt up to mailMaxRetryDelay
+	mailRetryDelay    = time.Minute
+	mailMaxRetryDelay = 6 * time.Hour
+)
+
+// listenForMail sends the mail of the outbox with a pool of workers. The outbox is checked when mail is
+// queued and every mailPollInterval for the retries that are due.
+func listenForMail(db repository.DatabaseRepo, mail mailer.Mailer) {
+	jobs := make(chan models.OutboxMail)
+	for i := 0; i < mailWorkers; i++ {
+		go func() {
+			for msg := range jobs {
+				deliverMail(db, mail, msg)
+			}
+		}()
+	}
+
 	go func() {
+		ticker := time.NewTicker(mailPollInterval)
+		defer ticker.Stop()
+
 		for {
+			mails, err := db.ClaimMail(mailWorkers, mailLease)
+			if err != nil {
+				errorLog.Println(err)
+			}
+			for _, msg := range mails {
+				jobs <- msg
+			}
+
+			// a full batch means more mail may be due already
+			if len(mails) == mailWorkers {
+				continue
+			}
+
 			select {
-			case msg := <-app.MailChan:
-				sendMessage(msg)
+			case <-ticker.C:
+			case <-app.MailQueued:
 			}
 		}
 	}()
 }
 
-func sendMessage(m models.MailData) {
-	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-
-	client, err := server.Connect()
+// wrapQueuedMail puts the content of the emails queued before the outbox stored whole emails in the layout,
+// so they go out like the others. The workers leave them alone until then.
+func wrapQueuedMail(db repository.DatabaseRepo) {
+	mails, err := db.UnwrappedMail()
 	if err != nil {
 		errorLog.Println(err)
+		return
 	}
 
-	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-
-	if m.Template == "" {
-		email.SetBody(mail.TextHTML, m.Content)
-	} else {
-		data, err := os.ReadFile(fmt.Sprintf("./email-templates/%s.html", m.Template))
+	data := &models.EmailData{PropertyName: app.PropertyName, PropertyAddress: app.PropertyAddress}
+	for _, msg := range mails {
+		content, err := render.EmailInLayout(msg.Mail.Content, data)
+		if err == nil {
+			err = db.WrapMail(msg.ID, content)
+		}
 		if err != nil {
-			app.ErrorLog.Println(err)
+			errorLog.Printf("Wrapping the queued email %d: %v", msg.ID, err)
 		}
-
-		mailTemplate := string(data)
-		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
 	}
+}
 
-	err = email.Send(client)
+// deliverMail sends an email of the outbox and records how it went
+func deliverMail(db repository.DatabaseRepo, mail mailer.Mailer, msg models.OutboxMail) {
+	sendErr := mail.Send(msg.Mail)
+
+	var err error
+	switch {
+	case sendErr == nil:
+		infoLog.Printf("Sent the email to %s", msg.Mail.To)
+		err = db.MarkMailSent(msg.ID)
+	case msg.Attempts >= mailMaxAttempts:
+		errorLog.Printf("Giving up on the email to %s after %d attempts: %v", msg.Mail.To, msg.Attempts, sendErr)
+		err = db.DeadLetterMail(msg.ID, sendErr.Error())
+	default:
+		errorLog.Printf("Sending the email to %s: %v", msg.Mail.To, sendErr)
+		err = db.RetryMail(msg.ID, sendErr.Error(), time.Now().Add(mailRetryBackoff(msg.Attempts)))
+	}
 	if err != nil {
 		errorLog.Println(err)
-	} else {
-		log.Println("Email sent")
 	}
 }
+
+// mailRetryBackoff is how long to wait before trying an email again after the attempts failed
+func mailRetryBackoff(attempts int) time.Duration {
+	delay := mailRetryDelay
+	for i := 1; i < attempts && delay < mailMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > mailMaxRetryDelay {
+		delay = mailMaxRetryDelay
+	}
+	return delay
+}
+
+// mailConfigFromEnv reads the sender address and the mailer from the environment. MAIL_DRIVER picks
+// smtp, file or memory; the file driver writes to MAIL_DIR and the smtp driver uses SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD and SMTP_ENCRYPTION (none, starttls or tls). Without them mail goes to
+// a local development server like MailHog.
+func mailConfigFromEnv() (string, mailer.Config, error) {
+	from := envOr("MAIL_FROM", "me@local.local")
+	cfg := mailer.Config{
+		Driver: envOr("MAIL_DRIVER", mailer.DriverSMTP),
+		SMTP: mailer.SMTPConfig{
+			Host:       envOr("SMTP_HOST", "localhost"),
+			Username:   os.Getenv("SMTP_USERNAME"),
+			Password:   os.Getenv("SMTP_PASSWORD"),
+			Encryption: envOr("SMTP_ENCRYPTION", mailer.EncryptionNone),
+		},
+		Dir: envOr("MAIL_DIR", "./mail"),
+	}
+
+	port, err := strconv.Atoi(envOr("SMTP_PORT", "1025"))
+	if err != nil || port <= 0 || port > 65535 {
+		return from, cfg, fmt.Errorf("SMTP_PORT must be a port number, got %q", os.Getenv("SMTP_PORT"))
+	}
+	cfg.SMTP.Port = port
+
+	return from, cfg, nil
+}
+
+// envOr returns the environment variable, or def when it isn't set
+func envOr(key, def string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return def
+}
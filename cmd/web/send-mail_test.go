@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/zahnah/study-app/internal/mailer"
+	"testing"
+	"time"
+)
+
+func TestMailRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, mailMaxRetryDelay},
+		{50, mailMaxRetryDelay},
+	}
+
+	for _, e := range tests {
+		if got := mailRetryBackoff(e.attempts); got != e.expected {
+			t.Errorf("after %d attempts: expected to wait %s, got %s", e.attempts, e.expected, got)
+		}
+	}
+}
+
+func TestMailConfigFromEnv(t *testing.T) {
+	t.Setenv("MAIL_FROM", "bookings@fort-smythe.com")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("SMTP_PORT", "587")
+	t.Setenv("SMTP_USERNAME", "bookings")
+	t.Setenv("SMTP_PASSWORD", "secret")
+	t.Setenv("SMTP_ENCRYPTION", mailer.EncryptionSTARTTLS)
+	t.Setenv("MAIL_DRIVER", mailer.DriverFile)
+	t.Setenv("MAIL_DIR", "/var/mail/bookings")
+
+	from, cfg, err := mailConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "bookings@fort-smythe.com" {
+		t.Errorf("unexpected sender %s", from)
+	}
+	expected := mailer.Config{
+		Driver: mailer.DriverFile,
+		SMTP: mailer.SMTPConfig{
+			Host:       "smtp.example.com",
+			Port:       587,
+			Username:   "bookings",
+			Password:   "secret",
+			Encryption: mailer.EncryptionSTARTTLS,
+		},
+		Dir: "/var/mail/bookings",
+	}
+	if cfg != expected {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+
+	t.Setenv("SMTP_PORT", "smtp")
+	_, _, err = mailConfigFromEnv()
+	if err == nil {
+		t.Error("expected an error for a port that isn't a number")
+	}
+}
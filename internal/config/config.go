@@ -2,17 +2,36 @@ package config
 
 import (
 	"github.com/alexedwards/scs/v2"
-	"github.com/zahnah/study-app/internal/models"
+	"github.com/zahnah/study-app/internal/mailer"
+	"github.com/zahnah/study-app/internal/storage"
 	"html/template"
 	"log"
+	texttemplate "text/template"
+	"time"
 )
 
 type AppConfig struct {
 	UseCache      bool
 	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	// EmailTemplateCache and EmailTextTemplateCache hold the HTML and plain text versions of the emails
+	// parsed from EmailTemplateDir
+	EmailTemplateDir       string
+	EmailTemplateCache     map[string]*template.Template
+	EmailTextTemplateCache map[string]*texttemplate.Template
+	InfoLog                *log.Logger
+	ErrorLog               *log.Logger
+	InProduction           bool
+	Session                *scs.SessionManager
+	// MailQueued wakes the mail workers when mail is put in the outbox
+	MailQueued chan struct{}
+	// MailFrom is the sender address of the emails
+	MailFrom     string
+	Mailer       mailer.Mailer
+	HoldDuration time.Duration
+	Storage      storage.Storage
+	// PropertyName and PropertyAddress tell guests where they are staying
+	PropertyName    string
+	PropertyAddress string
+	// BaseURL is where the site is reached, for the links in the emails
+	BaseURL string
 }
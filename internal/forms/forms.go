@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/asaskevich/govalidator"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Form struct {
@@ -57,3 +59,21 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+func (f *Form) IsDate(field string) bool {
+	_, err := time.Parse("2006-01-02", f.Get(field))
+	if err != nil {
+		f.Errors.Add(field, "Invalid date")
+		return false
+	}
+	return true
+}
+
+func (f *Form) IsInt(field string) bool {
+	_, err := strconv.Atoi(f.Get(field))
+	if err != nil {
+		f.Errors.Add(field, "This must be a whole number")
+		return false
+	}
+	return true
+}
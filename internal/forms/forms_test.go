@@ -121,3 +121,36 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("email shouldn't be a valid email")
 	}
 }
+
+func TestForm_IsDate(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("a", "2050-01-02")
+	postedData.Add("b", "02/01/2050")
+
+	form := New(postedData)
+
+	if !form.IsDate("a") {
+		t.Error("a should be a valid date")
+	}
+	if form.IsDate("b") {
+		t.Error("b shouldn't be a valid date")
+	}
+	if form.Errors.Get("b") != "Invalid date" {
+		t.Error("form has to have an error for b")
+	}
+}
+
+func TestForm_IsInt(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("a", "-15")
+	postedData.Add("b", "1.5")
+
+	form := New(postedData)
+
+	if !form.IsInt("a") {
+		t.Error("a should be a valid number")
+	}
+	if form.IsInt("b") {
+		t.Error("b shouldn't be a valid number")
+	}
+}
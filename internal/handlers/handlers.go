@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -9,14 +11,20 @@ import (
 	"github.com/zahnah/study-app/internal/config"
 	"github.com/zahnah/study-app/internal/forms"
 	"github.com/zahnah/study-app/internal/helpers"
+	"github.com/zahnah/study-app/internal/ical"
+	"github.com/zahnah/study-app/internal/images"
 	"github.com/zahnah/study-app/internal/models"
+	"github.com/zahnah/study-app/internal/pricing"
 	"github.com/zahnah/study-app/internal/render"
 	"github.com/zahnah/study-app/repository"
 	"github.com/zahnah/study-app/repository/dbrepo"
+	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
-	"regexp"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -55,22 +63,71 @@ func (m *Repository) About(writer http.ResponseWriter, r *http.Request) {
 	_ = render.Template(writer, *r, "about.page.gohtml", &models.TemplateData{})
 }
 
+// Generals is the old address of the first room's page
 func (m *Repository) Generals(writer http.ResponseWriter, r *http.Request) {
-	_ = render.Template(writer, *r, "generals.page.gohtml", &models.TemplateData{
-		IntMap: map[string]int{
-			"room_id": 1,
-		},
-	})
+	http.Redirect(writer, r, "/rooms/1", http.StatusMovedPermanently)
 }
 
 func (m *Repository) Contact(writer http.ResponseWriter, r *http.Request) {
 	_ = render.Template(writer, *r, "contact.page.gohtml", &models.TemplateData{})
 }
 
+// Majors is the old address of the second room's page
 func (m *Repository) Majors(writer http.ResponseWriter, r *http.Request) {
-	_ = render.Template(writer, *r, "majors.page.gohtml", &models.TemplateData{
+	http.Redirect(writer, r, "/rooms/2", http.StatusMovedPermanently)
+}
+
+// Rooms lists the rooms guests can book
+func (m *Repository) Rooms(writer http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	// a room type is shown once, by its first unit
+	var active []models.Room
+	shownTypes := make(map[int]bool)
+	for _, room := range rooms {
+		if !room.Active || shownTypes[room.RoomTypeID] {
+			continue
+		}
+		if room.HasType() {
+			shownTypes[room.RoomTypeID] = true
+		}
+		active = append(active, room)
+	}
+
+	_ = render.Template(writer, *r, "rooms.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"rooms": active,
+		},
+	})
+}
+
+// Room shows a room's page with its photos, amenities and the availability search
+func (m *Repository) Room(writer http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(writer, r)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(roomID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		http.NotFound(writer, r)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *r, "room.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"room": room,
+		},
 		IntMap: map[string]int{
-			"room_id": 2,
+			"room_id": room.ID,
 		},
 	})
 }
@@ -80,6 +137,8 @@ func (m *Repository) SearchAvailability(writer http.ResponseWriter, r *http.Requ
 }
 
 func (m *Repository) PostAvailability(writer http.ResponseWriter, r *http.Request) {
+	// a new search starts over, so the room picked before is free again
+	m.releaseHolds(r)
 
 	sd := r.Form.Get("start")
 	layout := "2006-01-02"
@@ -98,7 +157,9 @@ func (m *Repository) PostAvailability(writer http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	adults, children := guestCounts(r.Form)
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate, adults, children)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "cannot search for availability!")
 		http.Redirect(writer, r, "/", http.StatusTemporaryRedirect)
@@ -115,26 +176,72 @@ func (m *Repository) PostAvailability(writer http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	prices := make(map[int]models.PriceBreakdown)
+	for _, room := range rooms {
+		prices[room.ID], err = m.priceForRoom(room, startDate, endDate)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "cannot calculate the price!")
+			http.Redirect(writer, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["prices"] = prices
 
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
 	}
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	_ = render.Template(writer, *r, "choose-room.page.gohtml", &models.TemplateData{
 		Data: data,
+		StringMap: map[string]string{
+			"StartDate": sd,
+			"EndDate":   ed,
+			"guests":    res.Guests(),
+		},
 	})
 }
 
+// guestCounts reads the number of adults and children from a search form. A search is for
+// at least one adult; missing or invalid counts fall back to that.
+func guestCounts(values url.Values) (int, int) {
+	adults, err := strconv.Atoi(values.Get("adults"))
+	if err != nil || adults < 1 {
+		adults = 1
+	}
+
+	children, err := strconv.Atoi(values.Get("children"))
+	if err != nil || children < 0 {
+		children = 0
+	}
+
+	return adults, children
+}
+
+// priceForRoom calculates the price of a stay taking the room's rate rules into account
+func (m *Repository) priceForRoom(room models.Room, start, end time.Time) (models.PriceBreakdown, error) {
+	rules, err := m.DB.GetRateRulesForRoomByDate(room.ID, start, end)
+	if err != nil {
+		return models.PriceBreakdown{}, err
+	}
+	return pricing.Calculate(room, start, end, rules), nil
+}
+
 type jsonResponse struct {
 	OK        bool   `json:"ok"`
 	Message   string `json:"message"`
 	RoomID    int    `json:"room_id"`
 	StartDate string `json:"start_date"`
 	EndDate   string `json:"end_date"`
+	Reason    string `json:"reason,omitempty"`
+	Adults    int    `json:"adults"`
+	Children  int    `json:"children"`
 }
 
 func (m *Repository) PostAvailabilityJSON(writer http.ResponseWriter, r *http.Request) {
@@ -155,12 +262,23 @@ func (m *Repository) PostAvailabilityJSON(writer http.ResponseWriter, r *http.Re
 	sd := r.Form.Get("start")
 	ed := r.Form.Get("end")
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	adults, children := guestCounts(r.Form)
 
 	layout := "2006-01-02"
 	startDate, _ := time.Parse(layout, sd)
 	endDate, _ := time.Parse(layout, ed)
 
-	available, err := m.DB.SearchAvailabilityByRoomID(startDate, endDate, roomID)
+	m.releaseHolds(r)
+
+	available, reason, err := m.DB.SearchAvailabilityByRoomID(startDate, endDate, roomID)
+	if err == nil && available {
+		var room models.Room
+		room, err = m.DB.GetRoomById(roomID)
+		if err == nil && !room.Fits(adults, children) {
+			available = false
+			reason = fmt.Sprintf("The room sleeps at most %s", room.Capacity())
+		}
+	}
 
 	if err != nil {
 		resp := jsonResponse{
@@ -180,6 +298,9 @@ func (m *Repository) PostAvailabilityJSON(writer http.ResponseWriter, r *http.Re
 		RoomID:    roomID,
 		StartDate: sd,
 		EndDate:   ed,
+		Reason:    reason,
+		Adults:    adults,
+		Children:  children,
 	}
 	if !available {
 		resp.Message = "Not Available"
@@ -200,16 +321,15 @@ func (m *Repository) MakeReservation(writer http.ResponseWriter, request *http.R
 		return
 	}
 
-	sd := res.StartDate.Format("2006-01-02")
-	ed := res.EndDate.Format("2006-01-02")
-
-	room, err := m.DB.GetRoomById(res.RoomID)
+	prices, err := m.priceReservation(&res)
 	if err != nil {
 		m.App.Session.Put(request.Context(), "error", "cannot find room!")
 		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	res.Room = room
+
+	sd := res.StartDate.Format("2006-01-02")
+	ed := res.EndDate.Format("2006-01-02")
 
 	m.App.Session.Put(request.Context(), "reservation", res)
 
@@ -217,14 +337,50 @@ func (m *Repository) MakeReservation(writer http.ResponseWriter, request *http.R
 		Form: forms.New(nil),
 		Data: map[string]interface{}{
 			"reservation": res,
+			"prices":      prices,
 		},
 		StringMap: map[string]string{
-			"StartDate": sd,
-			"EndDate":   ed,
+			"StartDate":       sd,
+			"EndDate":         ed,
+			"hold_expires_at": m.App.Session.GetString(request.Context(), "hold_expires_at"),
 		},
 	})
 }
 
+// capacityError tells the guest how many people the rooms of the reservation sleep
+func capacityError(res models.Reservation) string {
+	if res.IsGroup() {
+		return fmt.Sprintf("The rooms you picked sleep at most %s together", res.Capacity())
+	}
+	return fmt.Sprintf("The room sleeps at most %s", res.Capacity())
+}
+
+// priceReservation loads every room of the reservation and prices its stay, returning the
+// breakdowns in the order of the rooms
+func (m *Repository) priceReservation(res *models.Reservation) ([]models.PriceBreakdown, error) {
+	rooms := res.RoomStays()
+	prices := make([]models.PriceBreakdown, len(rooms))
+
+	for i := range rooms {
+		room, err := m.DB.GetRoomById(rooms[i].RoomID)
+		if err != nil {
+			return nil, err
+		}
+
+		prices[i], err = m.priceForRoom(room, rooms[i].StartDate, rooms[i].EndDate)
+		if err != nil {
+			return nil, err
+		}
+
+		rooms[i].Room = room
+		rooms[i].TotalPrice = prices[i].Total
+	}
+
+	res.Rooms = rooms
+	res.SummarizeRooms()
+	return prices, nil
+}
+
 func (m *Repository) PostMakeReservation(writer http.ResponseWriter, r *http.Request) {
 
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
@@ -246,9 +402,25 @@ func (m *Repository) PostMakeReservation(writer http.ResponseWriter, r *http.Req
 	reservation.Email = r.Form.Get("email")
 	reservation.Phone = r.Form.Get("phone")
 
+	prices, err := m.priceReservation(&reservation)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot calculate the price!")
+		http.Redirect(writer, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// the party may have been changed since the room was held
+	if !reservation.Fits() {
+		m.releaseHolds(r)
+		m.App.Session.Put(r.Context(), "error", capacityError(reservation))
+		http.Redirect(writer, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
 	form := forms.New(r.PostForm)
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
+	data["prices"] = prices
 
 	// form.Has("first_name", r)
 	form.Required("first_name", "last_name", "email")
@@ -262,53 +434,46 @@ func (m *Repository) PostMakeReservation(writer http.ResponseWriter, r *http.Req
 		})
 		return
 	} else {
-		newID, err := m.DB.InsertReservation(reservation)
+		reservation.ConfirmationCode, err = helpers.NewConfirmationCode()
 		if err != nil {
 			m.App.Session.Put(r.Context(), "error", "cannot insert a reservation!")
 			http.Redirect(writer, r, "/", http.StatusTemporaryRedirect)
 			return
 		}
-		reservation.ID = newID
 
-		restriction := models.RoomRestriction{
-			RestrictionID: 1,
-			ReservationID: newID,
-			RoomID:        reservation.RoomID,
-			StartDate:     reservation.StartDate,
-			EndDate:       reservation.EndDate,
+		// the emails are queued with the reservation, so they go out only if it is stored
+		var mails []models.MailData
+		data := m.emailData(reservation)
+		if guestMail, ok := m.renderMail(reservation.Email, "reservation-confirmation", data); ok {
+			guestMail.Attachments = []models.MailAttachment{m.reservationInvitation(reservation)}
+			mails = append(mails, guestMail)
+		}
+		if ownerMail, ok := m.renderMail("owner@email.local", "reservation-new", data); ok {
+			mails = append(mails, ownerMail)
 		}
 
-		_, err = m.DB.InsertRoomRestriction(restriction)
-		if err != nil {
-			m.App.Session.Put(r.Context(), "error", "cannot insert a reservation's restriction!")
+		holdIDs, _ := m.App.Session.Get(r.Context(), "hold_ids").([]int)
+		newID, err := m.DB.InsertReservationWithRestriction(reservation, holdIDs, mails)
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			m.releaseHolds(r)
+			m.App.Session.Put(r.Context(), "error", "Sorry, a room has just been booked for these dates. Please search again.")
+			http.Redirect(writer, r, "/search-availability", http.StatusSeeOther)
+			return
+		} else if errors.Is(err, repository.ErrStayRuleViolated) {
+			m.releaseHolds(r)
+			m.App.Session.Put(r.Context(), "error", "Sorry, these dates no longer meet the stay rules of a room. Please search again.")
+			http.Redirect(writer, r, "/search-availability", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			m.App.Session.Put(r.Context(), "error", "cannot insert a reservation!")
 			http.Redirect(writer, r, "/", http.StatusTemporaryRedirect)
 			return
 		}
-
-		// sending email notification
-		htmlMessage := fmt.Sprintf(`<b>Reservation confirmation</b><br>
-Dear %s:, <br>
-This is confirm your reservation from %s to %s
-`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-		msg := models.MailData{
-			To:       reservation.Email,
-			From:     "me@local.local",
-			Subject:  "Reservation Confirmation",
-			Content:  htmlMessage,
-			Template: "basic",
-		}
-		m.App.MailChan <- msg
-
-		htmlMessage = fmt.Sprintf(`<b>Reservation confirmation</b><br>
-A reservation has been made for %s from %s to %s
-`, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-		msg = models.MailData{
-			To:      "owner@email.local",
-			From:    "me@local.local",
-			Subject: "Reservation Confirmation",
-			Content: htmlMessage,
-		}
-		m.App.MailChan <- msg
+		reservation.ID = newID
+		// the holds are part of the reservation now
+		m.App.Session.Remove(r.Context(), "hold_ids")
+		m.App.Session.Remove(r.Context(), "hold_expires_at")
+		m.wakeMailWorkers()
 
 		m.App.Session.Put(r.Context(), "flash", "Data stored successfully")
 		m.App.Session.Put(r.Context(), "reservation", reservation)
@@ -317,6 +482,81 @@ A reservation has been made for %s from %s to %s
 
 }
 
+// reservationInvitation is a calendar file with an all day event for each room of the reservation, so the guest
+// can add the stay to their calendar
+func (m *Repository) reservationInvitation(reservation models.Reservation) models.MailAttachment {
+	calendar := ical.Calendar{
+		ProdID: icalProdID,
+		Method: "PUBLISH",
+	}
+	now := time.Now()
+	for _, stay := range reservation.RoomStays() {
+		location := stay.Room.RoomName
+		if m.App.PropertyAddress != "" {
+			location = fmt.Sprintf("%s, %s", stay.Room.RoomName, m.App.PropertyAddress)
+		}
+		calendar.Events = append(calendar.Events, ical.Event{
+			UID:         fmt.Sprintf("reservation-%s-room-%d@study-app", reservation.ConfirmationCode, stay.RoomID),
+			Summary:     fmt.Sprintf("Stay at %s", m.App.PropertyName),
+			Description: fmt.Sprintf("%s\nConfirmation code: %s", stay.Room.RoomName, reservation.ConfirmationCode),
+			Location:    location,
+			Start:       stay.StartDate,
+			End:         stay.EndDate,
+			AllDay:      true,
+			Stamp:       now,
+		})
+	}
+
+	return models.MailAttachment{
+		Name:        "reservation.ics",
+		ContentType: "text/calendar; charset=utf-8; method=PUBLISH",
+		Data:        calendar.Bytes(),
+	}
+}
+
+// emailData is what the emails about the reservation are rendered with
+func (m *Repository) emailData(reservation models.Reservation) *models.EmailData {
+	adminURL := fmt.Sprintf("%s/admin/reservations/new", m.App.BaseURL)
+	if reservation.ID != 0 {
+		adminURL = fmt.Sprintf("%s/admin/reservations/all/%d", m.App.BaseURL, reservation.ID)
+	}
+
+	return &models.EmailData{
+		Reservation:     reservation,
+		PropertyName:    m.App.PropertyName,
+		PropertyAddress: m.App.PropertyAddress,
+		ManageURL:       fmt.Sprintf("%s/my-reservation", m.App.BaseURL),
+		AdminURL:        adminURL,
+	}
+}
+
+// renderMail renders the email called name for the address to, in the owner's wording when there is one
+// and falling back on the files otherwise. A failure is only logged, the email is left out rather than
+// failing the change it tells about.
+func (m *Repository) renderMail(to, name string, data *models.EmailData) (models.MailData, bool) {
+	var mail models.MailData
+	email, err := m.DB.GetEmailTemplate(name)
+	if err == nil {
+		mail, err = render.EmailFromTemplate(email, data)
+		if err != nil {
+			m.App.ErrorLog.Printf("can't render the reworded email %s, using the default: %v", name, err)
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		m.App.ErrorLog.Printf("can't get the wording of the email %s, using the default: %v", name, err)
+	}
+
+	if err != nil {
+		mail, err = render.Email(name, data)
+	}
+	if err != nil {
+		m.App.ErrorLog.Printf("can't render the email %s: %v", name, err)
+		return mail, false
+	}
+	mail.To = to
+	mail.From = m.App.MailFrom
+	return mail, true
+}
+
 func (m *Repository) ReservationSummary(writer http.ResponseWriter, r *http.Request) {
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
@@ -356,6 +596,121 @@ func (m *Repository) ChooseRoom(writer http.ResponseWriter, request *http.Reques
 	}
 
 	res.RoomID = roomID
+	res.Rooms = nil
+
+	if !m.holdRooms(writer, request, &res) {
+		return
+	}
+	m.App.Session.Put(request.Context(), "reservation", res)
+
+	http.Redirect(writer, request, "/make-reservation", http.StatusSeeOther)
+}
+
+// PostChooseRooms books the rooms picked on the choose-room page, each for its own dates. Rooms of a type
+// can be booked more than once.
+func (m *Repository) PostChooseRooms(writer http.ResponseWriter, request *http.Request) {
+	res, ok := m.App.Session.Get(request.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(request.Context(), "error", "cannot get reservation from session")
+		http.Redirect(writer, request, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	// the rooms held for an earlier pick would otherwise count against picking them again
+	m.releaseHolds(request)
+
+	res.Rooms = nil
+	for _, value := range request.PostForm["rooms"] {
+		roomID, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+
+		startDate, err := time.Parse("2006-01-02", request.PostForm.Get(fmt.Sprintf("start_%d", roomID)))
+		if err != nil {
+			startDate = res.StartDate
+		}
+		endDate, err := time.Parse("2006-01-02", request.PostForm.Get(fmt.Sprintf("end_%d", roomID)))
+		if err != nil {
+			endDate = res.EndDate
+		}
+
+		if !endDate.After(startDate) {
+			m.App.Session.Put(request.Context(), "error", "The departure has to be after the arrival")
+			http.Redirect(writer, request, "/search-availability", http.StatusSeeOther)
+			return
+		}
+
+		available, reason, err := m.DB.SearchAvailabilityByRoomID(startDate, endDate, roomID)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		if !available {
+			if reason == "" {
+				reason = "One of the rooms is not available for the dates you picked"
+			}
+			m.App.Session.Put(request.Context(), "error", reason)
+			http.Redirect(writer, request, "/search-availability", http.StatusSeeOther)
+			return
+		}
+
+		// several rooms of a type each get their own unit when they are held
+		count, err := strconv.Atoi(request.PostForm.Get(fmt.Sprintf("count_%d", roomID)))
+		if err != nil || count < 1 {
+			count = 1
+		}
+		if count > 1 {
+			units, err := m.DB.GetFreeUnits(roomID, startDate, endDate, 0)
+			if err != nil {
+				helpers.ServerError(writer, err)
+				return
+			}
+			if count > len(units)+1 {
+				m.App.Session.Put(request.Context(), "error", "Sorry, there aren't that many rooms of this type free for these dates. Please search again.")
+				http.Redirect(writer, request, "/search-availability", http.StatusSeeOther)
+				return
+			}
+		}
+
+		room, err := m.DB.GetRoomById(roomID)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+
+		for i := 0; i < count; i++ {
+			res.Rooms = append(res.Rooms, models.ReservationRoom{
+				RoomID:    roomID,
+				StartDate: startDate,
+				EndDate:   endDate,
+				Room:      room,
+			})
+		}
+	}
+
+	if len(res.Rooms) == 0 {
+		m.App.Session.Put(request.Context(), "error", "Choose at least one room")
+		http.Redirect(writer, request, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	res.SummarizeRooms()
+
+	if !res.Fits() {
+		m.App.Session.Put(request.Context(), "error", capacityError(res))
+		http.Redirect(writer, request, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	if !m.holdRooms(writer, request, &res) {
+		return
+	}
 	m.App.Session.Put(request.Context(), "reservation", res)
 
 	http.Redirect(writer, request, "/make-reservation", http.StatusSeeOther)
@@ -370,19 +725,104 @@ func (m *Repository) BookRoom(writer http.ResponseWriter, request *http.Request)
 
 	sd, _ := time.Parse("2006-01-02", startDate)
 	ed, _ := time.Parse("2006-01-02", endDate)
+	adults, children := guestCounts(request.URL.Query())
+
+	// the link may be stale or hand-made, so the room's stay rules and restrictions are checked again
+	available, reason, err := m.DB.SearchAvailabilityByRoomID(sd, ed, roomID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	if !available {
+		if reason == "" {
+			reason = "The room is not available for the dates you picked"
+		}
+		m.App.Session.Put(request.Context(), "error", reason)
+		http.Redirect(writer, request, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(roomID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	if !room.Fits(adults, children) {
+		m.App.Session.Put(request.Context(), "error", fmt.Sprintf("The room sleeps at most %s", room.Capacity()))
+		http.Redirect(writer, request, "/search-availability", http.StatusSeeOther)
+		return
+	}
 
 	var res = models.Reservation{
 		StartDate: sd,
 		EndDate:   ed,
 		RoomID:    roomID,
+		Adults:    adults,
+		Children:  children,
 	}
 
+	if !m.holdRooms(writer, request, &res) {
+		return
+	}
 	m.App.Session.Put(request.Context(), "reservation", res)
 
 	http.Redirect(writer, request, "/make-reservation", http.StatusTemporaryRedirect)
 	return
 }
 
+// holdRooms keeps the reservation's rooms free for the guest while they fill in the booking form, replacing
+// any previous holds. A room of a type may be swapped for the unit that could be held. When a room has been
+// taken in the meantime the guest is sent back to the search.
+func (m *Repository) holdRooms(writer http.ResponseWriter, request *http.Request, res *models.Reservation) bool {
+	m.releaseHolds(request)
+
+	expiresAt := time.Now().Add(m.App.HoldDuration)
+
+	var holdIDs []int
+	for i, room := range res.RoomStays() {
+		hold, err := m.DB.InsertHold(room.RoomID, room.StartDate, room.EndDate, expiresAt)
+		if err != nil {
+			m.App.Session.Put(request.Context(), "hold_ids", holdIDs)
+			m.releaseHolds(request)
+		}
+
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			m.App.Session.Put(request.Context(), "error", "Sorry, a room has just been taken for these dates. Please search again.")
+			http.Redirect(writer, request, "/search-availability", http.StatusSeeOther)
+			return false
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return false
+		}
+
+		holdIDs = append(holdIDs, hold.ID)
+
+		if len(res.Rooms) > 0 {
+			res.Rooms[i].RoomID = hold.RoomID
+		} else {
+			res.RoomID = hold.RoomID
+		}
+	}
+	res.SummarizeRooms()
+
+	m.App.Session.Put(request.Context(), "hold_ids", holdIDs)
+	m.App.Session.Put(request.Context(), "hold_expires_at", expiresAt.Format("15:04"))
+	return true
+}
+
+// releaseHolds gives up the rooms the guest is holding, if any
+func (m *Repository) releaseHolds(request *http.Request) {
+	holdIDs, _ := m.App.Session.Pop(request.Context(), "hold_ids").([]int)
+	m.App.Session.Remove(request.Context(), "hold_expires_at")
+
+	for _, holdID := range holdIDs {
+		err := m.DB.DeleteHold(holdID)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
 type LoginForm struct {
 	Email    string
 	Password string
@@ -459,7 +899,8 @@ func (m *Repository) AdminDashboard(writer http.ResponseWriter, request *http.Re
 }
 
 func (m *Repository) AdminReservations(writer http.ResponseWriter, request *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	status := statusFilter(request)
+	reservations, err := m.DB.AllReservations(status)
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
@@ -468,12 +909,17 @@ func (m *Repository) AdminReservations(writer http.ResponseWriter, request *http
 	_ = render.Template(writer, *request, "admin-reservations.page.gohtml", &models.TemplateData{
 		Data: map[string]interface{}{
 			"reservations": reservations,
+			"statuses":     models.ReservationStatuses,
+		},
+		StringMap: map[string]string{
+			"status": string(status),
 		},
 	})
 }
 
 func (m *Repository) AdminReservationsNew(writer http.ResponseWriter, request *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+	status := statusFilter(request)
+	reservations, err := m.DB.AllNewReservations(status)
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
@@ -482,10 +928,23 @@ func (m *Repository) AdminReservationsNew(writer http.ResponseWriter, request *h
 	_ = render.Template(writer, *request, "admin-reservations-new.page.gohtml", &models.TemplateData{
 		Data: map[string]interface{}{
 			"reservations": reservations,
+			"statuses":     models.OpenReservationStatuses,
+		},
+		StringMap: map[string]string{
+			"status": string(status),
 		},
 	})
 }
 
+// statusFilter reads the status the admin lists are filtered by, unknown statuses show everything
+func statusFilter(request *http.Request) models.ReservationStatus {
+	status := models.ReservationStatus(request.URL.Query().Get("status"))
+	if !status.Valid() {
+		return ""
+	}
+	return status
+}
+
 func (m *Repository) AdminReservationsCalendar(writer http.ResponseWriter, request *http.Request) {
 
 	now := time.Now()
@@ -518,41 +977,34 @@ func (m *Repository) AdminReservationsCalendar(writer http.ResponseWriter, reque
 		return
 	}
 
-	data := map[string]interface{}{
-		"now":   now,
-		"rooms": rooms,
+	roomTypes, err := m.DB.AllRoomTypes()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
 	}
 
-	for _, room := range rooms {
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
+	calendar, err := m.buildCalendar(rooms, roomTypes, firstOfMonth, lastOfMonth)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
 
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-02")] = 0
-			blockMap[d.Format("2006-01-02")] = 0
-			blockMap[d.Format("2006-01-02")] = 0
-		}
+	data := map[string]interface{}{
+		"now":        now,
+		"rooms":      rooms,
+		"room_types": roomTypes,
+		"group_map":  calendar.Groups,
+	}
 
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, firstOfMonth, lastOfMonth)
-		if err != nil {
-			helpers.ServerError(writer, err)
-			return
-		}
+	for _, room := range calendar.Rooms {
+		data[fmt.Sprintf("reservation_map_%d", room.ID)] = room.Reservations
+		data[fmt.Sprintf("block_map_%d", room.ID)] = room.Blocks
+		data[fmt.Sprintf("block_note_map_%d", room.ID)] = room.BlockNotes
+		data[fmt.Sprintf("version_%d", room.ID)] = room.Version
+	}
 
-		for _, restriction := range restrictions {
-			for d := restriction.StartDate; d.Before(restriction.EndDate) == true; d = d.AddDate(0, 0, 1) {
-				if restriction.ReservationID > 0 {
-					reservationMap[d.Format("2006-01-02")] = restriction.ReservationID
-				} else {
-					blockMap[d.Format("2006-01-02")] = restriction.ID
-				}
-			}
-		}
-
-		data[fmt.Sprintf("reservation_map_%d", room.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", room.ID)] = blockMap
-
-		m.App.Session.Put(request.Context(), fmt.Sprintf("block_map_%d", room.ID), blockMap)
+	for roomTypeID, freeMap := range calendar.FreeUnits {
+		data[fmt.Sprintf("free_units_map_%d", roomTypeID)] = freeMap
 	}
 
 	_ = render.Template(writer, *request, "admin-reservations-calendar.page.gohtml", &models.TemplateData{
@@ -569,6 +1021,249 @@ func (m *Repository) AdminReservationsCalendar(writer http.ResponseWriter, reque
 	})
 }
 
+// calendarRoom is a room's row of the admin calendar, its days are keyed by date
+type calendarRoom struct {
+	ID           int               `json:"id"`
+	Name         string            `json:"name"`
+	RoomTypeID   int               `json:"room_type_id,omitempty"`
+	Active       bool              `json:"active"`
+	Reservations map[string]int    `json:"reservations"`
+	Blocks       map[string]int    `json:"blocks"`
+	BlockNotes   map[string]string `json:"block_notes"`
+	Version      string            `json:"version"`
+}
+
+// calendarWindow holds what the admin calendar shows from Start to End, inclusive: the reservation and the
+// block of every room per day, how many rooms each reservation books and the free units of each room type per day
+type calendarWindow struct {
+	Start     string                 `json:"start"`
+	End       string                 `json:"end"`
+	Rooms     []calendarRoom         `json:"rooms"`
+	Groups    map[int]int            `json:"groups"`
+	FreeUnits map[int]map[string]int `json:"free_units"`
+}
+
+// maxCalendarDays limits the window of the calendar JSON, a year view of a leap year being the longest
+const maxCalendarDays = 366
+
+// buildCalendar collects the reservations and owner blocks of the rooms from first to last, inclusive
+func (m *Repository) buildCalendar(rooms []models.Room, roomTypes []models.RoomType, first, last time.Time) (calendarWindow, error) {
+	first = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.UTC)
+	last = time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, time.UTC)
+
+	calendar := calendarWindow{
+		Start:     first.Format("2006-01-02"),
+		End:       last.Format("2006-01-02"),
+		Rooms:     []calendarRoom{},
+		Groups:    make(map[int]int),
+		FreeUnits: make(map[int]map[string]int),
+	}
+
+	// rooms of the same booking seen in the window, so grouped reservations can be marked
+	reservationRooms := make(map[int]map[int]bool)
+
+	// units of each room type taken by a reservation or a block, per day
+	takenUnits := make(map[int]map[string]int)
+
+	for _, room := range rooms {
+		row := calendarRoom{
+			ID:           room.ID,
+			Name:         room.RoomName,
+			RoomTypeID:   room.RoomTypeID,
+			Active:       room.Active,
+			Reservations: make(map[string]int),
+			Blocks:       make(map[string]int),
+			BlockNotes:   make(map[string]string),
+		}
+
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, first, last)
+		if err != nil {
+			return calendar, err
+		}
+		row.Version = models.RestrictionsVersion(restrictions)
+
+		for _, restriction := range restrictions {
+			if restriction.RestrictionID == models.RestrictionHold {
+				continue
+			}
+			for d := restriction.StartDate; d.Before(restriction.EndDate); d = d.AddDate(0, 0, 1) {
+				if d.Before(first) || d.After(last) {
+					continue
+				}
+				day := d.Format("2006-01-02")
+				if restriction.ReservationID > 0 {
+					row.Reservations[day] = restriction.ReservationID
+					if reservationRooms[restriction.ReservationID] == nil {
+						reservationRooms[restriction.ReservationID] = make(map[int]bool)
+					}
+					reservationRooms[restriction.ReservationID][room.ID] = true
+				} else {
+					row.Blocks[day] = restriction.ID
+					row.BlockNotes[day] = restriction.Note
+				}
+			}
+		}
+
+		if room.HasType() && room.Active {
+			if takenUnits[room.RoomTypeID] == nil {
+				takenUnits[room.RoomTypeID] = make(map[string]int)
+			}
+			for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+				day := d.Format("2006-01-02")
+				if row.Reservations[day] > 0 || row.Blocks[day] > 0 {
+					takenUnits[room.RoomTypeID][day]++
+				}
+			}
+		}
+
+		calendar.Rooms = append(calendar.Rooms, row)
+	}
+
+	for reservationID, roomIDs := range reservationRooms {
+		calendar.Groups[reservationID] = len(roomIDs)
+	}
+
+	for _, roomType := range roomTypes {
+		freeMap := make(map[string]int)
+		for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+			day := d.Format("2006-01-02")
+			freeMap[day] = roomType.Units - takenUnits[roomType.ID][day]
+		}
+		calendar.FreeUnits[roomType.ID] = freeMap
+	}
+
+	return calendar, nil
+}
+
+// AdminReservationsCalendarJSON returns the calendar of all rooms, or of the room_id one, from start to end
+func (m *Repository) AdminReservationsCalendarJSON(writer http.ResponseWriter, request *http.Request) {
+	writeError := func(status int, message string) {
+		out, _ := json.MarshalIndent(jsonResponse{OK: false, Message: message}, "", "     ")
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(status)
+		_, _ = writer.Write(out)
+	}
+
+	first, err := time.Parse("2006-01-02", request.URL.Query().Get("start"))
+	if err != nil {
+		writeError(http.StatusBadRequest, "start has to be a date")
+		return
+	}
+	last, err := time.Parse("2006-01-02", request.URL.Query().Get("end"))
+	if err != nil {
+		writeError(http.StatusBadRequest, "end has to be a date")
+		return
+	}
+	if last.Before(first) || last.Sub(first) >= maxCalendarDays*24*time.Hour {
+		writeError(http.StatusBadRequest, fmt.Sprintf("end has to be within %d days after start", maxCalendarDays))
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		writeError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if roomID := request.URL.Query().Get("room_id"); roomID != "" {
+		var selected []models.Room
+		for _, room := range rooms {
+			if strconv.Itoa(room.ID) == roomID {
+				selected = append(selected, room)
+			}
+		}
+		if len(selected) == 0 {
+			writeError(http.StatusNotFound, "Room not found")
+			return
+		}
+		rooms = selected
+	}
+
+	roomTypes, err := m.DB.AllRoomTypes()
+	if err != nil {
+		writeError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	calendar, err := m.buildCalendar(rooms, roomTypes, first, last)
+	if err != nil {
+		writeError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	out, _ := json.MarshalIndent(calendar, "", "     ")
+	writer.Header().Set("Content-Type", "application/json")
+	_, _ = writer.Write(out)
+}
+
+// timelineMonths are the lengths the timeline can show
+var timelineMonths = []int{2, 3, 6}
+
+// AdminReservationsTimeline shows all rooms over several months, the page loads the days from
+// AdminReservationsCalendarJSON and scrolls a month at a time
+func (m *Repository) AdminReservationsTimeline(writer http.ResponseWriter, request *http.Request) {
+	now := time.Now()
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if request.URL.Query().Get("y") != "" && request.URL.Query().Get("m") != "" {
+		year, _ := strconv.Atoi(request.URL.Query().Get("y"))
+		month, _ := strconv.Atoi(request.URL.Query().Get("m"))
+		first = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	months := 3
+	requested, _ := strconv.Atoi(request.URL.Query().Get("months"))
+	for _, n := range timelineMonths {
+		if n == requested {
+			months = n
+		}
+	}
+
+	_ = render.Template(writer, *request, "admin-reservations-timeline.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"lengths": timelineMonths,
+		},
+		StringMap: map[string]string{
+			"start": first.Format("2006-01-02"),
+		},
+		IntMap: map[string]int{
+			"months": months,
+		},
+	})
+}
+
+// AdminRoomCalendar shows a year of one room, a month per row, loaded from AdminReservationsCalendarJSON
+func (m *Repository) AdminRoomCalendar(writer http.ResponseWriter, request *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(request.Context(), "error", "Room not found")
+		http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	year := time.Now().Year()
+	if y, err := strconv.Atoi(request.URL.Query().Get("y")); err == nil && y > 0 {
+		year = y
+	}
+
+	_ = render.Template(writer, *request, "admin-room-calendar.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"room": room,
+		},
+		IntMap: map[string]int{
+			"year": year,
+		},
+	})
+}
+
 func (m *Repository) AdminReservation(writer http.ResponseWriter, request *http.Request) {
 	reservationID, err := strconv.Atoi(chi.URLParam(request, "id"))
 	if err != nil {
@@ -583,9 +1278,53 @@ func (m *Repository) AdminReservation(writer http.ResponseWriter, request *http.
 		return
 	}
 
+	policy, penalty, err := m.cancellationTerms(reservation)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	history, err := m.DB.GetReservationStatusHistory(reservation.ID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	// the rooms any room of the reservation can be moved to with other dates
+	var rooms []models.Room
+	if reservation.IsOpen() {
+		rooms, err = m.DB.AllRooms()
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	// the other free units of their type each room of the reservation can be moved to
+	units := make(map[int][]models.Room)
+	if !reservation.IsCancelled() {
+		for _, stay := range reservation.RoomStays() {
+			if stay.ID == 0 {
+				continue
+			}
+			units[stay.ID], err = m.DB.GetFreeUnits(stay.RoomID, stay.StartDate, stay.EndDate, reservation.ID)
+			if err != nil {
+				helpers.ServerError(writer, err)
+				return
+			}
+		}
+	}
+
 	_ = render.Template(writer, *request, "admin-reservation.page.gohtml", &models.TemplateData{
 		Data: map[string]interface{}{
 			"reservation": reservation,
+			"policy":      policy,
+			"history":     history,
+			"units":       units,
+			"rooms":       rooms,
+		},
+		IntMap: map[string]int{
+			"penalty": penalty,
 		},
 		StringMap: map[string]string{
 			"src":   src,
@@ -640,96 +1379,2512 @@ func (m *Repository) AdminPostReservation(writer http.ResponseWriter, request *h
 	}
 }
 
-func (m *Repository) AdminProcessedReservation(writer http.ResponseWriter, request *http.Request) {
+// AdminReservationStatus moves a reservation to the next status of its lifecycle,
+// cancelling goes through AdminCancelReservation so that the penalty is charged
+func (m *Repository) AdminReservationStatus(writer http.ResponseWriter, request *http.Request) {
 	reservationID, err := strconv.Atoi(chi.URLParam(request, "id"))
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
 	}
+	src := chi.URLParam(request, "src")
 
-	err = m.DB.UpdateProcessedForReservations(reservationID, 1)
-
+	err = request.ParseForm()
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
 	}
 
-	writer.WriteHeader(http.StatusNoContent)
+	back := fmt.Sprintf("/admin/reservations/%s/%d", src, reservationID)
+	if year := request.Form.Get("year"); year != "" {
+		back = fmt.Sprintf("%s?y=%s&m=%s", back, year, request.Form.Get("month"))
+	}
+
+	status := models.ReservationStatus(request.Form.Get("status"))
+	if !status.Valid() || status == models.ReservationCancelled {
+		m.App.Session.Put(request.Context(), "error", "Invalid status")
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateReservationStatus(reservationID, status, m.App.Session.GetInt(request.Context(), "user_id"), request.Form.Get("note"))
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(request.Context(), "error", fmt.Sprintf("The reservation can't be marked as %s", strings.ToLower(status.Label())))
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("Reservation marked as %s", strings.ToLower(status.Label())))
+	http.Redirect(writer, request, back, http.StatusSeeOther)
 }
 
-func (m *Repository) AdminDeleteReservation(writer http.ResponseWriter, request *http.Request) {
+// AdminReassignReservationRoom moves one room of a reservation to another free unit of the same type
+func (m *Repository) AdminReassignReservationRoom(writer http.ResponseWriter, request *http.Request) {
 	reservationID, err := strconv.Atoi(chi.URLParam(request, "id"))
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
 	}
+	stayID, err := strconv.Atoi(chi.URLParam(request, "stayID"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	src := chi.URLParam(request, "src")
+
+	err = request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/reservations/%s/%d", src, reservationID)
+	if year := request.Form.Get("year"); year != "" {
+		back = fmt.Sprintf("%s?y=%s&m=%s", back, year, request.Form.Get("month"))
+	}
+
+	reservation, err := m.DB.GetReservationByID(reservationID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	var stay models.ReservationRoom
+	for _, s := range reservation.RoomStays() {
+		if s.ID == stayID {
+			stay = s
+		}
+	}
+	if stay.ID == 0 || reservation.IsCancelled() {
+		m.App.Session.Put(request.Context(), "error", "This room of the reservation can't be changed")
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	}
 
-	err = m.DB.DeleteReservation(reservationID)
+	roomID, _ := strconv.Atoi(request.Form.Get("room_id"))
 
+	units, err := m.DB.GetFreeUnits(stay.RoomID, stay.StartDate, stay.EndDate, reservation.ID)
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
 	}
 
-	writer.WriteHeader(http.StatusNoContent)
+	free := false
+	for _, unit := range units {
+		if unit.ID == roomID {
+			free = true
+		}
+	}
+
+	if free {
+		err = m.DB.ReassignReservationRoom(stay.ID, roomID)
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			free = false
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+	if !free {
+		m.App.Session.Put(request.Context(), "error", "That room isn't free for these dates")
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Room changed")
+	http.Redirect(writer, request, back, http.StatusSeeOther)
 }
 
-func (m *Repository) AdminPostReservationsCalendar(writer http.ResponseWriter, request *http.Request) {
-	err := request.ParseForm()
+// AdminMoveReservation moves one room of a reservation to another room and/or other dates, re-prices it
+// and tells the guest about the change
+func (m *Repository) AdminMoveReservation(writer http.ResponseWriter, request *http.Request) {
+	reservationID, err := strconv.Atoi(chi.URLParam(request, "id"))
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
 	}
+	src := chi.URLParam(request, "src")
 
-	year, _ := strconv.Atoi(request.Form.Get("y"))
-	month, _ := strconv.Atoi(request.Form.Get("m"))
+	err = request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
 
-	rooms, err := m.DB.AllRooms()
+	year := request.Form.Get("year")
+	month := request.Form.Get("month")
+
+	back := fmt.Sprintf("/admin/reservations/%s/%d", src, reservationID)
+	if year != "" {
+		back = fmt.Sprintf("%s?y=%s&m=%s", back, year, month)
+	}
+
+	reservation, err := m.DB.GetReservationByID(reservationID)
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
 	}
 
+	stayID, _ := strconv.Atoi(request.Form.Get("stay_id"))
+	var stay models.ReservationRoom
+	for _, s := range reservation.RoomStays() {
+		if s.ID == stayID {
+			stay = s
+		}
+	}
+	if stay.ID == 0 || !reservation.IsOpen() {
+		m.App.Session.Put(request.Context(), "error", "This room of the reservation can't be moved")
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	}
+
 	form := forms.New(request.PostForm)
+	form.Required("room_id", "start_date", "end_date")
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+
+	startDate, _ := time.Parse("2006-01-02", form.Get("start_date"))
+	endDate, _ := time.Parse("2006-01-02", form.Get("end_date"))
+	if form.Valid() && !endDate.After(startDate) {
+		form.Errors.Add("end_date", "The departure has to be after the arrival")
+	}
 
-	a := form.Get("add_block")
-	log.Println(a)
-	b := form.Get("remove_block")
-	log.Println(b)
+	if !form.Valid() {
+		m.App.Session.Put(request.Context(), "error", "Please choose a room, an arrival and a later departure")
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	}
 
-	for _, room := range rooms {
+	roomID, _ := strconv.Atoi(form.Get("room_id"))
+	room, err := m.DB.GetRoomById(roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(request.Context(), "error", "Room not found")
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
 
-		curMap := m.App.Session.Get(request.Context(), fmt.Sprintf("block_map_%d", room.ID)).(map[string]int)
-		for name, value := range curMap {
-			if val, ok := curMap[name]; ok {
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block[%d][%s]", room.ID, name)) {
-						log.Println("would delete block", value)
-						err = m.DB.DeleteRoomRestriction(value)
-						if err != nil {
-							helpers.ServerError(writer, err)
-							return
-						}
-					}
-				}
-			}
+	// the party has to fit in the rooms the reservation keeps once this one is moved
+	moved := reservation
+	moved.Rooms = nil
+	for _, s := range reservation.RoomStays() {
+		if s.ID == stay.ID {
+			s.Room = room
+		}
+		moved.Rooms = append(moved.Rooms, s)
+	}
+	if !moved.Fits() {
+		message := fmt.Sprintf("%s sleeps %s", room.RoomName, room.Capacity())
+		if moved.IsGroup() {
+			message = fmt.Sprintf("With %s the rooms sleep %s", room.RoomName, moved.Capacity())
 		}
+		m.App.Session.Put(request.Context(), "error", message)
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
 	}
 
-	for name, value := range request.PostForm["add_block"] {
-		log.Println("name:", name, value)
-		re := regexp.MustCompile(`(\d*):(\d*-\d*-\d*)`)
-		match := re.FindStringSubmatch(value)
-		addID, _ := strconv.Atoi(match[1])
-		date, _ := time.Parse("2006-01-02", match[2])
-		log.Println(addID, date)
-		m.DB.InsertBlockForRoom(addID, date)
+	old := stay
+	stay.RoomID = room.ID
+	stay.Room = room
+	stay.StartDate = startDate
+	stay.EndDate = endDate
+
+	// unless asked to keep it, the moved stay costs what the new room charges for the new dates
+	if form.Get("keep_price") == "" {
+		price, err := m.priceForRoom(room, startDate, endDate)
 		if err != nil {
 			helpers.ServerError(writer, err)
 			return
 		}
+		stay.TotalPrice = price.Total
 	}
 
-	m.App.Session.Put(request.Context(), "flash", "Changes saved")
-	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/calendar?y=%d&m=%02d", year, month), http.StatusSeeOther)
+	total := reservation.TotalPrice - old.TotalPrice + stay.TotalPrice
+
+	reservation.TotalPrice = total
+	data := m.emailData(reservation)
+	data.Stay = stay
+	data.PreviousStay = old
+	var mails []models.MailData
+	if mail, ok := m.renderMail(reservation.Email, "reservation-changed", data); ok {
+		mails = append(mails, mail)
+	}
+
+	err = m.DB.MoveReservationRoom(stay, mails)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(request.Context(), "error", fmt.Sprintf("%s is not available for these dates", room.RoomName))
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrReservationClosed) {
+		m.App.Session.Put(request.Context(), "error", "This room of the reservation can't be moved")
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.wakeMailWorkers()
+
+	m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("Reservation moved, the new total price is %s", render.Money(total)))
+
+	if src == "cal" {
+		http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/calendar?y=%d&m=%d",
+			startDate.Year(), startDate.Month()), http.StatusSeeOther)
+	} else {
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+	}
+}
+
+// AdminCancelReservation cancels a reservation, charging the penalty of the room's cancellation policy
+func (m *Repository) AdminCancelReservation(writer http.ResponseWriter, request *http.Request) {
+	reservationID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	src := chi.URLParam(request, "src")
+
+	err = request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	reservation, err := m.DB.GetReservationByID(reservationID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	if !reservation.Status.CanTransitionTo(models.ReservationCancelled) {
+		m.App.Session.Put(request.Context(), "error", "The reservation can't be cancelled anymore")
+		http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/%s/%d", src, reservation.ID), http.StatusSeeOther)
+		return
+	}
+
+	_, penalty, err := m.cancellationTerms(reservation)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	data := m.emailData(reservation)
+	data.CancellationFee = penalty
+	var mails []models.MailData
+	if mail, ok := m.renderMail(reservation.Email, "reservation-cancelled", data); ok {
+		mails = append(mails, mail)
+	}
+
+	reason := request.Form.Get("reason")
+	err = m.DB.CancelReservation(reservation.ID, reason, penalty, m.App.Session.GetInt(request.Context(), "user_id"), mails)
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(request.Context(), "error", "The reservation can't be cancelled anymore")
+		http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/%s/%d", src, reservation.ID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.wakeMailWorkers()
+
+	year := request.Form.Get("year")
+	month := request.Form.Get("month")
+
+	m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("Reservation cancelled, cancellation fee: %s", render.Money(penalty)))
+
+	if year == "" {
+		http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// cancellationTerms returns the cancellation policy of the reservation's room and the fee for cancelling it now.
+// For a reservation with several rooms the fee is the sum of what each room's policy charges for its own stay.
+func (m *Repository) cancellationTerms(reservation models.Reservation) (models.CancellationPolicy, int, error) {
+	var primary models.CancellationPolicy
+	penalty := 0
+
+	for i, stay := range reservation.RoomStays() {
+		policy, err := m.DB.GetCancellationPolicyForRoom(stay.RoomID)
+		if err != nil {
+			return primary, 0, err
+		}
+		if i == 0 {
+			primary = policy
+		}
+		if policy.ID == 0 {
+			continue
+		}
+
+		room, err := m.DB.GetRoomById(stay.RoomID)
+		if err != nil {
+			return primary, 0, err
+		}
+
+		firstNight, err := m.priceForRoom(room, stay.StartDate, stay.StartDate.AddDate(0, 0, 1))
+		if err != nil {
+			return primary, 0, err
+		}
+
+		penalty += pricing.CancellationPenalty(policy, stay.StartDate, stay.TotalPrice, firstNight.Total, time.Now())
+	}
+
+	return primary, penalty, nil
+}
+
+// AdminPostReservationsCalendar blocks the ticked free nights and frees the ticked blocked nights of a month.
+// The changes of a room are only saved when its calendar is still the version the form was made from.
+func (m *Repository) AdminPostReservationsCalendar(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	year, _ := strconv.Atoi(request.Form.Get("y"))
+	month, _ := strconv.Atoi(request.Form.Get("m"))
+	firstOfMonth := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	changes := make(map[int]*models.CalendarChange)
+	for _, room := range rooms {
+		changes[room.ID] = &models.CalendarChange{
+			RoomID:  room.ID,
+			Start:   firstOfMonth,
+			End:     lastOfMonth,
+			Version: request.Form.Get(fmt.Sprintf("version_%d", room.ID)),
+		}
+	}
+
+	// nights are posted as room id:date
+	night := func(value string) (*models.CalendarChange, time.Time, bool) {
+		roomID, day, _ := strings.Cut(value, ":")
+		id, _ := strconv.Atoi(roomID)
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil || changes[id] == nil || date.Before(firstOfMonth) || date.After(lastOfMonth) {
+			return nil, date, false
+		}
+		return changes[id], date, true
+	}
+
+	for _, value := range request.PostForm["add_block"] {
+		if change, date, ok := night(value); ok {
+			change.Add = append(change.Add, date)
+		}
+	}
+	for _, value := range request.PostForm["remove_block"] {
+		if change, date, ok := night(value); ok {
+			change.Remove = append(change.Remove, date)
+		}
+	}
+
+	var changed, unavailable, imported []string
+	for _, room := range rooms {
+		change := changes[room.ID]
+		if len(change.Add) == 0 && len(change.Remove) == 0 {
+			continue
+		}
+
+		err = m.DB.UpdateCalendarBlocks(*change)
+		if errors.Is(err, repository.ErrCalendarChanged) {
+			changed = append(changed, room.RoomName)
+		} else if errors.Is(err, repository.ErrRoomNotAvailable) {
+			unavailable = append(unavailable, room.RoomName)
+		} else if errors.Is(err, repository.ErrImportedBlock) {
+			imported = append(imported, room.RoomName)
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	var problems []string
+	if len(changed) > 0 {
+		problems = append(problems, fmt.Sprintf("%s changed while you were editing, so its changes weren't saved. "+
+			"Please check the calendar and submit them again", strings.Join(changed, ", ")))
+	}
+	if len(unavailable) > 0 {
+		problems = append(problems, fmt.Sprintf("%s got booked on a night you wanted to block, so its changes weren't saved",
+			strings.Join(unavailable, ", ")))
+	}
+	if len(imported) > 0 {
+		problems = append(problems, fmt.Sprintf("%s has nights blocked by an imported calendar, they are freed in the calendar "+
+			"they come from, so its changes weren't saved", strings.Join(imported, ", ")))
+	}
+
+	if len(problems) > 0 {
+		m.App.Session.Put(request.Context(), "error", strings.Join(problems, ". "))
+	} else {
+		m.App.Session.Put(request.Context(), "flash", "Changes saved")
+	}
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/calendar?y=%d&m=%02d", year, month), http.StatusSeeOther)
+}
+
+// Limits on recurring owner blocks, so that a block doesn't expand into an endless number of restrictions
+const (
+	maxWeeklyBlockYears = 2
+	maxYearlyBlockYears = 10
+)
+
+// AdminBlocks lists the owner blocks with the forms to block a range of dates and to free one
+func (m *Repository) AdminBlocks(writer http.ResponseWriter, request *http.Request) {
+	block := models.OwnerBlock{
+		Recurrence: models.BlockOnce,
+		Weekdays:   models.AllWeekdays,
+		Years:      2,
+	}
+
+	m.renderBlocksPage(writer, request, block, forms.New(nil))
+}
+
+// AdminPostBlock blocks a room for a range of nights, once, on some days of every week or every year
+func (m *Repository) AdminPostBlock(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("room_id", "start_date", "end_date")
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+
+	var block models.OwnerBlock
+	block.RoomID, _ = strconv.Atoi(form.Get("room_id"))
+	block.Note = form.Get("note")
+	block.Recurrence = form.Get("recurrence")
+	block.Weekdays = weekdaysFromForm(request.PostForm["weekdays"])
+	block.Years, _ = strconv.Atoi(form.Get("years"))
+	block.StartDate, _ = time.Parse("2006-01-02", form.Get("start_date"))
+	block.EndDate, _ = time.Parse("2006-01-02", form.Get("end_date"))
+
+	if block.RoomID < 1 {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+	if block.EndDate.Before(block.StartDate) {
+		form.Errors.Add("end_date", "The last night can't be before the first night")
+	}
+
+	switch block.Recurrence {
+	case models.BlockWeekly:
+		block.Years = 1
+		if block.EndDate.After(block.StartDate.AddDate(maxWeeklyBlockYears, 0, 0)) {
+			form.Errors.Add("end_date", fmt.Sprintf("A weekly block can't run for more than %d years", maxWeeklyBlockYears))
+		}
+		if form.Valid() && len(block.Restrictions()) == 0 {
+			form.Errors.Add("weekdays", "None of the nights falls on the chosen days")
+		}
+	case models.BlockYearly:
+		if block.Years < 2 || block.Years > maxYearlyBlockYears {
+			form.Errors.Add("years", fmt.Sprintf("Repeat a yearly block for 2 to %d years", maxYearlyBlockYears))
+		}
+		if !block.EndDate.Before(block.StartDate.AddDate(1, 0, 0)) {
+			form.Errors.Add("end_date", "A yearly block has to be shorter than a year")
+		}
+	default:
+		block.Recurrence = models.BlockOnce
+		block.Years = 1
+	}
+	if block.Recurrence != models.BlockWeekly {
+		block.Weekdays = models.AllWeekdays
+	}
+
+	if !form.Valid() {
+		m.renderBlocksPage(writer, request, block, form)
+		return
+	}
+
+	_, err = m.DB.InsertOwnerBlock(block)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		form.Errors.Add("start_date", "Some of these nights are already booked or blocked")
+		m.renderBlocksPage(writer, request, block, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Dates blocked")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/calendar?y=%d&m=%02d", block.StartDate.Year(), block.StartDate.Month()), http.StatusSeeOther)
+}
+
+// AdminDeleteBlock removes an owner block with every night it blocks
+func (m *Repository) AdminDeleteBlock(writer http.ResponseWriter, request *http.Request) {
+	blockID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteOwnerBlock(blockID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Block removed")
+	http.Redirect(writer, request, "/admin/blocks", http.StatusSeeOther)
+}
+
+// AdminRemoveBlocks frees a range of nights of a room, or of every room, from all owner blocks
+func (m *Repository) AdminRemoveBlocks(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	roomID, _ := strconv.Atoi(request.Form.Get("room_id"))
+	startDate, err := time.Parse("2006-01-02", request.Form.Get("start_date"))
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", "Choose the first night to free")
+		http.Redirect(writer, request, "/admin/blocks", http.StatusSeeOther)
+		return
+	}
+	endDate, err := time.Parse("2006-01-02", request.Form.Get("end_date"))
+	if err != nil || endDate.Before(startDate) {
+		m.App.Session.Put(request.Context(), "error", "Choose a last night that isn't before the first night")
+		http.Redirect(writer, request, "/admin/blocks", http.StatusSeeOther)
+		return
+	}
+
+	freed, imported, err := m.DB.DeleteBlocksByDate(roomID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	message := fmt.Sprintf("Freed the dates of %d block(s)", freed)
+	if imported > 0 {
+		message += fmt.Sprintf(". %d block(s) of imported calendars were left, their nights are freed in the calendar they come from", imported)
+	}
+	m.App.Session.Put(request.Context(), "flash", message)
+	http.Redirect(writer, request, "/admin/blocks", http.StatusSeeOther)
+}
+
+func (m *Repository) renderBlocksPage(writer http.ResponseWriter, request *http.Request, block models.OwnerBlock, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	blocks, err := m.DB.AllOwnerBlocks()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *request, "admin-blocks.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"block":    block,
+			"blocks":   blocks,
+			"rooms":    rooms,
+			"weekdays": weekdayOptions(block.Weekdays),
+		},
+		IntMap: map[string]int{
+			"max_years": maxYearlyBlockYears,
+		},
+		Form: form,
+	})
+}
+
+// Limits on downloading and uploading the calendars of other channels
+const (
+	icalFetchTimeout = 20 * time.Second
+	maxICalSize      = 5 << 20
+)
+
+// icalClient downloads the calendars of other channels
+var icalClient = &http.Client{Timeout: icalFetchTimeout}
+
+// AdminICalImports lists the calendars of other channels imported as owner blocks
+func (m *Repository) AdminICalImports(writer http.ResponseWriter, request *http.Request) {
+	m.renderICalImportsPage(writer, request, models.ICalImport{}, forms.New(nil))
+}
+
+// AdminPostICalImport registers a calendar of another channel for a room, by URL or as an uploaded file,
+// and imports its events right away
+func (m *Repository) AdminPostICalImport(writer http.ResponseWriter, request *http.Request) {
+	request.Body = http.MaxBytesReader(writer, request.Body, maxICalSize)
+	err := request.ParseMultipartForm(maxICalSize)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		m.App.Session.Put(request.Context(), "error", "The calendar file is too large")
+		http.Redirect(writer, request, "/admin/ical-imports", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("name")
+
+	var imp models.ICalImport
+	imp.RoomID, _ = strconv.Atoi(form.Get("room_id"))
+	imp.Name = form.Get("name")
+	imp.URL = icalURL(form.Get("url"))
+
+	if imp.RoomID < 1 {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	file, _, fileErr := request.FormFile("calendar")
+	if fileErr == nil {
+		defer func(file multipart.File) {
+			_ = file.Close()
+		}(file)
+		// an uploaded calendar is imported once, not fetched again
+		imp.URL = ""
+	} else if imp.URL == "" {
+		form.Errors.Add("url", "Enter the address of the calendar or upload its file")
+	} else if u, err := url.Parse(imp.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		form.Errors.Add("url", "Enter an http or https address")
+	}
+
+	if !form.Valid() {
+		m.renderICalImportsPage(writer, request, imp, form)
+		return
+	}
+
+	imp.ID, err = m.DB.InsertICalImport(imp)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	var sync models.ICalSync
+	if fileErr == nil {
+		sync, err = m.importICalFile(imp, file)
+	} else {
+		sync, err = m.SyncICalImport(imp)
+	}
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", fmt.Sprintf("The calendar was added but couldn't be imported: %s", err))
+		http.Redirect(writer, request, "/admin/ical-imports", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("Calendar imported: %s", sync.Summary()))
+	http.Redirect(writer, request, "/admin/ical-imports", http.StatusSeeOther)
+}
+
+// AdminSyncICalImport imports a calendar again, from its URL or from a newly uploaded file
+func (m *Repository) AdminSyncICalImport(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	request.Body = http.MaxBytesReader(writer, request.Body, maxICalSize)
+	err = request.ParseMultipartForm(maxICalSize)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		m.App.Session.Put(request.Context(), "error", "The calendar file is too large")
+		http.Redirect(writer, request, "/admin/ical-imports", http.StatusSeeOther)
+		return
+	}
+
+	imp, err := m.DB.GetICalImportByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(request.Context(), "error", "Calendar not found")
+		http.Redirect(writer, request, "/admin/ical-imports", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	var sync models.ICalSync
+	file, _, fileErr := request.FormFile("calendar")
+	switch {
+	case fileErr == nil:
+		defer func(file multipart.File) {
+			_ = file.Close()
+		}(file)
+		sync, err = m.importICalFile(imp, file)
+	case imp.URL != "":
+		sync, err = m.SyncICalImport(imp)
+	default:
+		m.App.Session.Put(request.Context(), "error", "Upload the calendar file to import it again")
+		http.Redirect(writer, request, "/admin/ical-imports", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", fmt.Sprintf("The calendar couldn't be imported: %s", err))
+		http.Redirect(writer, request, "/admin/ical-imports", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("Calendar imported: %s", sync.Summary()))
+	http.Redirect(writer, request, "/admin/ical-imports", http.StatusSeeOther)
+}
+
+// AdminDeleteICalImport stops importing a calendar and frees the nights its events blocked
+func (m *Repository) AdminDeleteICalImport(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteICalImport(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Calendar removed, its blocked nights are free again")
+	http.Redirect(writer, request, "/admin/ical-imports", http.StatusSeeOther)
+}
+
+// SyncICalImport downloads the calendar of an import with a URL and brings the room's blocks in line with
+// its events. A failed download is recorded on the import and leaves its blocks as they are.
+func (m *Repository) SyncICalImport(imp models.ICalImport) (models.ICalSync, error) {
+	events, err := fetchICal(imp.URL)
+	if err != nil {
+		if err := m.DB.UpdateICalImportError(imp.ID, err.Error()); err != nil {
+			log.Println(err)
+		}
+		return models.ICalSync{}, err
+	}
+
+	return m.importICalEvents(imp, events)
+}
+
+// importICalFile brings the room's blocks in line with the events of an uploaded calendar
+func (m *Repository) importICalFile(imp models.ICalImport, file io.Reader) (models.ICalSync, error) {
+	events, err := ical.Parse(file)
+	if err != nil {
+		if err := m.DB.UpdateICalImportError(imp.ID, err.Error()); err != nil {
+			log.Println(err)
+		}
+		return models.ICalSync{}, err
+	}
+
+	return m.importICalEvents(imp, events)
+}
+
+// importICalEvents blocks the nights of the events that aren't over yet, one block per event
+func (m *Repository) importICalEvents(imp models.ICalImport, events []ical.Event) (models.ICalSync, error) {
+	var blocks []models.RoomRestriction
+	for _, e := range events {
+		start, end := e.Nights()
+		blocks = append(blocks, models.RoomRestriction{
+			RestrictionID: models.RestrictionOwnerBlock,
+			RoomID:        imp.RoomID,
+			ICalImportID:  imp.ID,
+			ICalUID:       e.UID,
+			StartDate:     start,
+			EndDate:       end,
+		})
+	}
+
+	return m.DB.SyncICalImport(imp.ID, blocks)
+}
+
+// fetchICal downloads and reads a calendar
+func fetchICal(address string) ([]ical.Event, error) {
+	resp, err := icalClient.Get(address)
+	if err != nil {
+		return nil, err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the calendar address answered %s", resp.Status)
+	}
+
+	return ical.Parse(io.LimitReader(resp.Body, maxICalSize))
+}
+
+// icalURL tidies a calendar address, subscription links often use the webcal scheme for https
+func icalURL(address string) string {
+	address = strings.TrimSpace(address)
+	if strings.HasPrefix(strings.ToLower(address), "webcal://") {
+		return "https://" + address[len("webcal://"):]
+	}
+	return address
+}
+
+func (m *Repository) renderICalImportsPage(writer http.ResponseWriter, request *http.Request, imp models.ICalImport, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	imports, err := m.DB.AllICalImports()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *request, "admin-ical-imports.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"import":  imp,
+			"imports": imports,
+			"rooms":   rooms,
+		},
+		IntMap: map[string]int{
+			"max_size_mb": maxICalSize >> 20,
+		},
+		Form: form,
+	})
+}
+
+// AdminMailOutbox lists the emails that are waiting to be sent and the ones that failed too often to be tried again
+func (m *Repository) AdminMailOutbox(writer http.ResponseWriter, request *http.Request) {
+	pending, err := m.DB.AllOutboxMail(models.MailPending)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	dead, err := m.DB.AllOutboxMail(models.MailDead)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *request, "admin-mail-outbox.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"pending": pending,
+			"dead":    dead,
+		},
+	})
+}
+
+// AdminResendMail puts a dead email back in the outbox with a new set of attempts
+func (m *Repository) AdminResendMail(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.ResendMail(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(request.Context(), "error", "The email isn't waiting to be resent")
+		http.Redirect(writer, request, "/admin/mail-outbox", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.wakeMailWorkers()
+
+	m.App.Session.Put(request.Context(), "flash", "The email will be sent again")
+	http.Redirect(writer, request, "/admin/mail-outbox", http.StatusSeeOther)
+}
+
+// emailTemplateDescriptions tell the owner when each email is sent
+var emailTemplateDescriptions = map[string]string{
+	"reservation-confirmation":    "Sent to the guest when they make a reservation",
+	"reservation-new":             "Sent to you when a reservation is made",
+	"reservation-changed":         "Sent to the guest when their reservation is moved or its dates change",
+	"reservation-changed-owner":   "Sent to you when a guest changes the dates of their reservation",
+	"reservation-cancelled":       "Sent to the guest when their reservation is cancelled",
+	"reservation-cancelled-owner": "Sent to you when a guest cancels their reservation",
+}
+
+// AdminEmailTemplates lists the emails, telling which ones have been reworded
+func (m *Repository) AdminEmailTemplates(writer http.ResponseWriter, request *http.Request) {
+	names, err := render.EmailNames()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	templates, err := m.DB.AllEmailTemplates()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	custom := make(map[string]models.EmailTemplate)
+	for _, t := range templates {
+		custom[t.Name] = t
+	}
+
+	_ = render.Template(writer, *request, "admin-email-templates.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"names":  names,
+			"custom": custom,
+		},
+		StringMap: emailTemplateDescriptions,
+	})
+}
+
+// AdminEmailTemplate shows the form to reword an email, with its current wording previewed
+func (m *Repository) AdminEmailTemplate(writer http.ResponseWriter, request *http.Request) {
+	name, ok := m.emailTemplateName(writer, request)
+	if !ok {
+		return
+	}
+
+	email, err := m.DB.GetEmailTemplate(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		email, err = render.DefaultEmailTemplate(name)
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.renderEmailTemplateForm(writer, request, email, forms.New(nil))
+}
+
+// AdminPostEmailTemplate saves the wording of an email, unless it can't be rendered for the sample reservation
+func (m *Repository) AdminPostEmailTemplate(writer http.ResponseWriter, request *http.Request) {
+	name, ok := m.emailTemplateName(writer, request)
+	if !ok {
+		return
+	}
+
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("subject", "html", "text")
+
+	email := models.EmailTemplate{
+		Name:    name,
+		Subject: form.Get("subject"),
+		HTML:    form.Get("html"),
+		Text:    form.Get("text"),
+	}
+
+	if form.Valid() {
+		_, err = render.EmailFromTemplate(email, m.sampleEmailData())
+		var templateErr *render.EmailTemplateError
+		if errors.As(err, &templateErr) {
+			form.Errors.Add(templateErr.Part, templateErr.Err.Error())
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderEmailTemplateForm(writer, request, email, form)
+		return
+	}
+
+	err = m.DB.SaveEmailTemplate(email)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Email template saved")
+	http.Redirect(writer, request, "/admin/email-templates", http.StatusSeeOther)
+}
+
+// emailPreviewResponse is an email rendered for the sample reservation, or why it can't be
+type emailPreviewResponse struct {
+	OK      bool   `json:"ok"`
+	Part    string `json:"part,omitempty"`
+	Message string `json:"message,omitempty"`
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// AdminPreviewEmailTemplate renders the posted wording of an email for the sample reservation
+func (m *Repository) AdminPreviewEmailTemplate(writer http.ResponseWriter, request *http.Request) {
+	name, ok := m.emailTemplateName(writer, request)
+	if !ok {
+		return
+	}
+
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ClientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	resp := emailPreviewResponse{OK: true}
+	mail, err := render.EmailFromTemplate(models.EmailTemplate{
+		Name:    name,
+		Subject: request.PostForm.Get("subject"),
+		HTML:    request.PostForm.Get("html"),
+		Text:    request.PostForm.Get("text"),
+	}, m.sampleEmailData())
+	var templateErr *render.EmailTemplateError
+	if errors.As(err, &templateErr) {
+		resp = emailPreviewResponse{OK: false, Part: templateErr.Part, Message: templateErr.Err.Error()}
+	} else if err != nil {
+		m.App.ErrorLog.Println(err)
+		resp = emailPreviewResponse{OK: false, Message: "Internal server error"}
+	} else {
+		resp.Subject = mail.Subject
+		resp.HTML = mail.Content
+		resp.Text = mail.Text
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	writer.Header().Set("Content-Type", "application/json")
+	_, _ = writer.Write(out)
+}
+
+// AdminResetEmailTemplate puts an email back to the wording of its files
+func (m *Repository) AdminResetEmailTemplate(writer http.ResponseWriter, request *http.Request) {
+	name, ok := m.emailTemplateName(writer, request)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteEmailTemplate(name)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "The email is back to its default wording")
+	http.Redirect(writer, request, "/admin/email-templates", http.StatusSeeOther)
+}
+
+// emailTemplateName returns the email named in the URL, answering 404 when there is no such email
+func (m *Repository) emailTemplateName(writer http.ResponseWriter, request *http.Request) (string, bool) {
+	names, err := render.EmailNames()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return "", false
+	}
+
+	name := chi.URLParam(request, "name")
+	for _, n := range names {
+		if n == name {
+			return name, true
+		}
+	}
+
+	http.NotFound(writer, request)
+	return "", false
+}
+
+func (m *Repository) renderEmailTemplateForm(writer http.ResponseWriter, request *http.Request, email models.EmailTemplate, form *forms.Form) {
+	// the form shows what the email looks like, as far as it can be rendered
+	preview, _ := render.EmailFromTemplate(email, m.sampleEmailData())
+
+	_ = render.Template(writer, *request, "admin-email-template.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"email":   email,
+			"preview": preview,
+		},
+		StringMap: map[string]string{
+			"description": emailTemplateDescriptions[email.Name],
+		},
+		Form: form,
+	})
+}
+
+// sampleEmailData is a made up reservation the email templates are previewed and checked with
+func (m *Repository) sampleEmailData() *models.EmailData {
+	start := time.Now().AddDate(0, 1, 0).Truncate(24 * time.Hour)
+	reservation := models.Reservation{
+		ID:               1,
+		FirstName:        "John",
+		LastName:         "Smith",
+		Email:            "john@smith.com",
+		Phone:            "555-0100",
+		StartDate:        start,
+		EndDate:          start.AddDate(0, 0, 3),
+		RoomID:           1,
+		Status:           models.ReservationConfirmed,
+		TotalPrice:       45000,
+		ConfirmationCode: "K7QM2XD9PRT4",
+		Adults:           2,
+		Room:             models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+
+	data := m.emailData(reservation)
+	data.Stay = reservationStay(reservation)
+	data.PreviousStay = reservationStay(reservation)
+	data.PreviousStay.StartDate = start.AddDate(0, 0, -7)
+	data.PreviousStay.EndDate = start.AddDate(0, 0, -4)
+	data.CancellationFee = 15000
+	return data
+}
+
+// AdminRates lists the rate rules and previews the resulting nightly prices for a month
+func (m *Repository) AdminRates(writer http.ResponseWriter, request *http.Request) {
+	now := time.Now()
+
+	if request.URL.Query().Get("y") != "" && request.URL.Query().Get("m") != "" {
+		year, _ := strconv.Atoi(request.URL.Query().Get("y"))
+		month, _ := strconv.Atoi(request.URL.Query().Get("m"))
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+
+	currentYear, currentMonth, _ := now.Date()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, time.UTC)
+	firstOfNextMonth := firstOfMonth.AddDate(0, 1, 0)
+
+	rules, err := m.DB.AllRateRules()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"now":   now,
+		"rules": rules,
+		"rooms": rooms,
+	}
+
+	for _, room := range rooms {
+		priceMap := make(map[string]int)
+		ruleMap := make(map[string]string)
+
+		breakdown := pricing.Calculate(room, firstOfMonth, firstOfNextMonth, rules)
+		for _, night := range breakdown.Nights {
+			priceMap[night.Date.Format("2006-01-02")] = night.Price
+			ruleMap[night.Date.Format("2006-01-02")] = night.Rule
+		}
+
+		data[fmt.Sprintf("price_map_%d", room.ID)] = priceMap
+		data[fmt.Sprintf("rule_map_%d", room.ID)] = ruleMap
+	}
+
+	_ = render.Template(writer, *request, "admin-rates.page.gohtml", &models.TemplateData{
+		StringMap: map[string]string{
+			"next_month":      next.Format("01"),
+			"next_month_year": next.Format("2006"),
+			"last_month":      last.Format("01"),
+			"last_month_year": last.Format("2006"),
+			"this_month":      now.Format("01"),
+			"this_month_year": now.Format("2006"),
+		},
+		IntMap: map[string]int{
+			"days_in_month": firstOfNextMonth.AddDate(0, 0, -1).Day(),
+		},
+		Data: data,
+	})
+}
+
+// AdminRate shows the form to create (id "new") or edit a rate rule
+func (m *Repository) AdminRate(writer http.ResponseWriter, request *http.Request) {
+	rule := models.RateRule{
+		Weekdays:   models.AllWeekdays,
+		AmountType: models.RateRulePercent,
+	}
+
+	if chi.URLParam(request, "id") != "new" {
+		ruleID, err := strconv.Atoi(chi.URLParam(request, "id"))
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+
+		rule, err = m.DB.GetRateRuleByID(ruleID)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	m.renderRateRuleForm(writer, request, rule, forms.New(nil))
+}
+
+// AdminPostRate creates (id "new") or updates a rate rule
+func (m *Repository) AdminPostRate(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	var rule models.RateRule
+	if chi.URLParam(request, "id") != "new" {
+		rule.ID, err = strconv.Atoi(chi.URLParam(request, "id"))
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("name", "start_date", "end_date", "priority", "amount")
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+	form.IsInt("priority")
+	form.IsInt("amount")
+
+	rule.Name = form.Get("name")
+	rule.RoomID, _ = strconv.Atoi(form.Get("room_id"))
+	rule.StartDate, _ = time.Parse("2006-01-02", form.Get("start_date"))
+	rule.EndDate, _ = time.Parse("2006-01-02", form.Get("end_date"))
+	rule.Priority, _ = strconv.Atoi(form.Get("priority"))
+	rule.AmountType = form.Get("amount_type")
+	rule.Amount, _ = strconv.Atoi(form.Get("amount"))
+	rule.Weekdays = weekdaysFromForm(request.PostForm["weekdays"])
+
+	if rule.EndDate.Before(rule.StartDate) {
+		form.Errors.Add("end_date", "The end date can't be before the start date")
+	}
+	if rule.Weekdays == 0 {
+		form.Errors.Add("weekdays", "Choose at least one day of the week")
+	}
+	if rule.AmountType != models.RateRuleFixed && rule.AmountType != models.RateRulePercent {
+		form.Errors.Add("amount_type", "Unknown amount type")
+	}
+	if rule.AmountType == models.RateRuleFixed && rule.Amount < 0 {
+		form.Errors.Add("amount", "A fixed rate can't be negative")
+	}
+	if rule.AmountType == models.RateRulePercent && rule.Amount < -100 {
+		form.Errors.Add("amount", "A discount can't exceed 100 percent")
+	}
+
+	if !form.Valid() {
+		m.renderRateRuleForm(writer, request, rule, form)
+		return
+	}
+
+	if rule.ID == 0 {
+		_, err = m.DB.InsertRateRule(rule)
+	} else {
+		err = m.DB.UpdateRateRule(rule)
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Rate rule saved")
+	http.Redirect(writer, request, "/admin/rates", http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDeleteRate(writer http.ResponseWriter, request *http.Request) {
+	ruleID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteRateRule(ruleID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Rate rule deleted")
+	http.Redirect(writer, request, "/admin/rates", http.StatusSeeOther)
+}
+
+type weekdayOption struct {
+	Value   int
+	Name    string
+	Checked bool
+}
+
+// weekdayOptions lists the days of the week for the weekday checkboxes of a mask
+func weekdayOptions(mask int) []weekdayOption {
+	var options []weekdayOption
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		options = append(options, weekdayOption{
+			Value:   int(day),
+			Name:    day.String(),
+			Checked: mask&(1<<uint(day)) != 0,
+		})
+	}
+	return options
+}
+
+// weekdaysFromForm turns the posted weekday checkboxes into a mask
+func weekdaysFromForm(values []string) int {
+	mask := 0
+	for _, value := range values {
+		day, err := strconv.Atoi(value)
+		if err == nil && day >= 0 && day <= 6 {
+			mask |= 1 << day
+		}
+	}
+	return mask
+}
+
+func (m *Repository) renderRateRuleForm(writer http.ResponseWriter, request *http.Request, rule models.RateRule, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *request, "admin-rate.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"rule":     rule,
+			"rooms":    rooms,
+			"weekdays": weekdayOptions(rule.Weekdays),
+		},
+		Form: form,
+	})
+}
+
+func (m *Repository) AdminStayRules(writer http.ResponseWriter, request *http.Request) {
+	rules, err := m.DB.AllStayRules()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *request, "admin-stay-rules.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"rules": rules,
+		},
+	})
+}
+
+// AdminStayRule shows the form to create (id "new") or edit a stay rule
+func (m *Repository) AdminStayRule(writer http.ResponseWriter, request *http.Request) {
+	rule := models.StayRule{
+		Weekdays: models.AllWeekdays,
+	}
+
+	if chi.URLParam(request, "id") != "new" {
+		ruleID, err := strconv.Atoi(chi.URLParam(request, "id"))
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+
+		rule, err = m.DB.GetStayRuleByID(ruleID)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	m.renderStayRuleForm(writer, request, rule, forms.New(nil))
+}
+
+// AdminPostStayRule creates (id "new") or updates a stay rule
+func (m *Repository) AdminPostStayRule(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	var rule models.StayRule
+	if chi.URLParam(request, "id") != "new" {
+		rule.ID, err = strconv.Atoi(chi.URLParam(request, "id"))
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("start_date", "end_date", "min_nights", "max_nights")
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+	form.IsInt("min_nights")
+	form.IsInt("max_nights")
+
+	rule.RoomID, _ = strconv.Atoi(form.Get("room_id"))
+	rule.StartDate, _ = time.Parse("2006-01-02", form.Get("start_date"))
+	rule.EndDate, _ = time.Parse("2006-01-02", form.Get("end_date"))
+	rule.Weekdays = weekdaysFromForm(request.PostForm["weekdays"])
+	rule.MinNights, _ = strconv.Atoi(form.Get("min_nights"))
+	rule.MaxNights, _ = strconv.Atoi(form.Get("max_nights"))
+	rule.ClosedToArrival = form.Get("closed_to_arrival") != ""
+	rule.ClosedToDeparture = form.Get("closed_to_departure") != ""
+
+	if rule.EndDate.Before(rule.StartDate) {
+		form.Errors.Add("end_date", "The end date can't be before the start date")
+	}
+	if rule.Weekdays == 0 {
+		form.Errors.Add("weekdays", "Choose at least one day of the week")
+	}
+	if rule.MinNights < 0 {
+		form.Errors.Add("min_nights", "This can't be negative")
+	}
+	if rule.MaxNights < 0 {
+		form.Errors.Add("max_nights", "This can't be negative")
+	}
+	if rule.MaxNights > 0 && rule.MaxNights < rule.MinNights {
+		form.Errors.Add("max_nights", "The maximum stay can't be shorter than the minimum stay")
+	}
+
+	if !form.Valid() {
+		m.renderStayRuleForm(writer, request, rule, form)
+		return
+	}
+
+	if rule.ID == 0 {
+		_, err = m.DB.InsertStayRule(rule)
+	} else {
+		err = m.DB.UpdateStayRule(rule)
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Stay rule saved")
+	http.Redirect(writer, request, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDeleteStayRule(writer http.ResponseWriter, request *http.Request) {
+	ruleID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteStayRule(ruleID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Stay rule deleted")
+	http.Redirect(writer, request, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+func (m *Repository) renderStayRuleForm(writer http.ResponseWriter, request *http.Request, rule models.StayRule, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *request, "admin-stay-rule.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"rule":     rule,
+			"rooms":    rooms,
+			"weekdays": weekdayOptions(rule.Weekdays),
+		},
+		Form: form,
+	})
+}
+
+func (m *Repository) AdminCancellationPolicies(writer http.ResponseWriter, request *http.Request) {
+	policies, err := m.DB.AllCancellationPolicies()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *request, "admin-cancellation-policies.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"policies": policies,
+			"rooms":    rooms,
+		},
+	})
+}
+
+// AdminCancellationPolicy shows the form to create (id "new") or edit a cancellation policy
+func (m *Repository) AdminCancellationPolicy(writer http.ResponseWriter, request *http.Request) {
+	policy := models.CancellationPolicy{
+		PenaltyType: models.CancellationPenaltyFirstNight,
+	}
+
+	if chi.URLParam(request, "id") != "new" {
+		policyID, err := strconv.Atoi(chi.URLParam(request, "id"))
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+
+		policy, err = m.DB.GetCancellationPolicyByID(policyID)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	m.renderCancellationPolicyForm(writer, request, policy, nil, forms.New(nil))
+}
+
+// AdminPostCancellationPolicy creates (id "new") or updates a cancellation policy and the rooms it applies to
+func (m *Repository) AdminPostCancellationPolicy(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	var policy models.CancellationPolicy
+	if chi.URLParam(request, "id") != "new" {
+		policy.ID, err = strconv.Atoi(chi.URLParam(request, "id"))
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("name", "free_days", "penalty_type")
+	form.IsInt("free_days")
+
+	policy.Name = form.Get("name")
+	policy.FreeDays, _ = strconv.Atoi(form.Get("free_days"))
+	policy.PenaltyType = form.Get("penalty_type")
+	policy.PenaltyPercent, _ = strconv.Atoi(form.Get("penalty_percent"))
+
+	if policy.FreeDays < 0 {
+		form.Errors.Add("free_days", "This can't be negative")
+	}
+	switch policy.PenaltyType {
+	case models.CancellationPenaltyFirstNight:
+		policy.PenaltyPercent = 0
+	case models.CancellationPenaltyPercent:
+		form.IsInt("penalty_percent")
+		if policy.PenaltyPercent < 0 || policy.PenaltyPercent > 100 {
+			form.Errors.Add("penalty_percent", "The penalty has to be between 0 and 100 percent")
+		}
+	default:
+		form.Errors.Add("penalty_type", "Unknown penalty type")
+	}
+
+	roomIDs := make(map[int]bool)
+	for _, value := range request.PostForm["rooms"] {
+		roomID, err := strconv.Atoi(value)
+		if err == nil {
+			roomIDs[roomID] = true
+		}
+	}
+
+	if !form.Valid() {
+		m.renderCancellationPolicyForm(writer, request, policy, roomIDs, form)
+		return
+	}
+
+	if policy.ID == 0 {
+		policy.ID, err = m.DB.InsertCancellationPolicy(policy)
+	} else {
+		err = m.DB.UpdateCancellationPolicy(policy)
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	for _, room := range rooms {
+		switch {
+		case roomIDs[room.ID] && room.CancellationPolicyID != policy.ID:
+			err = m.DB.UpdateRoomCancellationPolicy(room.ID, policy.ID)
+		case !roomIDs[room.ID] && room.CancellationPolicyID == policy.ID:
+			err = m.DB.UpdateRoomCancellationPolicy(room.ID, 0)
+		}
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Cancellation policy saved")
+	http.Redirect(writer, request, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDeleteCancellationPolicy(writer http.ResponseWriter, request *http.Request) {
+	policyID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteCancellationPolicy(policyID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Cancellation policy deleted")
+	http.Redirect(writer, request, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// renderCancellationPolicyForm shows the policy form, roomIDs keeps the checked rooms when the form is re-rendered
+func (m *Repository) renderCancellationPolicyForm(writer http.ResponseWriter, request *http.Request, policy models.CancellationPolicy, roomIDs map[int]bool, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	if roomIDs == nil {
+		roomIDs = make(map[int]bool)
+		for _, room := range rooms {
+			roomIDs[room.ID] = policy.ID != 0 && room.CancellationPolicyID == policy.ID
+		}
+	}
+
+	_ = render.Template(writer, *request, "admin-cancellation-policy.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"policy":  policy,
+			"rooms":   rooms,
+			"checked": roomIDs,
+		},
+		Form: form,
+	})
+}
+
+func (m *Repository) AdminRooms(writer http.ResponseWriter, request *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *request, "admin-rooms.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"rooms": rooms,
+		},
+	})
+}
+
+// AdminRoom shows the form to create (id "new") or edit a room
+func (m *Repository) AdminRoom(writer http.ResponseWriter, request *http.Request) {
+	room := models.Room{
+		MaxAdults: 2,
+		Active:    true,
+	}
+
+	if chi.URLParam(request, "id") != "new" {
+		roomID, err := strconv.Atoi(chi.URLParam(request, "id"))
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+
+		room, err = m.DB.GetRoomById(roomID)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	m.renderRoomForm(writer, request, room, forms.New(nil))
+}
+
+// AdminPostRoom creates (id "new") or updates a room
+func (m *Repository) AdminPostRoom(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	var room models.Room
+	if chi.URLParam(request, "id") != "new" {
+		room.ID, err = strconv.Atoi(chi.URLParam(request, "id"))
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("room_name", "max_adults", "max_children", "nightly_rate")
+	form.MinLength("room_name", 3)
+	form.IsInt("max_adults")
+	form.IsInt("max_children")
+	form.IsInt("nightly_rate")
+
+	room.RoomName = form.Get("room_name")
+	room.Description = form.Get("description")
+	room.Amenities = form.Get("amenities")
+	room.MaxAdults, _ = strconv.Atoi(form.Get("max_adults"))
+	room.MaxChildren, _ = strconv.Atoi(form.Get("max_children"))
+	room.NightlyRate, _ = strconv.Atoi(form.Get("nightly_rate"))
+	room.Active = form.Get("active") != ""
+	room.RoomTypeID, _ = strconv.Atoi(form.Get("room_type_id"))
+
+	if room.MaxAdults < 1 {
+		form.Errors.Add("max_adults", "A room sleeps at least one adult")
+	}
+	if room.MaxChildren < 0 {
+		form.Errors.Add("max_children", "This can't be negative")
+	}
+	if room.NightlyRate < 0 {
+		form.Errors.Add("nightly_rate", "This can't be negative")
+	}
+
+	if !form.Valid() {
+		m.renderRoomForm(writer, request, room, form)
+		return
+	}
+
+	if room.ID == 0 {
+		room.ID, err = m.DB.InsertRoom(room)
+	} else {
+		err = m.DB.UpdateRoom(room)
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Room saved")
+	http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminDeactivateRoom takes a room off the website, its reservations stay untouched
+func (m *Repository) AdminDeactivateRoom(writer http.ResponseWriter, request *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeactivateRoom(roomID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Room deactivated")
+	http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminMoveRoom swaps a room with its neighbour, the direction field is "up" or "down"
+func (m *Repository) AdminMoveRoom(writer http.ResponseWriter, request *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	ids := make([]int, len(rooms))
+	position := -1
+	for i, room := range rooms {
+		ids[i] = room.ID
+		if room.ID == roomID {
+			position = i
+		}
+	}
+
+	other := position + 1
+	if request.Form.Get("direction") == "up" {
+		other = position - 1
+	}
+
+	if position >= 0 && other >= 0 && other < len(ids) {
+		ids[position], ids[other] = ids[other], ids[position]
+
+		err = m.DB.ReorderRooms(ids)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+}
+
+func (m *Repository) renderRoomForm(writer http.ResponseWriter, request *http.Request, room models.Room, form *forms.Form) {
+	types, err := m.DB.AllRoomTypes()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	feedURL := ""
+	if room.ICalToken != "" {
+		scheme := "http"
+		if request.TLS != nil {
+			scheme = "https"
+		}
+		feedURL = fmt.Sprintf("%s://%s/ical/rooms/%d.ics?token=%s", scheme, request.Host, room.ID, room.ICalToken)
+	}
+
+	_ = render.Template(writer, *request, "admin-room.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"room":       room,
+			"room_types": types,
+		},
+		IntMap: map[string]int{
+			"max_upload_mb": images.MaxUploadSize >> 20,
+		},
+		StringMap: map[string]string{
+			"feed_url": feedURL,
+		},
+		Form: form,
+	})
+}
+
+// AdminRoomICalToken creates a new link for the room's calendar feed, or turns the feed off. Calendars
+// subscribed to the old link stop getting updates.
+func (m *Repository) AdminRoomICalToken(writer http.ResponseWriter, request *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	token := ""
+	flash := "The calendar feed is turned off"
+	if request.Form.Get("action") != "disable" {
+		token, err = helpers.NewToken()
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		flash = "New calendar feed link created"
+	}
+
+	err = m.DB.UpdateRoomICalToken(roomID, token)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", flash)
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d", roomID), http.StatusSeeOther)
+}
+
+// The room feeds cover the nights from icalFeedPastDays ago to icalFeedYears ahead
+const (
+	icalFeedPastDays = 30
+	icalFeedYears    = 2
+)
+
+// icalProdID identifies the app in the calendars it writes
+const icalProdID = "-//study-app//Bookings//EN"
+
+// RoomICalFeed serves the reservations and owner blocks of a room as an iCalendar feed external calendars can
+// subscribe to. It answers only to the room's current token and tells nothing about the guests.
+func (m *Repository) RoomICalFeed(writer http.ResponseWriter, request *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		http.NotFound(writer, request)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(writer, request)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	token := request.URL.Query().Get("token")
+	if room.ICalToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(room.ICalToken)) != 1 {
+		http.NotFound(writer, request)
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID,
+		today.AddDate(0, 0, -icalFeedPastDays), today.AddDate(icalFeedYears, 0, 0))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	calendar := ical.Calendar{
+		ProdID: icalProdID,
+		Name:   room.RoomName,
+		Method: "PUBLISH",
+	}
+	for _, r := range restrictions {
+		if r.RestrictionID == models.RestrictionHold {
+			continue
+		}
+		summary := "Blocked"
+		if r.ReservationID > 0 {
+			summary = "Reserved"
+		}
+		calendar.Events = append(calendar.Events, ical.Event{
+			UID:          fmt.Sprintf("room-restriction-%d@study-app", r.ID),
+			Summary:      summary,
+			Start:        r.StartDate,
+			End:          r.EndDate,
+			AllDay:       true,
+			LastModified: r.UpdatedAt,
+		})
+	}
+
+	writer.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="room-%d.ics"`, room.ID))
+	err = calendar.Encode(writer)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// AdminPostRoomPhotos uploads photos of a room. Every photo is stored as a web-sized copy and a thumbnail.
+func (m *Repository) AdminPostRoomPhotos(writer http.ResponseWriter, request *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	roomURL := fmt.Sprintf("/admin/rooms/%d", roomID)
+
+	request.Body = http.MaxBytesReader(writer, request.Body, maxPhotosPerUpload*images.MaxUploadSize)
+	err = request.ParseMultipartForm(images.MaxUploadSize)
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", "The upload is too large")
+		http.Redirect(writer, request, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	files := request.MultipartForm.File["photos"]
+	if len(files) == 0 || len(files) > maxPhotosPerUpload {
+		m.App.Session.Put(request.Context(), "error", fmt.Sprintf("Choose between 1 and %d photos", maxPhotosPerUpload))
+		http.Redirect(writer, request, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	for _, file := range files {
+		err = m.storeRoomPhoto(roomID, file)
+		if errors.Is(err, images.ErrTooLarge) || errors.Is(err, images.ErrUnsupportedType) {
+			m.App.Session.Put(request.Context(), "error", fmt.Sprintf("%s: %s", file.Filename, err))
+			http.Redirect(writer, request, roomURL, http.StatusSeeOther)
+			return
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Photos uploaded")
+	http.Redirect(writer, request, roomURL, http.StatusSeeOther)
+}
+
+// maxPhotosPerUpload limits how many photos can be uploaded at once
+const maxPhotosPerUpload = 5
+
+// storeRoomPhoto validates an uploaded image, saves its variants and adds the photo to the room
+func (m *Repository) storeRoomPhoto(roomID int, file *multipart.FileHeader) error {
+	if file.Size > images.MaxUploadSize {
+		return images.ErrTooLarge
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer func(f multipart.File) {
+		_ = f.Close()
+	}(f)
+
+	data, err := io.ReadAll(io.LimitReader(f, images.MaxUploadSize+1))
+	if err != nil {
+		return err
+	}
+
+	img, err := images.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	photo := models.RoomPhoto{RoomID: roomID}
+	key := fmt.Sprintf("rooms/%d/%d", roomID, time.Now().UnixNano())
+	variants := []struct {
+		variant images.Variant
+		path    *string
+	}{
+		{images.Web, &photo.Path},
+		{images.Thumbnail, &photo.ThumbPath},
+	}
+
+	for _, v := range variants {
+		content, err := images.Generate(img, v.variant)
+		if err != nil {
+			m.deletePhotoFiles(photo)
+			return err
+		}
+
+		*v.path, err = m.App.Storage.Save(fmt.Sprintf("%s-%s.jpg", key, v.variant.Name), bytes.NewReader(content))
+		if err != nil {
+			m.deletePhotoFiles(photo)
+			return err
+		}
+	}
+
+	_, err = m.DB.InsertRoomPhoto(photo)
+	if err != nil {
+		m.deletePhotoFiles(photo)
+	}
+	return err
+}
+
+// AdminDeleteRoomPhoto removes a photo from a room and deletes its files
+func (m *Repository) AdminDeleteRoomPhoto(writer http.ResponseWriter, request *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	photoID, err := strconv.Atoi(chi.URLParam(request, "photoID"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	roomURL := fmt.Sprintf("/admin/rooms/%d", roomID)
+
+	photo, err := m.DB.DeleteRoomPhoto(roomID, photoID)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(request.Context(), "error", "The photo doesn't exist anymore")
+		http.Redirect(writer, request, roomURL, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.deletePhotoFiles(photo)
+
+	m.App.Session.Put(request.Context(), "flash", "Photo deleted")
+	http.Redirect(writer, request, roomURL, http.StatusSeeOther)
+}
+
+// deletePhotoFiles removes the stored variants of a photo, failures only leave orphaned files behind
+func (m *Repository) deletePhotoFiles(photo models.RoomPhoto) {
+	for _, path := range []string{photo.Path, photo.ThumbPath} {
+		if path == "" {
+			continue
+		}
+		if err := m.App.Storage.Delete(path); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (m *Repository) AdminRoomTypes(writer http.ResponseWriter, request *http.Request) {
+	types, err := m.DB.AllRoomTypes()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *request, "admin-room-types.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"room_types": types,
+		},
+	})
+}
+
+// AdminRoomType shows the form to create (id "new") or edit a room type
+func (m *Repository) AdminRoomType(writer http.ResponseWriter, request *http.Request) {
+	var roomType models.RoomType
+
+	if chi.URLParam(request, "id") != "new" {
+		typeID, err := strconv.Atoi(chi.URLParam(request, "id"))
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+
+		roomType, err = m.DB.GetRoomTypeByID(typeID)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	m.renderRoomTypeForm(writer, request, roomType, forms.New(nil))
+}
+
+// AdminPostRoomType creates (id "new") or updates a room type. Its units are assigned on the room form.
+func (m *Repository) AdminPostRoomType(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	var roomType models.RoomType
+	if chi.URLParam(request, "id") != "new" {
+		roomType.ID, err = strconv.Atoi(chi.URLParam(request, "id"))
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("name")
+	form.MinLength("name", 3)
+
+	roomType.Name = form.Get("name")
+	roomType.Description = form.Get("description")
+
+	if !form.Valid() {
+		m.renderRoomTypeForm(writer, request, roomType, form)
+		return
+	}
+
+	if roomType.ID == 0 {
+		_, err = m.DB.InsertRoomType(roomType)
+	} else {
+		err = m.DB.UpdateRoomType(roomType)
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Room type saved")
+	http.Redirect(writer, request, "/admin/room-types", http.StatusSeeOther)
+}
+
+// AdminDeleteRoomType removes a room type, its units are kept as rooms of their own
+func (m *Repository) AdminDeleteRoomType(writer http.ResponseWriter, request *http.Request) {
+	typeID, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteRoomType(typeID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Room type deleted")
+	http.Redirect(writer, request, "/admin/room-types", http.StatusSeeOther)
+}
+
+func (m *Repository) renderRoomTypeForm(writer http.ResponseWriter, request *http.Request, roomType models.RoomType, form *forms.Form) {
+	var units []models.Room
+	if roomType.ID > 0 {
+		rooms, err := m.DB.AllRooms()
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+
+		for _, room := range rooms {
+			if room.RoomTypeID == roomType.ID {
+				units = append(units, room)
+			}
+		}
+	}
+
+	_ = render.Template(writer, *request, "admin-room-type.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{
+			"room_type": roomType,
+			"units":     units,
+		},
+		Form: form,
+	})
+}
+
+// MyReservation shows the form guests use to find their reservation
+func (m *Repository) MyReservation(writer http.ResponseWriter, request *http.Request) {
+	_ = render.Template(writer, *request, "my-reservation.page.gohtml", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostMyReservation looks a reservation up by confirmation code and email
+func (m *Repository) PostMyReservation(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", "cannot parse form!")
+		http.Redirect(writer, request, "/my-reservation", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("code", "email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		_ = render.Template(writer, *request, "my-reservation.page.gohtml", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(form.Get("code")))
+	reservation, err := m.DB.GetReservationByCode(code, strings.TrimSpace(form.Get("email")))
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", "We couldn't find a reservation with this code and email")
+		http.Redirect(writer, request, "/my-reservation", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "my_reservation_id", reservation.ID)
+	http.Redirect(writer, request, "/my-reservation/manage", http.StatusSeeOther)
+}
+
+// MyReservationManage shows the reservation a guest looked up
+func (m *Repository) MyReservationManage(writer http.ResponseWriter, request *http.Request) {
+	reservation, ok := m.guestReservation(writer, request)
+	if !ok {
+		return
+	}
+
+	m.renderMyReservation(writer, request, reservation, forms.New(nil))
+}
+
+// PostMyReservationDates moves the guest's reservation to new dates
+func (m *Repository) PostMyReservationDates(writer http.ResponseWriter, request *http.Request) {
+	reservation, ok := m.guestReservation(writer, request)
+	if !ok {
+		return
+	}
+
+	if !reservation.IsUpcoming() {
+		m.App.Session.Put(request.Context(), "error", "Your reservation can't be changed anymore")
+		http.Redirect(writer, request, "/my-reservation/manage", http.StatusSeeOther)
+		return
+	}
+
+	if reservation.IsGroup() {
+		m.App.Session.Put(request.Context(), "error", "Please contact us to change a reservation with several rooms")
+		http.Redirect(writer, request, "/my-reservation/manage", http.StatusSeeOther)
+		return
+	}
+
+	err := request.ParseForm()
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", "cannot parse form!")
+		http.Redirect(writer, request, "/my-reservation/manage", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("start", "end")
+	form.IsDate("start")
+	form.IsDate("end")
+
+	startDate, _ := time.Parse("2006-01-02", form.Get("start"))
+	endDate, _ := time.Parse("2006-01-02", form.Get("end"))
+	if form.Valid() && !endDate.After(startDate) {
+		form.Errors.Add("end", "The departure has to be after the arrival")
+	}
+	if form.Valid() && startDate.Before(time.Now().UTC().Truncate(24*time.Hour)) {
+		form.Errors.Add("start", "The arrival can't be in the past")
+	}
+
+	if !form.Valid() {
+		m.renderMyReservation(writer, request, reservation, form)
+		return
+	}
+
+	previous := reservationStay(reservation)
+	reservation.StartDate = startDate
+	reservation.EndDate = endDate
+
+	available, reason, err := m.DB.SearchAvailabilityForReservation(reservation)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	if !available {
+		if reason == "" {
+			reason = "The room is not available for these dates"
+		}
+		m.App.Session.Put(request.Context(), "error", reason)
+		http.Redirect(writer, request, "/my-reservation/manage", http.StatusSeeOther)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(reservation.RoomID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	price, err := m.priceForRoom(room, reservation.StartDate, reservation.EndDate)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	reservation.TotalPrice = price.Total
+
+	data := m.emailData(reservation)
+	data.Stay = reservationStay(reservation)
+	data.PreviousStay = previous
+	mails := m.guestAndOwnerMails(data, "reservation-changed", "reservation-changed-owner")
+
+	err = m.DB.UpdateReservationDates(reservation, mails)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(request.Context(), "error", "The room is not available for these dates")
+		http.Redirect(writer, request, "/my-reservation/manage", http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrGroupReservation) {
+		m.App.Session.Put(request.Context(), "error", "Please contact us to change a reservation with several rooms")
+		http.Redirect(writer, request, "/my-reservation/manage", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.wakeMailWorkers()
+
+	m.App.Session.Put(request.Context(), "flash", "Your reservation has been changed")
+	http.Redirect(writer, request, "/my-reservation/manage", http.StatusSeeOther)
+}
+
+// PostMyReservationCancel cancels the guest's reservation and frees the room
+func (m *Repository) PostMyReservationCancel(writer http.ResponseWriter, request *http.Request) {
+	reservation, ok := m.guestReservation(writer, request)
+	if !ok {
+		return
+	}
+
+	if !reservation.IsUpcoming() {
+		m.App.Session.Put(request.Context(), "error", "Your reservation can't be cancelled anymore")
+		http.Redirect(writer, request, "/my-reservation/manage", http.StatusSeeOther)
+		return
+	}
+
+	_, penalty, err := m.cancellationTerms(reservation)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	data := m.emailData(reservation)
+	data.CancellationFee = penalty
+	mails := m.guestAndOwnerMails(data, "reservation-cancelled", "reservation-cancelled-owner")
+
+	err = m.DB.CancelReservation(reservation.ID, "Cancelled by the guest", penalty, 0, mails)
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(request.Context(), "error", "Your reservation can't be cancelled anymore")
+		http.Redirect(writer, request, "/my-reservation/manage", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.wakeMailWorkers()
+
+	m.App.Session.Remove(request.Context(), "my_reservation_id")
+	m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("Your reservation has been cancelled, cancellation fee: %s", render.Money(penalty)))
+	http.Redirect(writer, request, "/", http.StatusSeeOther)
+}
+
+// guestReservation loads the reservation the guest looked up, redirecting to the lookup form when there is none
+func (m *Repository) guestReservation(writer http.ResponseWriter, request *http.Request) (models.Reservation, bool) {
+	reservationID, ok := m.App.Session.Get(request.Context(), "my_reservation_id").(int)
+	if !ok {
+		m.App.Session.Put(request.Context(), "error", "Find your reservation first")
+		http.Redirect(writer, request, "/my-reservation", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	reservation, err := m.DB.GetReservationByID(reservationID)
+	if err != nil {
+		m.App.Session.Remove(request.Context(), "my_reservation_id")
+		m.App.Session.Put(request.Context(), "error", "We couldn't find your reservation")
+		http.Redirect(writer, request, "/my-reservation", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	return reservation, true
+}
+
+func (m *Repository) renderMyReservation(writer http.ResponseWriter, request *http.Request, reservation models.Reservation, form *forms.Form) {
+	policy, penalty, err := m.cancellationTerms(reservation)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	_ = render.Template(writer, *request, "my-reservation-manage.page.gohtml", &models.TemplateData{
+		Form: form,
+		Data: map[string]interface{}{
+			"reservation": reservation,
+			"policy":      policy,
+		},
+		IntMap: map[string]int{
+			"penalty": penalty,
+		},
+		StringMap: map[string]string{
+			"StartDate": reservation.StartDate.Format("2006-01-02"),
+			"EndDate":   reservation.EndDate.Format("2006-01-02"),
+		},
+	})
+}
+
+// guestAndOwnerMails are the emails telling the guest and the owner about a change of the reservation
+func (m *Repository) guestAndOwnerMails(data *models.EmailData, guestEmail, ownerEmail string) []models.MailData {
+	var mails []models.MailData
+	if mail, ok := m.renderMail(data.Reservation.Email, guestEmail, data); ok {
+		mails = append(mails, mail)
+	}
+	if mail, ok := m.renderMail("owner@email.local", ownerEmail, data); ok {
+		mails = append(mails, mail)
+	}
+	return mails
+}
+
+// reservationStay is the room of a single room reservation with its dates and price
+func reservationStay(reservation models.Reservation) models.ReservationRoom {
+	return models.ReservationRoom{
+		ReservationID: reservation.ID,
+		RoomID:        reservation.RoomID,
+		StartDate:     reservation.StartDate,
+		EndDate:       reservation.EndDate,
+		TotalPrice:    reservation.TotalPrice,
+		Room:          reservation.Room,
+	}
+}
+
+// wakeMailWorkers tells the mail workers there is mail in the outbox, without waiting for them
+func (m *Repository) wakeMailWorkers() {
+	select {
+	case m.App.MailQueued <- struct{}{}:
+	default:
+	}
 }
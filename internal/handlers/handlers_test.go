@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/zahnah/study-app/internal/ical"
+	"github.com/zahnah/study-app/internal/images"
 	"github.com/zahnah/study-app/internal/models"
+	"image"
+	"image/png"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -35,6 +42,9 @@ var theTests = []struct {
 	{
 		"gq", "/generals", "GET", []postData{}, 200,
 	},
+	{
+		"rooms", "/rooms", "GET", []postData{}, 200,
+	},
 	{
 		"sa", "/search-availability", "GET", []postData{}, 200,
 	},
@@ -44,6 +54,12 @@ var theTests = []struct {
 	{
 		"mr", "/make-reservation", "GET", []postData{}, 200,
 	},
+	{
+		"my-reservation", "/my-reservation", "GET", []postData{}, 200,
+	},
+	{
+		"my-reservation-manage", "/my-reservation/manage", "GET", []postData{}, 200,
+	},
 
 	//{
 	//	"search-availability-post", "/search-availability", "POST", []postData{
@@ -160,6 +176,30 @@ func TestRepository_MakeReservation(t *testing.T) {
 		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
 
+	// several rooms are priced together
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+
+	reservation = models.Reservation{
+		Rooms: []models.ReservationRoom{
+			{RoomID: 1, StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)},
+			{RoomID: 2, StartDate: time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	session.Put(ctx, "reservation", reservation)
+
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	reservation, _ = session.Get(ctx, "reservation").(models.Reservation)
+	if reservation.TotalPrice != 50000 || reservation.RoomID != 1 || !reservation.EndDate.Equal(time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("the rooms have to be summarized into the reservation, got %d for room %d until %s",
+			reservation.TotalPrice, reservation.RoomID, reservation.EndDate.Format("2006-01-02"))
+	}
+
 	// non existent room
 	req, _ = http.NewRequest("GET", "/make-reservation", nil)
 	ctx = getCtx(req)
@@ -198,6 +238,7 @@ func TestRepository_PostMakeReservation(t *testing.T) {
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	rr := httptest.NewRecorder()
+	mailbox.Reset()
 
 	handler := http.HandlerFunc(Repo.PostMakeReservation)
 	handler.ServeHTTP(rr, req)
@@ -205,6 +246,23 @@ func TestRepository_PostMakeReservation(t *testing.T) {
 		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
+	mails := mailbox.Messages()
+	if len(mails) != 2 {
+		t.Fatalf("expected a confirmation for the guest and one for the owner, got %d emails", len(mails))
+	}
+	if mails[0].To != "smith@email.local" || mails[0].Subject != "Reservation Confirmation" || mails[0].From != "me@local.local" {
+		t.Errorf("unexpected guest email to %s: %s", mails[0].To, mails[0].Subject)
+	}
+	if !strings.Contains(mails[0].Content, "Dear John") || !strings.Contains(mails[0].Text, "Dear John") {
+		t.Errorf("the guest email doesn't greet the guest: %s", mails[0].Text)
+	}
+	if len(mails[0].Attachments) != 1 || mails[0].Attachments[0].Name != "reservation.ics" {
+		t.Errorf("expected the guest email to carry the invitation, got %d attachments", len(mails[0].Attachments))
+	}
+	if mails[1].To != "owner@email.local" || len(mails[1].Attachments) != 0 {
+		t.Errorf("unexpected owner email to %s", mails[1].To)
+	}
+
 	// missed post body
 	req, _ = http.NewRequest("POST", "/make-reservation", nil)
 	ctx = getCtx(req)
@@ -283,7 +341,7 @@ func TestRepository_PostMakeReservation(t *testing.T) {
 		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
 
-	// cannot save room restriction
+	// room was booked by someone else in the meantime
 	reservation = models.Reservation{
 		RoomID:    2,
 		StartDate: time.Now(),
@@ -303,11 +361,129 @@ func TestRepository_PostMakeReservation(t *testing.T) {
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	rr = httptest.NewRecorder()
+	mailbox.Reset()
 
 	handler = http.HandlerFunc(Repo.PostMakeReservation)
 	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if location := rr.Header().Get("Location"); location != "/search-availability" {
+		t.Errorf("Reservation handler redirected to %s, wanted %s", location, "/search-availability")
+	}
+	if mails := mailbox.Messages(); len(mails) != 0 {
+		t.Errorf("expected no email for a reservation that wasn't made, got %d", len(mails))
+	}
+
+	// the party doesn't fit in the room
+	reservation = models.Reservation{
+		RoomID:    1,
+		StartDate: time.Now(),
+		EndDate:   time.Now().AddDate(0, 0, 2),
+		Adults:    2,
+		Children:  2,
+	}
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "reservation", reservation)
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostMakeReservation)
+	handler.ServeHTTP(rr, req)
+	if location := rr.Header().Get("Location"); location != "/search-availability" {
+		t.Errorf("Reservation handler redirected to %s, wanted %s", location, "/search-availability")
+	}
+	if mails := mailbox.Messages(); len(mails) != 0 {
+		t.Errorf("expected no email for a reservation that wasn't made, got %d", len(mails))
+	}
+
+	// a stay rule was added since the search
+	reservation = models.Reservation{
+		RoomID:    1,
+		StartDate: time.Now(),
+		EndDate:   time.Now().AddDate(0, 0, 60),
+	}
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "reservation", reservation)
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostMakeReservation)
+	handler.ServeHTTP(rr, req)
+	if location := rr.Header().Get("Location"); location != "/search-availability" {
+		t.Errorf("Reservation handler redirected to %s, wanted %s", location, "/search-availability")
+	}
+	if mails := mailbox.Messages(); len(mails) != 0 {
+		t.Errorf("expected no email for a reservation that wasn't made, got %d", len(mails))
+	}
+
+	// one of several rooms was booked by someone else in the meantime
+	reservation = models.Reservation{
+		Rooms: []models.ReservationRoom{
+			{RoomID: 1, StartDate: time.Now(), EndDate: time.Now().AddDate(0, 0, 2)},
+			{RoomID: 2, StartDate: time.Now(), EndDate: time.Now().AddDate(0, 0, 2)},
+		},
+	}
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "reservation", reservation)
+	session.Put(ctx, "hold_ids", []int{1, 2})
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostMakeReservation)
+	handler.ServeHTTP(rr, req)
+	if location := rr.Header().Get("Location"); location != "/search-availability" {
+		t.Errorf("Reservation handler redirected to %s, wanted %s", location, "/search-availability")
+	}
+	if session.Exists(ctx, "hold_ids") {
+		t.Error("the holds have to be released when the booking fails")
+	}
+
+	// the party doesn't fit in all the rooms together
+	reservation = models.Reservation{
+		Rooms: []models.ReservationRoom{
+			{RoomID: 1, StartDate: time.Now(), EndDate: time.Now().AddDate(0, 0, 2)},
+			{RoomID: 1, StartDate: time.Now(), EndDate: time.Now().AddDate(0, 0, 2)},
+		},
+		Adults: 5,
+	}
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "reservation", reservation)
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	mailbox.Reset()
+
+	handler = http.HandlerFunc(Repo.PostMakeReservation)
+	handler.ServeHTTP(rr, req)
+	if location := rr.Header().Get("Location"); location != "/search-availability" {
+		t.Errorf("Reservation handler redirected to %s, wanted %s", location, "/search-availability")
+	}
+	expected := "The rooms you picked sleep at most 4 adults, 2 children together"
+	if message := session.PopString(ctx, "error"); message != expected {
+		t.Errorf("expected error %q, got %q", expected, message)
+	}
+	if mails := mailbox.Messages(); len(mails) != 0 {
+		t.Errorf("expected no email for a reservation that wasn't made, got %d", len(mails))
 	}
 }
 
@@ -402,3 +578,1987 @@ func getCtx(req *http.Request) context.Context {
 
 	return ctx
 }
+
+func TestRepository_AdminRates(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/rates?y=2050&m=01", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminRates)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminRates handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_AdminPostRate(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		params             url.Values
+		expectedStatusCode int
+	}{
+		{
+			"valid new rule", "new", url.Values{
+				"name":        {"Weekend"},
+				"room_id":     {"0"},
+				"start_date":  {"2050-01-01"},
+				"end_date":    {"2050-12-31"},
+				"weekdays":    {"5", "6"},
+				"priority":    {"1"},
+				"amount_type": {"percent"},
+				"amount":      {"20"},
+			}, http.StatusSeeOther,
+		},
+		{
+			"valid update", "1", url.Values{
+				"name":        {"Christmas"},
+				"room_id":     {"1"},
+				"start_date":  {"2050-12-24"},
+				"end_date":    {"2050-12-26"},
+				"weekdays":    {"0", "1", "2", "3", "4", "5", "6"},
+				"priority":    {"10"},
+				"amount_type": {"fixed"},
+				"amount":      {"25000"},
+			}, http.StatusSeeOther,
+		},
+		{
+			"end before start", "new", url.Values{
+				"name":        {"Broken"},
+				"start_date":  {"2050-12-31"},
+				"end_date":    {"2050-01-01"},
+				"weekdays":    {"1"},
+				"priority":    {"1"},
+				"amount_type": {"percent"},
+				"amount":      {"20"},
+			}, http.StatusOK,
+		},
+		{
+			"no weekdays", "new", url.Values{
+				"name":        {"Broken"},
+				"start_date":  {"2050-01-01"},
+				"end_date":    {"2050-01-31"},
+				"priority":    {"1"},
+				"amount_type": {"percent"},
+				"amount":      {"20"},
+			}, http.StatusOK,
+		},
+		{
+			"not a number", "new", url.Values{
+				"name":        {"Broken"},
+				"start_date":  {"2050-01-01"},
+				"end_date":    {"2050-01-31"},
+				"weekdays":    {"1"},
+				"priority":    {"1"},
+				"amount_type": {"fixed"},
+				"amount":      {"12.50"},
+			}, http.StatusOK,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rates/"+e.id, strings.NewReader(e.params.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(addURLParam(ctx, "id", e.id))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRate)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostRate handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func addURLParam(ctx context.Context, key, value string) context.Context {
+	rctx, ok := ctx.Value(chi.RouteCtxKey).(*chi.Context)
+	if !ok {
+		rctx = chi.NewRouteContext()
+	}
+	rctx.URLParams.Add(key, value)
+	return context.WithValue(ctx, chi.RouteCtxKey, rctx)
+}
+
+func TestRepository_PostAvailabilityJSON_Reason(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("start", "2050-01-02")
+	postedData.Add("end", "2050-01-03")
+	postedData.Add("room_id", "2")
+
+	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostAvailabilityJSON)
+	handler.ServeHTTP(rr, req)
+
+	var j jsonResponse
+	err := json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("Failed parse request")
+	}
+	if j.OK {
+		t.Error("room breaking a stay rule shouldn't be available")
+	}
+	if j.Reason != "The minimum stay is 3 nights" {
+		t.Errorf("Wrong reason, expected '%s', received '%s'", "The minimum stay is 3 nights", j.Reason)
+	}
+}
+
+func TestRepository_PostAvailabilityJSON_Capacity(t *testing.T) {
+	tests := []struct {
+		name           string
+		adults         string
+		children       string
+		expectedOK     bool
+		expectedReason string
+	}{
+		{"fits", "2", "1", true, ""},
+		{"too many adults", "3", "0", false, "The room sleeps at most 2 adults, 1 child"},
+		{"too many guests", "2", "2", false, "The room sleeps at most 2 adults, 1 child"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("start", "2051-01-02")
+		postedData.Add("end", "2051-01-03")
+		postedData.Add("room_id", "1")
+		postedData.Add("adults", e.adults)
+		postedData.Add("children", e.children)
+
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostAvailabilityJSON)
+		handler.ServeHTTP(rr, req)
+
+		var j jsonResponse
+		err := json.Unmarshal([]byte(rr.Body.String()), &j)
+		if err != nil {
+			t.Errorf("%s: failed parse request", e.name)
+		}
+		if j.OK != e.expectedOK {
+			t.Errorf("%s: expected ok %t, got %t", e.name, e.expectedOK, j.OK)
+		}
+		if j.Reason != e.expectedReason {
+			t.Errorf("%s: wrong reason, expected '%s', received '%s'", e.name, e.expectedReason, j.Reason)
+		}
+	}
+}
+
+func TestGuestCounts(t *testing.T) {
+	tests := []struct {
+		values           url.Values
+		expectedAdults   int
+		expectedChildren int
+	}{
+		{url.Values{"adults": {"2"}, "children": {"3"}}, 2, 3},
+		{url.Values{}, 1, 0},
+		{url.Values{"adults": {"0"}, "children": {"-1"}}, 1, 0},
+		{url.Values{"adults": {"two"}}, 1, 0},
+	}
+
+	for _, e := range tests {
+		adults, children := guestCounts(e.values)
+		if adults != e.expectedAdults || children != e.expectedChildren {
+			t.Errorf("%v: expected %d adults and %d children, got %d and %d",
+				e.values, e.expectedAdults, e.expectedChildren, adults, children)
+		}
+	}
+}
+
+func TestRepository_AdminPostStayRule(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		params             url.Values
+		expectedStatusCode int
+	}{
+		{
+			"valid new rule", "new", url.Values{
+				"room_id":           {"1"},
+				"start_date":        {"2050-07-01"},
+				"end_date":          {"2050-08-31"},
+				"weekdays":          {"6"},
+				"min_nights":        {"7"},
+				"max_nights":        {"0"},
+				"closed_to_arrival": {""},
+			}, http.StatusSeeOther,
+		},
+		{
+			"valid update", "1", url.Values{
+				"start_date":          {"2050-01-01"},
+				"end_date":            {"2050-01-01"},
+				"weekdays":            {"0", "1", "2", "3", "4", "5", "6"},
+				"min_nights":          {"0"},
+				"max_nights":          {"0"},
+				"closed_to_departure": {"1"},
+			}, http.StatusSeeOther,
+		},
+		{
+			"max shorter than min", "new", url.Values{
+				"start_date": {"2050-01-01"},
+				"end_date":   {"2050-01-31"},
+				"weekdays":   {"1"},
+				"min_nights": {"5"},
+				"max_nights": {"2"},
+			}, http.StatusOK,
+		},
+		{
+			"invalid date", "new", url.Values{
+				"start_date": {"2050-01-01"},
+				"end_date":   {"soon"},
+				"weekdays":   {"1"},
+				"min_nights": {"0"},
+				"max_nights": {"0"},
+			}, http.StatusOK,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/stay-rules/"+e.id, strings.NewReader(e.params.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(addURLParam(ctx, "id", e.id))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostStayRule)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostStayRule handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_PostMyReservation(t *testing.T) {
+	tests := []struct {
+		name             string
+		code             string
+		email            string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"found", " abcdefghjklm ", "guest@email.local", http.StatusSeeOther, "/my-reservation/manage"},
+		{"wrong code", "ZZZZZZZZZZZZ", "guest@email.local", http.StatusSeeOther, "/my-reservation"},
+		{"invalid email", "ABCDEFGHJKLM", "guest", http.StatusOK, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("code", e.code)
+		postedData.Add("email", e.email)
+
+		req, _ := http.NewRequest("POST", "/my-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostMyReservation)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: PostMyReservation handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: PostMyReservation handler redirected to %s, wanted %s", e.name, location, e.expectedLocation)
+		}
+		if e.name == "found" && session.GetInt(ctx, "my_reservation_id") != 1 {
+			t.Errorf("%s: reservation id is not stored in the session", e.name)
+		}
+	}
+}
+
+func TestRepository_PostMyReservationDates(t *testing.T) {
+	tests := []struct {
+		name             string
+		inSession        bool
+		start            string
+		end              string
+		expectedStatus   int
+		expectedLocation string
+		expectedMails    int
+	}{
+		{"changed", true, "2050-02-01", "2050-02-03", http.StatusSeeOther, "/my-reservation/manage", 2},
+		{"not available", true, "2051-02-01", "2051-02-03", http.StatusSeeOther, "/my-reservation/manage", 0},
+		{"departure before arrival", true, "2050-02-03", "2050-02-01", http.StatusOK, "", 0},
+		{"arrival in the past", true, "1999-02-01", "1999-02-03", http.StatusOK, "", 0},
+		{"no reservation in session", false, "2050-02-01", "2050-02-03", http.StatusSeeOther, "/my-reservation", 0},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("start", e.start)
+		postedData.Add("end", e.end)
+
+		req, _ := http.NewRequest("POST", "/my-reservation/dates", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		if e.inSession {
+			session.Put(ctx, "my_reservation_id", 1)
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		mailbox.Reset()
+
+		handler := http.HandlerFunc(Repo.PostMyReservationDates)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: PostMyReservationDates handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: PostMyReservationDates handler redirected to %s, wanted %s", e.name, location, e.expectedLocation)
+		}
+		if mails := mailbox.Messages(); len(mails) != e.expectedMails {
+			t.Errorf("%s: expected %d emails, got %d", e.name, e.expectedMails, len(mails))
+		}
+	}
+}
+
+func TestRepository_PostMyReservationCancel(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/my-reservation/cancel", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "my_reservation_id", 1)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostMyReservationCancel)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostMyReservationCancel handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if session.Exists(ctx, "my_reservation_id") {
+		t.Error("reservation id has to be removed from the session after cancelling")
+	}
+}
+
+func TestRepository_AdminCancelReservation(t *testing.T) {
+	tests := []struct {
+		name             string
+		id               string
+		year             string
+		expectedStatus   int
+		expectedLocation string
+		expectedMails    int
+	}{
+		{"cancelled", "1", "", http.StatusSeeOther, "/admin/reservations/all", 1},
+		{"cancelled from the calendar", "1", "2050", http.StatusSeeOther, "/admin/reservations/calendar?y=2050&m=01", 1},
+		{"already cancelled", "2", "", http.StatusSeeOther, "/admin/reservations/all/2", 0},
+		{"missing reservation", "3", "", http.StatusTemporaryRedirect, "", 0},
+		{"invalid id", "x", "", http.StatusTemporaryRedirect, "", 0},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("reason", "Guest called")
+		if e.year != "" {
+			postData.Add("year", e.year)
+			postData.Add("month", "01")
+		}
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/cancel", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "src", "all")
+		ctx = addURLParam(ctx, "id", e.id)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		mailbox.Reset()
+
+		handler := http.HandlerFunc(Repo.AdminCancelReservation)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: AdminCancelReservation handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: AdminCancelReservation handler redirected to %s, wanted %s", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+		mails := mailbox.Messages()
+		if len(mails) != e.expectedMails {
+			t.Errorf("%s: expected %d emails, got %d", e.name, e.expectedMails, len(mails))
+		} else if len(mails) > 0 && mails[0].Subject != "Reservation cancelled" {
+			t.Errorf("%s: unexpected email %s", e.name, mails[0].Subject)
+		}
+	}
+}
+
+func TestRepository_AdminPostCancellationPolicy(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		postData           url.Values
+		expectedStatusCode int
+	}{
+		{
+			name: "valid first night",
+			id:   "new",
+			postData: url.Values{
+				"name":         {"Moderate"},
+				"free_days":    {"7"},
+				"penalty_type": {"first_night"},
+				"rooms":        {"1", "2"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+		},
+		{
+			name: "valid percent",
+			id:   "1",
+			postData: url.Values{
+				"name":            {"Strict"},
+				"free_days":       {"14"},
+				"penalty_type":    {"percent"},
+				"penalty_percent": {"50"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+		},
+		{
+			name: "percent out of range",
+			id:   "new",
+			postData: url.Values{
+				"name":            {"Strict"},
+				"free_days":       {"14"},
+				"penalty_type":    {"percent"},
+				"penalty_percent": {"150"},
+			},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "unknown penalty type",
+			id:   "new",
+			postData: url.Values{
+				"name":         {"Strict"},
+				"free_days":    {"14"},
+				"penalty_type": {"everything"},
+			},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "missing name",
+			id:   "new",
+			postData: url.Values{
+				"free_days":    {"14"},
+				"penalty_type": {"first_night"},
+			},
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/cancellation-policies/"+e.id, strings.NewReader(e.postData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.id)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCancellationPolicy)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostCancellationPolicy handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminReservationStatus(t *testing.T) {
+	tests := []struct {
+		name             string
+		id               string
+		status           string
+		year             string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"confirmed", "1", "confirmed", "", http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"confirmed from the calendar", "1", "confirmed", "2050", http.StatusSeeOther, "/admin/reservations/all/1?y=2050&m=01"},
+		{"not allowed", "1", "checked_out", "", http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"cancelled is not a plain status change", "1", "cancelled", "", http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"unknown status", "1", "processed", "", http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"final status", "2", "confirmed", "", http.StatusSeeOther, "/admin/reservations/all/2"},
+		{"missing reservation", "3", "confirmed", "", http.StatusTemporaryRedirect, ""},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("status", e.status)
+		if e.year != "" {
+			postData.Add("year", e.year)
+			postData.Add("month", "01")
+		}
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/status", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "src", "all")
+		ctx = addURLParam(ctx, "id", e.id)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationStatus)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: AdminReservationStatus handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: AdminReservationStatus handler redirected to %s, wanted %s", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+
+		flash := session.GetString(ctx, "flash")
+		if e.name == "confirmed" && flash == "" {
+			t.Errorf("%s: expected a flash message", e.name)
+		}
+		if e.name == "not allowed" && flash != "" {
+			t.Errorf("%s: the status must not change", e.name)
+		}
+	}
+}
+
+func TestStatusFilter(t *testing.T) {
+	var tests = []struct {
+		query    string
+		expected models.ReservationStatus
+	}{
+		{"", ""},
+		{"?status=checked_in", models.ReservationCheckedIn},
+		{"?status=processed", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/all"+e.query, nil)
+		if status := statusFilter(req); status != e.expected {
+			t.Errorf("%s: expected '%s', got '%s'", e.query, e.expected, status)
+		}
+	}
+}
+
+func TestRepository_ChooseRoom(t *testing.T) {
+	tests := []struct {
+		name             string
+		roomID           string
+		expectedStatus   int
+		expectedLocation string
+		held             bool
+	}{
+		{"held", "1", http.StatusSeeOther, "/make-reservation", true},
+		{"already taken", "2", http.StatusSeeOther, "/search-availability", false},
+		{"missing room", "3", http.StatusTemporaryRedirect, "", false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/choose-room/"+e.roomID, nil)
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.roomID)
+		session.Put(ctx, "reservation", models.Reservation{
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		})
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ChooseRoom)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: ChooseRoom handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: ChooseRoom handler redirected to %s, wanted %s", e.name, location, e.expectedLocation)
+		}
+		holdIDs, _ := session.Get(ctx, "hold_ids").([]int)
+		if held := len(holdIDs) > 0; held != e.held {
+			t.Errorf("%s: expected the room to be held %t, got %t", e.name, e.held, held)
+		}
+	}
+}
+
+func TestRepository_BookRoom(t *testing.T) {
+	tests := []struct {
+		name             string
+		url              string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"room is free", "/book-room?id=1&start_date=2051-01-01&end_date=2051-01-03", http.StatusTemporaryRedirect, "/make-reservation"},
+		{"room is taken", "/book-room?id=1&start_date=2050-01-01&end_date=2050-01-03", http.StatusSeeOther, "/search-availability"},
+		{"stay rule is broken", "/book-room?id=2&start_date=2051-01-01&end_date=2051-01-02", http.StatusSeeOther, "/search-availability"},
+		{"party is too large", "/book-room?id=1&start_date=2051-01-01&end_date=2051-01-03&adults=3", http.StatusSeeOther, "/search-availability"},
+		{"missing room", "/book-room?id=3&start_date=2051-01-01&end_date=2051-01-03", http.StatusTemporaryRedirect, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "hold_ids", []int{5, 6})
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.BookRoom)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: BookRoom handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: BookRoom handler redirected to %s, wanted %s", e.name, location, e.expectedLocation)
+		}
+		if e.expectedLocation != "/make-reservation" {
+			continue
+		}
+		if holdIDs, _ := session.Get(ctx, "hold_ids").([]int); len(holdIDs) != 1 || holdIDs[0] != 1 {
+			t.Errorf("%s: the previous hold has to be replaced by the new one", e.name)
+		}
+		if session.GetString(ctx, "hold_expires_at") == "" {
+			t.Errorf("%s: the hold expiry has to be stored in the session", e.name)
+		}
+	}
+}
+
+func TestRepository_PostChooseRooms(t *testing.T) {
+	tests := []struct {
+		name             string
+		postedData       url.Values
+		expectedStatus   int
+		expectedLocation string
+		expectedRooms    int
+	}{
+		{
+			"rooms chosen",
+			url.Values{
+				"rooms":   {"1"},
+				"start_1": {"2051-01-01"},
+				"end_1":   {"2051-01-04"},
+			},
+			http.StatusSeeOther, "/make-reservation", 1,
+		},
+		{"nothing chosen", url.Values{}, http.StatusSeeOther, "/search-availability", 0},
+		{
+			"departure before arrival",
+			url.Values{
+				"rooms":   {"1"},
+				"start_1": {"2051-01-04"},
+				"end_1":   {"2051-01-01"},
+			},
+			http.StatusSeeOther, "/search-availability", 0,
+		},
+		{
+			"one room is not available",
+			url.Values{
+				"rooms":   {"1", "2"},
+				"start_1": {"2051-01-01"},
+				"end_1":   {"2051-01-04"},
+				"start_2": {"2051-01-01"},
+				"end_2":   {"2051-01-02"},
+			},
+			http.StatusSeeOther, "/search-availability", 0,
+		},
+		{
+			"several units of a type",
+			url.Values{
+				"rooms":   {"1"},
+				"count_1": {"2"},
+			},
+			http.StatusSeeOther, "/make-reservation", 2,
+		},
+		{
+			"more units than are free",
+			url.Values{
+				"rooms":   {"1"},
+				"count_1": {"2000000000"},
+			},
+			http.StatusSeeOther, "/search-availability", 0,
+		},
+		{
+			"missing room",
+			url.Values{
+				"rooms": {"3"},
+			},
+			http.StatusTemporaryRedirect, "", 0,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/choose-room", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", models.Reservation{
+			StartDate: time.Date(2051, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2051, 1, 5, 0, 0, 0, 0, time.UTC),
+		})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostChooseRooms)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: PostChooseRooms handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: PostChooseRooms handler redirected to %s, wanted %s", e.name, location, e.expectedLocation)
+		}
+		if holdIDs, _ := session.Get(ctx, "hold_ids").([]int); len(holdIDs) != e.expectedRooms {
+			t.Errorf("%s: expected %d room(s) to be held, got %d", e.name, e.expectedRooms, len(holdIDs))
+		}
+	}
+}
+
+func TestRepository_PostChooseRooms_Capacity(t *testing.T) {
+	tests := []struct {
+		name             string
+		count            string
+		adults           int
+		children         int
+		expectedLocation string
+		expectedError    string
+	}{
+		{"fits in one room", "1", 2, 1, "/make-reservation", ""},
+		{"too many for one room", "1", 3, 0, "/search-availability", "The room sleeps at most 2 adults, 1 child"},
+		{"fits in two rooms", "2", 3, 3, "/make-reservation", ""},
+		{"too many for two rooms", "2", 5, 0, "/search-availability", "The rooms you picked sleep at most 4 adults, 2 children together"},
+		{"too many children for two rooms", "2", 4, 3, "/search-availability", "The rooms you picked sleep at most 4 adults, 2 children together"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"rooms": {"1"}, "count_1": {e.count}}
+		req, _ := http.NewRequest("POST", "/choose-room", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", models.Reservation{
+			StartDate: time.Date(2051, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2051, 1, 5, 0, 0, 0, 0, time.UTC),
+			Adults:    e.adults,
+			Children:  e.children,
+		})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostChooseRooms)
+		handler.ServeHTTP(rr, req)
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: PostChooseRooms handler redirected to %s, wanted %s", e.name, location, e.expectedLocation)
+		}
+		if message := session.PopString(ctx, "error"); message != e.expectedError {
+			t.Errorf("%s: expected error %q, got %q", e.name, e.expectedError, message)
+		}
+	}
+}
+
+func TestRepository_Room(t *testing.T) {
+	tests := []struct {
+		name           string
+		roomID         string
+		expectedStatus int
+	}{
+		{"existing room", "1", http.StatusOK},
+		{"missing room", "3", http.StatusNotFound},
+		{"invalid id", "generals", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/rooms/"+e.roomID, nil)
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.roomID)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.Room)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: Room handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoom(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		postData           url.Values
+		expectedStatusCode int
+	}{
+		{
+			name: "new room",
+			id:   "new",
+			postData: url.Values{
+				"room_name":    {"Colonel's Loft"},
+				"description":  {"Under the roof"},
+				"amenities":    {"Wi-Fi\nSkylight"},
+				"max_adults":   {"2"},
+				"max_children": {"2"},
+				"nightly_rate": {"15000"},
+				"photos":       {"/static/room-images/generals-quarters.png"},
+				"active":       {"1"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+		},
+		{
+			name: "existing room",
+			id:   "1",
+			postData: url.Values{
+				"room_name":    {"General's Quarters"},
+				"max_adults":   {"2"},
+				"max_children": {"0"},
+				"nightly_rate": {"10000"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+		},
+		{
+			name: "missing name",
+			id:   "new",
+			postData: url.Values{
+				"max_adults":   {"2"},
+				"max_children": {"0"},
+				"nightly_rate": {"10000"},
+			},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "no adults",
+			id:   "new",
+			postData: url.Values{
+				"room_name":    {"Cadet's Cabin"},
+				"max_adults":   {"0"},
+				"max_children": {"0"},
+				"nightly_rate": {"10000"},
+			},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "missing room",
+			id:   "3",
+			postData: url.Values{
+				"room_name":    {"Cadet's Cabin"},
+				"max_adults":   {"1"},
+				"max_children": {"0"},
+				"nightly_rate": {"10000"},
+			},
+			expectedStatusCode: http.StatusTemporaryRedirect,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id, strings.NewReader(e.postData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.id)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoom)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostRoom handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminMoveRoom(t *testing.T) {
+	tests := []struct {
+		name      string
+		roomID    string
+		direction string
+	}{
+		{"move down", "1", "down"},
+		{"move up", "2", "up"},
+		{"already first", "1", "up"},
+		{"already last", "2", "down"},
+		{"missing room", "3", "up"},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{"direction": {e.direction}}
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.roomID+"/move", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.roomID)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminMoveRoom)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminMoveRoom handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomPhotos(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	var photo bytes.Buffer
+	if err := png.Encode(&photo, img); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		roomID        string
+		files         map[string][]byte
+		expectedKey   string
+		expectedValue string
+	}{
+		{"valid photo", "1", map[string][]byte{"room.png": photo.Bytes()}, "flash", "Photos uploaded"},
+		{"not an image", "1", map[string][]byte{"notes.txt": []byte("not an image")}, "error", "notes.txt: " + images.ErrUnsupportedType.Error()},
+		{"no photo", "1", map[string][]byte{}, "error", "Choose between 1 and 5 photos"},
+	}
+
+	for _, e := range tests {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for filename, content := range e.files {
+			part, err := writer.CreateFormFile("photos", filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = part.Write(content)
+		}
+		_ = writer.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.roomID+"/photos", &body)
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.roomID)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomPhotos)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminPostRoomPhotos handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if value := session.GetString(ctx, e.expectedKey); value != e.expectedValue {
+			t.Errorf("%s: expected %s '%s', got '%s'", e.name, e.expectedKey, e.expectedValue, value)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoomPhoto(t *testing.T) {
+	tests := []struct {
+		name        string
+		photoID     string
+		expectedKey string
+	}{
+		{"existing photo", "1", "flash"},
+		{"missing photo", "5", "error"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/photos/"+e.photoID+"/delete", nil)
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", "1")
+		ctx = addURLParam(ctx, "photoID", e.photoID)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteRoomPhoto)
+		handler.ServeHTTP(rr, req)
+		if location := rr.Header().Get("Location"); location != "/admin/rooms/1" {
+			t.Errorf("%s: AdminDeleteRoomPhoto handler redirected to %s, wanted %s", e.name, location, "/admin/rooms/1")
+		}
+		if !session.Exists(ctx, e.expectedKey) {
+			t.Errorf("%s: expected a %s message", e.name, e.expectedKey)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomType(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		postedData     url.Values
+		expectedStatus int
+	}{
+		{"new room type", "new", url.Values{"name": {"Standard double"}}, http.StatusSeeOther},
+		{"existing room type", "1", url.Values{"name": {"Standard double"}, "description": {"Two beds"}}, http.StatusSeeOther},
+		{"name too short", "new", url.Values{"name": {"SD"}}, http.StatusOK},
+		{"invalid id", "x", url.Values{"name": {"Standard double"}}, http.StatusTemporaryRedirect},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/room-types/"+e.id, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.id)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomType)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: AdminPostRoomType handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestRepository_AdminReassignReservationRoom(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		stayID        string
+		roomID        string
+		expectedKey   string
+		expectedValue string
+	}{
+		{"free unit", "1", "1", "2", "flash", "Room changed"},
+		{"unit that isn't free", "1", "1", "3", "error", "That room isn't free for these dates"},
+		{"room of another reservation", "1", "2", "2", "error", "This room of the reservation can't be changed"},
+		{"cancelled reservation", "2", "2", "2", "error", "This room of the reservation can't be changed"},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{"room_id": {e.roomID}}
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/rooms/"+e.stayID, strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "src", "all")
+		ctx = addURLParam(ctx, "id", e.id)
+		ctx = addURLParam(ctx, "stayID", e.stayID)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReassignReservationRoom)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminReassignReservationRoom handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if location := rr.Header().Get("Location"); location != "/admin/reservations/all/"+e.id {
+			t.Errorf("%s: AdminReassignReservationRoom handler redirected to %s", e.name, location)
+		}
+		if value := session.PopString(ctx, e.expectedKey); value != e.expectedValue {
+			t.Errorf("%s: expected %s %q, got %q", e.name, e.expectedKey, e.expectedValue, value)
+		}
+	}
+}
+
+func TestRepository_AdminMoveReservation(t *testing.T) {
+	tests := []struct {
+		name             string
+		id               string
+		src              string
+		postedData       url.Values
+		expectedLocation string
+		expectedKey      string
+		expectedValue    string
+	}{
+		{
+			"later dates",
+			"1", "all",
+			url.Values{"stay_id": {"1"}, "room_id": {"1"}, "start_date": {"2050-01-05"}, "end_date": {"2050-01-08"}},
+			"/admin/reservations/all/1", "flash", "Reservation moved, the new total price is $300.00",
+		},
+		{
+			"keeping the price",
+			"1", "all",
+			url.Values{"stay_id": {"1"}, "room_id": {"1"}, "start_date": {"2050-01-05"}, "end_date": {"2050-01-08"}, "keep_price": {"1"}},
+			"/admin/reservations/all/1", "flash", "Reservation moved, the new total price is $200.00",
+		},
+		{
+			"from the calendar",
+			"1", "cal",
+			url.Values{"stay_id": {"1"}, "room_id": {"1"}, "start_date": {"2050-02-05"}, "end_date": {"2050-02-06"}, "year": {"2050"}, "month": {"01"}},
+			"/admin/reservations/calendar?y=2050&m=2", "flash", "Reservation moved, the new total price is $100.00",
+		},
+		{
+			"room taken",
+			"1", "all",
+			url.Values{"stay_id": {"1"}, "room_id": {"2"}, "start_date": {"2050-01-01"}, "end_date": {"2050-01-03"}},
+			"/admin/reservations/all/1", "error", "General's Quarters is not available for these dates",
+		},
+		{
+			"unknown room",
+			"1", "all",
+			url.Values{"stay_id": {"1"}, "room_id": {"3"}, "start_date": {"2050-01-01"}, "end_date": {"2050-01-03"}},
+			"/admin/reservations/all/1", "error", "Room not found",
+		},
+		{
+			"departure before arrival",
+			"1", "cal",
+			url.Values{"stay_id": {"1"}, "room_id": {"1"}, "start_date": {"2050-01-05"}, "end_date": {"2050-01-05"}, "year": {"2050"}, "month": {"01"}},
+			"/admin/reservations/cal/1?y=2050&m=01", "error", "Please choose a room, an arrival and a later departure",
+		},
+		{
+			"room of another reservation",
+			"1", "all",
+			url.Values{"stay_id": {"2"}, "room_id": {"1"}, "start_date": {"2050-01-05"}, "end_date": {"2050-01-08"}},
+			"/admin/reservations/all/1", "error", "This room of the reservation can't be moved",
+		},
+		{
+			"cancelled reservation",
+			"2", "all",
+			url.Values{"stay_id": {"2"}, "room_id": {"1"}, "start_date": {"2050-01-05"}, "end_date": {"2050-01-08"}},
+			"/admin/reservations/all/2", "error", "This room of the reservation can't be moved",
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/"+e.src+"/"+e.id+"/move", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "src", e.src)
+		ctx = addURLParam(ctx, "id", e.id)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminMoveReservation)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminMoveReservation handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: AdminMoveReservation handler redirected to %s, wanted %s", e.name, location, e.expectedLocation)
+		}
+		if value := session.PopString(ctx, e.expectedKey); value != e.expectedValue {
+			t.Errorf("%s: expected %s %q, got %q", e.name, e.expectedKey, e.expectedValue, value)
+		}
+	}
+}
+
+func TestRepository_AdminPostBlock(t *testing.T) {
+	tests := []struct {
+		name             string
+		postedData       url.Values
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{
+			"block a range",
+			url.Values{"room_id": {"1"}, "start_date": {"2050-01-03"}, "end_date": {"2050-01-09"}, "note": {"Painting"}},
+			http.StatusSeeOther, "/admin/reservations/calendar?y=2050&m=01",
+		},
+		{
+			"every monday",
+			url.Values{"room_id": {"1"}, "start_date": {"2050-01-01"}, "end_date": {"2050-06-30"}, "recurrence": {"weekly"}, "weekdays": {"1"}},
+			http.StatusSeeOther, "/admin/reservations/calendar?y=2050&m=01",
+		},
+		{
+			"weekly without days",
+			url.Values{"room_id": {"1"}, "start_date": {"2050-01-01"}, "end_date": {"2050-06-30"}, "recurrence": {"weekly"}},
+			http.StatusOK, "",
+		},
+		{
+			"weekly for too long",
+			url.Values{"room_id": {"1"}, "start_date": {"2050-01-01"}, "end_date": {"2053-01-01"}, "recurrence": {"weekly"}, "weekdays": {"1"}},
+			http.StatusOK, "",
+		},
+		{
+			"every year",
+			url.Values{"room_id": {"1"}, "start_date": {"2050-08-01"}, "end_date": {"2050-08-07"}, "recurrence": {"yearly"}, "years": {"5"}},
+			http.StatusSeeOther, "/admin/reservations/calendar?y=2050&m=08",
+		},
+		{
+			"too many years",
+			url.Values{"room_id": {"1"}, "start_date": {"2050-08-01"}, "end_date": {"2050-08-07"}, "recurrence": {"yearly"}, "years": {"50"}},
+			http.StatusOK, "",
+		},
+		{
+			"last night before the first",
+			url.Values{"room_id": {"1"}, "start_date": {"2050-01-09"}, "end_date": {"2050-01-03"}},
+			http.StatusOK, "",
+		},
+		{
+			"no room",
+			url.Values{"start_date": {"2050-01-03"}, "end_date": {"2050-01-09"}},
+			http.StatusOK, "",
+		},
+		{
+			"nights already taken",
+			url.Values{"room_id": {"2"}, "start_date": {"2050-01-03"}, "end_date": {"2050-01-09"}},
+			http.StatusOK, "",
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/blocks", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostBlock)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: AdminPostBlock handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: AdminPostBlock handler redirected to %s, wanted %s", e.name, location, e.expectedLocation)
+		}
+	}
+}
+
+func TestRepository_AdminRemoveBlocks(t *testing.T) {
+	tests := []struct {
+		name          string
+		postedData    url.Values
+		expectedKey   string
+		expectedValue string
+	}{
+		{"free a range", url.Values{"room_id": {"0"}, "start_date": {"2050-01-03"}, "end_date": {"2050-01-09"}}, "flash", "Freed the dates of 1 block(s)"},
+		{
+			"imported block", url.Values{"room_id": {"2"}, "start_date": {"2050-01-03"}, "end_date": {"2050-01-09"}}, "flash",
+			"Freed the dates of 0 block(s). 1 block(s) of imported calendars were left, their nights are freed in the calendar they come from",
+		},
+		{"no first night", url.Values{"end_date": {"2050-01-09"}}, "error", "Choose the first night to free"},
+		{"last night before the first", url.Values{"start_date": {"2050-01-09"}, "end_date": {"2050-01-03"}}, "error", "Choose a last night that isn't before the first night"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/blocks/remove", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminRemoveBlocks)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminRemoveBlocks handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if value := session.PopString(ctx, e.expectedKey); value != e.expectedValue {
+			t.Errorf("%s: expected %s %q, got %q", e.name, e.expectedKey, e.expectedValue, value)
+		}
+	}
+}
+
+func TestRepository_AdminReservationsCalendarJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedRooms  int
+	}{
+		{"three months", "start=2050-01-01&end=2050-03-31", http.StatusOK, 2},
+		{"one room", "start=2050-01-01&end=2050-12-31&room_id=1", http.StatusOK, 1},
+		{"unknown room", "start=2050-01-01&end=2050-12-31&room_id=3", http.StatusNotFound, 0},
+		{"missing start", "end=2050-12-31", http.StatusBadRequest, 0},
+		{"end before start", "start=2050-02-01&end=2050-01-01", http.StatusBadRequest, 0},
+		{"longer than a year", "start=2050-01-01&end=2051-01-02", http.StatusBadRequest, 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/calendar-json?"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationsCalendarJSON)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: AdminReservationsCalendarJSON handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var calendar calendarWindow
+		err := json.Unmarshal(rr.Body.Bytes(), &calendar)
+		if err != nil {
+			t.Errorf("%s: failed to parse the response: %v", e.name, err)
+			continue
+		}
+		if len(calendar.Rooms) != e.expectedRooms {
+			t.Errorf("%s: expected %d rooms, got %d", e.name, e.expectedRooms, len(calendar.Rooms))
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/reservations/calendar-json?start=2050-01-02&end=2050-01-31", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminReservationsCalendarJSON).ServeHTTP(rr, req)
+
+	var calendar calendarWindow
+	_ = json.Unmarshal(rr.Body.Bytes(), &calendar)
+	room := calendar.Rooms[0]
+	if room.Reservations["2050-01-02"] != 1 || room.Reservations["2050-01-03"] != 0 {
+		t.Errorf("expected reservation 1 on the night of 2050-01-02 only, got %v", room.Reservations)
+	}
+	if _, ok := room.Reservations["2050-01-01"]; ok {
+		t.Error("nights before the window are returned")
+	}
+	if room.Blocks["2050-01-10"] != 2 || room.BlockNotes["2050-01-10"] != "Painting" {
+		t.Errorf("expected the block on 2050-01-10, got %v %v", room.Blocks, room.BlockNotes)
+	}
+	if free := calendar.FreeUnits[1]["2050-01-31"]; free != 2 {
+		t.Errorf("expected 2 free units of the room type, got %d", free)
+	}
+}
+
+func TestRepository_AdminReservationsTimeline(t *testing.T) {
+	for _, query := range []string{"", "?y=2050&m=01&months=6", "?months=12"} {
+		req, _ := http.NewRequest("GET", "/admin/reservations/timeline"+query, nil)
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationsTimeline)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%q: AdminReservationsTimeline handler returned wrong response code: got %d, wanted %d", query, rr.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestRepository_AdminRoomCalendar(t *testing.T) {
+	tests := []struct {
+		name           string
+		roomID         string
+		expectedStatus int
+	}{
+		{"existing room", "1", http.StatusOK},
+		{"missing room", "3", http.StatusSeeOther},
+		{"invalid id", "generals", http.StatusTemporaryRedirect},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/rooms/"+e.roomID+"/calendar?y=2050", nil)
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.roomID)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminRoomCalendar)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: AdminRoomCalendar handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendar(t *testing.T) {
+	first := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	restrictions, _ := Repo.DB.GetRestrictionsForRoomByDate(1, first, first.AddDate(0, 1, -1))
+	current := models.RestrictionsVersion(restrictions)
+	empty := models.RestrictionsVersion(nil)
+
+	tests := []struct {
+		name          string
+		postedData    url.Values
+		expectedKey   string
+		expectedValue string
+	}{
+		{
+			"block and free nights",
+			url.Values{"version_1": {current}, "add_block": {"1:2050-01-05"}, "remove_block": {"1:2050-01-10"}},
+			"flash", "Changes saved",
+		},
+		{
+			"stale calendar",
+			url.Values{"version_1": {empty}, "add_block": {"1:2050-01-05"}},
+			"error", "General's Quarters changed while you were editing, so its changes weren't saved. Please check the calendar and submit them again",
+		},
+		{
+			"stale calendar of an untouched room",
+			url.Values{"version_1": {current}, "version_2": {"outdated"}, "add_block": {"1:2050-01-05"}},
+			"flash", "Changes saved",
+		},
+		{
+			"night taken",
+			url.Values{"version_2": {empty}, "add_block": {"2:2050-01-05"}},
+			"error", "Major's Suite got booked on a night you wanted to block, so its changes weren't saved",
+		},
+		{
+			"middle night of an imported block",
+			url.Values{"version_2": {empty}, "remove_block": {"2:2050-01-06"}},
+			"error", "Major's Suite has nights blocked by an imported calendar, they are freed in the calendar they come from, so its changes weren't saved",
+		},
+		{
+			"night of another month",
+			url.Values{"version_1": {empty}, "add_block": {"1:2050-02-05"}},
+			"flash", "Changes saved",
+		},
+	}
+
+	for _, e := range tests {
+		e.postedData.Set("y", "2050")
+		e.postedData.Set("m", "01")
+		req, _ := http.NewRequest("POST", "/admin/reservations/calendar", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminPostReservationsCalendar handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if location := rr.Header().Get("Location"); location != "/admin/reservations/calendar?y=2050&m=01" {
+			t.Errorf("%s: AdminPostReservationsCalendar handler redirected to %s", e.name, location)
+		}
+		if value := session.PopString(ctx, e.expectedKey); value != e.expectedValue {
+			t.Errorf("%s: expected %s %q, got %q", e.name, e.expectedKey, e.expectedValue, value)
+		}
+	}
+}
+
+func TestRepository_RoomICalFeed(t *testing.T) {
+	tests := []struct {
+		name           string
+		roomID         string
+		token          string
+		expectedStatus int
+	}{
+		{"current token", "1", "feed-token", http.StatusOK},
+		{"wrong token", "1", "old-token", http.StatusNotFound},
+		{"feed turned off", "2", "", http.StatusNotFound},
+		{"missing room", "3", "feed-token", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/ical/rooms/"+e.roomID+".ics?token="+e.token, nil)
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.roomID)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.RoomICalFeed)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: RoomICalFeed handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/calendar") {
+			t.Errorf("%s: wrong content type %s", e.name, contentType)
+		}
+		body := rr.Body.String()
+		if !strings.HasPrefix(body, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(body, "END:VCALENDAR\r\n") {
+			t.Errorf("%s: not a calendar:\n%s", e.name, body)
+		}
+	}
+}
+
+func TestRepository_AdminRoomICalToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		postedData    url.Values
+		expectedFlash string
+	}{
+		{"new link", url.Values{}, "New calendar feed link created"},
+		{"turn off", url.Values{"action": {"disable"}}, "The calendar feed is turned off"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/ical-token", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", "1")
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminRoomICalToken)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminRoomICalToken handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+// channelCalendar is a calendar of another channel with a booking, a booking on nights taken here
+// and a booking that is over
+const channelCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Channel//Hosting Calendar//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:booking-1@channel\r\n" +
+	"DTSTART;VALUE=DATE:20500105\r\n" +
+	"DTEND;VALUE=DATE:20500108\r\n" +
+	"SUMMARY:Reserved\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:taken-2@channel\r\n" +
+	"DTSTART;VALUE=DATE:20500201\r\n" +
+	"DTEND;VALUE=DATE:20500203\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:booking-0@channel\r\n" +
+	"DTSTART;VALUE=DATE:20000105\r\n" +
+	"DTEND;VALUE=DATE:20000108\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func channelServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/calendar.ics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		_, _ = w.Write([]byte(channelCalendar))
+	})
+	mux.HandleFunc("/cut-off.ics", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(channelCalendar[:200]))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRepository_SyncICalImport(t *testing.T) {
+	server := channelServer()
+	defer server.Close()
+
+	sync, err := Repo.SyncICalImport(models.ICalImport{ID: 2, RoomID: 2, URL: server.URL + "/calendar.ics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sync.Added != 1 || len(sync.Conflicts) != 1 || sync.Conflicts[0] != "taken-2@channel" {
+		t.Errorf("expected one block and one conflict, got %+v", sync)
+	}
+
+	for _, path := range []string{"/missing.ics", "/cut-off.ics"} {
+		_, err = Repo.SyncICalImport(models.ICalImport{ID: 2, RoomID: 2, URL: server.URL + path})
+		if err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+func TestRepository_AdminPostICalImport(t *testing.T) {
+	server := channelServer()
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		fields         map[string]string
+		file           string
+		expectedStatus int
+		expectedFlash  string
+	}{
+		{
+			"by address",
+			map[string]string{"room_id": "2", "name": "Channel", "url": server.URL + "/calendar.ics"},
+			"", http.StatusSeeOther, "Calendar imported: 1 added, 0 updated, 0 removed, 1 on nights already taken",
+		},
+		{
+			"uploaded file",
+			map[string]string{"room_id": "1", "name": "Uploaded"},
+			channelCalendar, http.StatusSeeOther, "Calendar imported: 1 added, 0 updated, 0 removed, 1 on nights already taken",
+		},
+		{
+			"no address or file",
+			map[string]string{"room_id": "1", "name": "Channel"},
+			"", http.StatusOK, "",
+		},
+		{
+			"not a web address",
+			map[string]string{"room_id": "1", "name": "Channel", "url": "file:///etc/passwd"},
+			"", http.StatusOK, "",
+		},
+		{
+			"no room",
+			map[string]string{"name": "Channel", "url": server.URL + "/calendar.ics"},
+			"", http.StatusOK, "",
+		},
+	}
+
+	for _, e := range tests {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for name, value := range e.fields {
+			_ = writer.WriteField(name, value)
+		}
+		if e.file != "" {
+			part, err := writer.CreateFormFile("calendar", "calendar.ics")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = part.Write([]byte(e.file))
+		}
+		_ = writer.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/ical-imports", &body)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostICalImport)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: AdminPostICalImport handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+func TestRepository_AdminSyncICalImport(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		file          string
+		expectedKey   string
+		expectedValue string
+	}{
+		{"uploaded again", "1", channelCalendar, "flash", "Calendar imported: 1 added, 0 updated, 0 removed, 1 on nights already taken"},
+		{"uploaded calendar without a file", "1", "", "error", "Upload the calendar file to import it again"},
+		{"not a calendar", "1", "<html></html>", "error", "The calendar couldn't be imported: not an iCalendar file"},
+		{"missing calendar", "9", "", "error", "Calendar not found"},
+	}
+
+	for _, e := range tests {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		if e.file != "" {
+			part, err := writer.CreateFormFile("calendar", "calendar.ics")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = part.Write([]byte(e.file))
+		}
+		_ = writer.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/ical-imports/"+e.id+"/sync", &body)
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.id)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminSyncICalImport)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminSyncICalImport handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if value := session.PopString(ctx, e.expectedKey); value != e.expectedValue {
+			t.Errorf("%s: expected %s %q, got %q", e.name, e.expectedKey, e.expectedValue, value)
+		}
+	}
+}
+
+func TestICalURL(t *testing.T) {
+	if got := icalURL(" webcal://channel.example/calendar.ics "); got != "https://channel.example/calendar.ics" {
+		t.Errorf("webcal address isn't turned into https: %s", got)
+	}
+}
+
+func TestRepository_AdminICalImports(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/ical-imports", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminICalImports)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminICalImports handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_reservationInvitation(t *testing.T) {
+	reservation := models.Reservation{
+		ID:               7,
+		ConfirmationCode: "ABC123",
+		Rooms: []models.ReservationRoom{
+			{RoomID: 1, StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC), Room: models.Room{RoomName: "General's Quarters"}},
+			{RoomID: 2, StartDate: time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC), Room: models.Room{RoomName: "Major's Suite"}},
+		},
+	}
+
+	attachment := Repo.reservationInvitation(reservation)
+	if attachment.Name != "reservation.ics" || !strings.HasPrefix(attachment.ContentType, "text/calendar") {
+		t.Errorf("unexpected attachment %s of type %s", attachment.Name, attachment.ContentType)
+	}
+
+	events, err := ical.Parse(bytes.NewReader(attachment.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected an event for each room, got %d", len(events))
+	}
+
+	event := events[0]
+	if event.UID != "reservation-ABC123-room-1@study-app" {
+		t.Errorf("unexpected UID %s", event.UID)
+	}
+	if event.Summary != "Stay at Fort Smythe Bed and Breakfast" {
+		t.Errorf("unexpected summary %s", event.Summary)
+	}
+	if event.Location != "General's Quarters, 1 Fort Road, Smythe" {
+		t.Errorf("unexpected location %s", event.Location)
+	}
+	if event.Description != "General's Quarters\nConfirmation code: ABC123" {
+		t.Errorf("unexpected description %q", event.Description)
+	}
+	if !event.AllDay || !event.Start.Equal(reservation.Rooms[0].StartDate) || !event.End.Equal(reservation.Rooms[0].EndDate) {
+		t.Errorf("unexpected dates %s to %s", event.Start, event.End)
+	}
+}
+
+func TestRepository_AdminMailOutbox(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/mail-outbox", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminMailOutbox)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminMailOutbox handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_AdminResendMail(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		expectedKey   string
+		expectedValue string
+	}{
+		{"dead email", "1", "flash", "The email will be sent again"},
+		{"pending email", "2", "error", "The email isn't waiting to be resent"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/mail-outbox/"+e.id+"/resend", nil)
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "id", e.id)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminResendMail)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminResendMail handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if value := session.PopString(ctx, e.expectedKey); value != e.expectedValue {
+			t.Errorf("%s: expected %s %q, got %q", e.name, e.expectedKey, e.expectedValue, value)
+		}
+	}
+}
+
+func TestRepository_renderMail(t *testing.T) {
+	data := Repo.sampleEmailData()
+
+	tests := []struct {
+		name            string
+		email           string
+		expectedSubject string
+		expectedText    string
+	}{
+		{"default wording", "reservation-confirmation", "Reservation Confirmation", "This is to confirm your reservation"},
+		{"reworded", "reservation-changed", "Your stay has changed", "your stay now starts on"},
+		{"reworded but broken", "reservation-cancelled-owner", "Reservation cancelled", "has been cancelled by the guest"},
+	}
+
+	for _, e := range tests {
+		mail, ok := Repo.renderMail("john@smith.com", e.email, data)
+		if !ok {
+			t.Errorf("%s: the email wasn't rendered", e.name)
+			continue
+		}
+		if mail.To != "john@smith.com" || mail.From != "me@local.local" || mail.Subject != e.expectedSubject {
+			t.Errorf("%s: unexpected email to %s from %s: %s", e.name, mail.To, mail.From, mail.Subject)
+		}
+		if !strings.Contains(mail.Text, e.expectedText) {
+			t.Errorf("%s: the email doesn't contain %q:\n%s", e.name, e.expectedText, mail.Text)
+		}
+	}
+
+	_, ok := Repo.renderMail("john@smith.com", "not-existent", data)
+	if ok {
+		t.Error("rendered an email that doesn't exist")
+	}
+}
+
+func TestRepository_AdminEmailTemplates(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/email-templates", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminEmailTemplates)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminEmailTemplates handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_AdminEmailTemplate(t *testing.T) {
+	tests := []struct {
+		name           string
+		email          string
+		expectedStatus int
+	}{
+		{"default wording", "reservation-confirmation", http.StatusOK},
+		{"reworded", "reservation-changed", http.StatusOK},
+		{"unknown email", "not-existent", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/email-templates/"+e.email, nil)
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "name", e.email)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminEmailTemplate)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: AdminEmailTemplate handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestRepository_AdminPostEmailTemplate(t *testing.T) {
+	valid := url.Values{
+		"subject": {"See you soon, {{.Reservation.FirstName}}"},
+		"html":    {"<p>Your code is {{.Reservation.ConfirmationCode}}</p>"},
+		"text":    {"Your code is {{.Reservation.ConfirmationCode}}"},
+	}
+	with := func(key, value string) url.Values {
+		posted := url.Values{}
+		for k, v := range valid {
+			posted[k] = v
+		}
+		posted.Set(key, value)
+		return posted
+	}
+
+	tests := []struct {
+		name           string
+		email          string
+		postedData     url.Values
+		expectedStatus int
+	}{
+		{"valid", "reservation-confirmation", valid, http.StatusSeeOther},
+		{"missing subject", "reservation-confirmation", with("subject", ""), http.StatusOK},
+		{"html doesn't parse", "reservation-confirmation", with("html", "{{if .Reservation.ID}}"), http.StatusOK},
+		{"text doesn't parse", "reservation-confirmation", with("text", "{{.Reservation.FirstName"), http.StatusOK},
+		{"unknown field", "reservation-confirmation", with("html", "{{.Reservation.Missing}}"), http.StatusOK},
+		{"unknown email", "not-existent", valid, http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/email-templates/"+e.email, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "name", e.email)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostEmailTemplate)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: AdminPostEmailTemplate handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if e.expectedStatus == http.StatusSeeOther {
+			if value := session.PopString(ctx, "flash"); value != "Email template saved" {
+				t.Errorf("%s: expected flash %q, got %q", e.name, "Email template saved", value)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminPreviewEmailTemplate(t *testing.T) {
+	tests := []struct {
+		name            string
+		postedData      url.Values
+		expectedOK      bool
+		expectedPart    string
+		expectedSubject string
+	}{
+		{
+			"valid",
+			url.Values{"subject": {"See you soon, {{.Reservation.FirstName}}"}, "html": {"<p>Hello</p>"}, "text": {"Hello"}},
+			true, "", "See you soon, John",
+		},
+		{
+			"subject doesn't parse",
+			url.Values{"subject": {"{{.Reservation.FirstName"}, "html": {"<p>Hello</p>"}, "text": {"Hello"}},
+			false, "subject", "",
+		},
+		{
+			"unknown function",
+			url.Values{"subject": {"Hello"}, "html": {"<p>Hello</p>"}, "text": {"{{price .Reservation}}"}},
+			false, "text", "",
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/email-templates/reservation-confirmation/preview", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParam(ctx, "name", "reservation-confirmation")
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPreviewEmailTemplate)
+		handler.ServeHTTP(rr, req)
+
+		var preview emailPreviewResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &preview)
+		if err != nil {
+			t.Errorf("%s: failed to parse json: %v", e.name, err)
+			continue
+		}
+		if preview.OK != e.expectedOK || preview.Part != e.expectedPart || preview.Subject != e.expectedSubject {
+			t.Errorf("%s: unexpected preview %+v", e.name, preview)
+		}
+		if e.expectedOK && (!strings.Contains(preview.HTML, "<p>Hello</p>") || !strings.HasPrefix(preview.Text, "Hello\n")) {
+			t.Errorf("%s: unexpected bodies %s\n%s", e.name, preview.Text, preview.HTML)
+		}
+	}
+}
+
+func TestRepository_AdminResetEmailTemplate(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/email-templates/reservation-changed/reset", nil)
+	ctx := getCtx(req)
+	ctx = addURLParam(ctx, "name", "reservation-changed")
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminResetEmailTemplate)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminResetEmailTemplate handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if value := session.PopString(ctx, "flash"); value != "The email is back to its default wording" {
+		t.Errorf("expected flash %q, got %q", "The email is back to its default wording", value)
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"github.com/justinas/nosurf"
 	"github.com/zahnah/study-app/internal/config"
 	"github.com/zahnah/study-app/internal/helpers"
+	"github.com/zahnah/study-app/internal/mailer"
 	"github.com/zahnah/study-app/internal/models"
 	"github.com/zahnah/study-app/internal/render"
+	"github.com/zahnah/study-app/internal/storage"
 	"html/template"
 	"log"
 	"net/http"
@@ -22,8 +24,17 @@ import (
 
 var app config.AppConfig
 var session *scs.SessionManager
+
+// mailbox keeps the emails the handlers send
+var mailbox = mailer.NewMemory()
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":  render.HumanDate,
+	"formatDate": render.FormatDate,
+	"iterate":    render.Iterate,
+	"add":        render.Add,
+	"money":      render.Money,
+}
 
 func NoServe(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
@@ -46,6 +57,17 @@ func TestMain(m *testing.M) {
 	gob.Register(models.Reservation{})
 
 	app.InProduction = false
+	app.HoldDuration = 15 * time.Minute
+
+	uploadDir, err := os.MkdirTemp("", "uploads")
+	if err != nil {
+		log.Fatalln("Can't create the upload directory")
+	}
+	app.Storage = storage.NewLocalDisk(uploadDir, "/static/uploads")
+	app.MailFrom = "me@local.local"
+	app.Mailer = mailbox
+	app.PropertyName = "Fort Smythe Bed and Breakfast"
+	app.PropertyAddress = "1 Fort Road, Smythe"
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -66,11 +88,14 @@ func TestMain(m *testing.M) {
 		log.Fatalln("Can't create a template cache")
 	}
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
+	app.MailQueued = make(chan struct{}, 1)
 
-	listenForMail()
+	app.EmailTemplateDir = "./../../email-templates"
+	app.EmailTemplateCache, app.EmailTextTemplateCache, err = render.CreateEmailTemplateCache(app.EmailTemplateDir)
+	if err != nil {
+		log.Fatalln("Can't create the email template cache:", err)
+	}
+	app.BaseURL = "http://localhost:8080"
 
 	app.TemplateCache = tc
 	app.UseCache = true
@@ -79,7 +104,9 @@ func TestMain(m *testing.M) {
 	NewHandlers(repo)
 
 	render.NewRenderer(&app)
-	os.Exit(m.Run())
+	code := m.Run()
+	_ = os.RemoveAll(uploadDir)
+	os.Exit(code)
 }
 
 func getRoutes() http.Handler {
@@ -117,14 +144,6 @@ func getRoutes() http.Handler {
 	return routes(&app)
 }
 
-func listenForMail() {
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
-}
-
 func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
@@ -137,6 +156,8 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/generals", Repo.Generals)
 	mux.Get("/contact", Repo.Contact)
 	mux.Get("/majors", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{id}", Repo.Room)
 
 	mux.Get("/search-availability", Repo.SearchAvailability)
 	mux.Post("/search-availability", Repo.PostAvailability)
@@ -146,6 +167,9 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Post("/make-reservation", Repo.PostMakeReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/my-reservation", Repo.MyReservation)
+	mux.Get("/my-reservation/manage", Repo.MyReservationManage)
+
 	fileServer := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -1,12 +1,17 @@
 package helpers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/zahnah/study-app/internal/config"
 	"net/http"
 	"runtime/debug"
 )
 
+// confirmationCodeAlphabet leaves out characters that are easily confused when read aloud
+const confirmationCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
 var app *config.AppConfig
 
 func NewHelpers(a *config.AppConfig) {
@@ -28,3 +33,27 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// NewConfirmationCode returns a random code guests use to find their reservation
+func NewConfirmationCode() (string, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range b {
+		b[i] = confirmationCodeAlphabet[int(b[i])%len(confirmationCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// NewToken returns a random token that can't be guessed, for links that work without logging in
+func NewToken() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,42 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewConfirmationCode(t *testing.T) {
+	code, err := NewConfirmationCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(code) != 12 {
+		t.Errorf("expected a code of 12 characters, got %s", code)
+	}
+	for _, c := range code {
+		if !strings.ContainsRune(confirmationCodeAlphabet, c) {
+			t.Errorf("unexpected character %c in %s", c, code)
+		}
+	}
+
+	other, _ := NewConfirmationCode()
+	if code == other {
+		t.Error("two codes in a row shouldn't be the same")
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	token, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != 40 {
+		t.Errorf("expected a token of 40 characters, got %s", token)
+	}
+
+	other, _ := NewToken()
+	if token == other {
+		t.Error("two tokens in a row shouldn't be the same")
+	}
+}
@@ -0,0 +1,149 @@
+// Package ical reads and writes iCalendar (RFC 5545) calendars, as served by the room feeds and imported from other channels
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineOctets is the longest a content line may be before it has to be folded
+const maxLineOctets = 75
+
+// Calendar is a VCALENDAR with its events
+type Calendar struct {
+	ProdID string
+	Name   string
+	Method string
+	Events []Event
+}
+
+// Event is a VEVENT. All day events run from the Start date to the End date, exclusive, other events are
+// written in UTC. Stamp is when the event was last written and defaults to LastModified.
+type Event struct {
+	UID          string
+	Summary      string
+	Description  string
+	Location     string
+	Start        time.Time
+	End          time.Time
+	AllDay       bool
+	Stamp        time.Time
+	LastModified time.Time
+	Sequence     int
+}
+
+// Encode writes the calendar to w
+func (c Calendar) Encode(w io.Writer) error {
+	lw := &lineWriter{w: w}
+
+	lw.line("BEGIN", "VCALENDAR")
+	lw.line("VERSION", "2.0")
+	lw.line("PRODID", c.ProdID)
+	lw.line("CALSCALE", "GREGORIAN")
+	if c.Method != "" {
+		lw.line("METHOD", c.Method)
+	}
+	if c.Name != "" {
+		lw.line("X-WR-CALNAME", escapeText(c.Name))
+	}
+
+	for _, e := range c.Events {
+		stamp := e.Stamp
+		if stamp.IsZero() {
+			stamp = e.LastModified
+		}
+
+		lw.line("BEGIN", "VEVENT")
+		lw.line("UID", e.UID)
+		lw.line("DTSTAMP", formatDateTime(stamp))
+		if e.AllDay {
+			lw.line("DTSTART;VALUE=DATE", formatDate(e.Start))
+			lw.line("DTEND;VALUE=DATE", formatDate(e.End))
+		} else {
+			lw.line("DTSTART", formatDateTime(e.Start))
+			lw.line("DTEND", formatDateTime(e.End))
+		}
+		if !e.LastModified.IsZero() {
+			lw.line("LAST-MODIFIED", formatDateTime(e.LastModified))
+		}
+		if e.Sequence > 0 {
+			lw.line("SEQUENCE", fmt.Sprint(e.Sequence))
+		}
+		lw.line("SUMMARY", escapeText(e.Summary))
+		if e.Description != "" {
+			lw.line("DESCRIPTION", escapeText(e.Description))
+		}
+		if e.Location != "" {
+			lw.line("LOCATION", escapeText(e.Location))
+		}
+		lw.line("TRANSP", "OPAQUE")
+		lw.line("END", "VEVENT")
+	}
+
+	lw.line("END", "VCALENDAR")
+	return lw.err
+}
+
+// Bytes returns the encoded calendar
+func (c Calendar) Bytes() []byte {
+	var buf bytes.Buffer
+	_ = c.Encode(&buf)
+	return buf.Bytes()
+}
+
+// lineWriter writes CRLF terminated content lines, folding the long ones, and keeps the first error
+type lineWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (lw *lineWriter) line(name, value string) {
+	if lw.err != nil {
+		return
+	}
+	_, lw.err = io.WriteString(lw.w, fold(name+":"+value))
+}
+
+// fold splits a content line into lines of at most 75 octets, the following ones starting with a space,
+// without breaking a UTF-8 character in two
+func fold(line string) string {
+	var b strings.Builder
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space counts towards the length of the continuation line
+		limit = maxLineOctets - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// escapeText escapes a TEXT value
+func escapeText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("20060102")
+}
+
+func formatDateTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
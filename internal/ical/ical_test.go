@@ -0,0 +1,74 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar_Encode(t *testing.T) {
+	modified := time.Date(2049, 12, 1, 10, 30, 0, 0, time.UTC)
+	calendar := Calendar{
+		ProdID: "-//Bookings//Rooms//EN",
+		Name:   "General's Quarters",
+		Events: []Event{
+			{
+				UID:          "restriction-1@bookings",
+				Summary:      "Blocked; painting, walls",
+				Start:        time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:          time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+				AllDay:       true,
+				LastModified: modified,
+			},
+		},
+	}
+
+	out := string(calendar.Bytes())
+
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"VERSION:2.0\r\n",
+		"PRODID:-//Bookings//Rooms//EN\r\n",
+		"UID:restriction-1@bookings\r\n",
+		"DTSTAMP:20491201T103000Z\r\n",
+		"DTSTART;VALUE=DATE:20500101\r\n",
+		"DTEND;VALUE=DATE:20500103\r\n",
+		"SUMMARY:Blocked\\; painting\\, walls\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in\n%s", line, out)
+		}
+	}
+	if strings.Contains(strings.ReplaceAll(out, "\r\n", ""), "\n") {
+		t.Error("lines have to end with CRLF")
+	}
+}
+
+func TestFold(t *testing.T) {
+	line := "DESCRIPTION:" + strings.Repeat("é", 100)
+	folded := fold(line)
+
+	for _, l := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(l) > maxLineOctets {
+			t.Errorf("line of %d octets: %q", len(l), l)
+		}
+		if !strings.HasPrefix(l, "DESCRIPTION") && !strings.HasPrefix(l, " ") {
+			t.Errorf("continuation line doesn't start with a space: %q", l)
+		}
+	}
+
+	if unfolded := strings.ReplaceAll(strings.TrimSuffix(folded, "\r\n"), "\r\n ", ""); unfolded != line {
+		t.Errorf("unfolding doesn't give the line back: %q", unfolded)
+	}
+
+	if fold("UID:1") != "UID:1\r\n" {
+		t.Error("short lines are changed")
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	if got := escapeText("a\\b;c,d\ne"); got != `a\\b\;c\,d\ne` {
+		t.Errorf("got %q", got)
+	}
+}
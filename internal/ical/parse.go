@@ -0,0 +1,286 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotCalendar is returned when the input has no VCALENDAR
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// maxParseLine is the longest unfolded content line Parse accepts
+const maxParseLine = 64 * 1024
+
+// Parse reads the events of an iCalendar stream. Cancelled events are left out. Recurrence rules aren't
+// expanded, a recurring event is read as its first occurrence.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var event *Event
+	var cancelled, inCalendar, ended bool
+	var startSet, endSet bool
+	var duration time.Duration
+	var durationDays int
+
+	for n, line := range lines {
+		name, params, value, ok := splitLine(line)
+		if !ok && !inCalendar {
+			// a login page or an error page served in place of the calendar
+			return nil, ErrNotCalendar
+		}
+		if !ok {
+			return nil, fmt.Errorf("line %d: malformed content line", n+1)
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCALENDAR"):
+			inCalendar = true
+		case name == "END" && strings.EqualFold(value, "VCALENDAR"):
+			ended = true
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			event = &Event{}
+			cancelled, startSet, endSet = false, false, false
+			duration, durationDays = 0, 0
+		case name == "END" && strings.EqualFold(value, "VEVENT") && event != nil:
+			if !startSet || event.UID == "" {
+				return nil, fmt.Errorf("line %d: event without UID or DTSTART", n+1)
+			}
+			if !endSet {
+				switch {
+				case duration > 0 || durationDays > 0:
+					event.End = event.Start.AddDate(0, 0, durationDays).Add(duration)
+				case event.AllDay:
+					// an all day event without an end lasts the day it starts on
+					event.End = event.Start.AddDate(0, 0, 1)
+				default:
+					event.End = event.Start
+				}
+			}
+			if !cancelled {
+				events = append(events, *event)
+			}
+			event = nil
+		case event == nil:
+			// properties of the calendar or of other components
+		case name == "UID":
+			event.UID = value
+		case name == "SUMMARY":
+			event.Summary = unescapeText(value)
+		case name == "DESCRIPTION":
+			event.Description = unescapeText(value)
+		case name == "LOCATION":
+			event.Location = unescapeText(value)
+		case name == "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		case name == "SEQUENCE":
+			event.Sequence, _ = strconv.Atoi(value)
+		case name == "DTSTART":
+			event.Start, event.AllDay, err = parseTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			startSet = true
+		case name == "DTEND":
+			event.End, _, err = parseTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			endSet = true
+		case name == "DURATION":
+			durationDays, duration, err = parseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+		case name == "DTSTAMP":
+			event.Stamp, _, _ = parseTime(value, params)
+		case name == "LAST-MODIFIED":
+			event.LastModified, _, _ = parseTime(value, params)
+		}
+	}
+
+	if !inCalendar {
+		return nil, ErrNotCalendar
+	}
+	if !ended {
+		// a cut off download would otherwise look like a calendar without the events that are missing
+		return nil, errors.New("the calendar ends early")
+	}
+
+	return events, nil
+}
+
+// unfold reads the content lines, joining the folded ones. Lines may end with CRLF or, leniently, with LF.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxParseLine)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// splitLine splits a content line into its upper-cased name, its parameters and its value
+func splitLine(line string) (string, map[string]string, string, bool) {
+	// the value starts at the first colon outside a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 1 {
+		return "", nil, "", false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		key, value, _ := strings.Cut(p, "=")
+		params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+
+	return strings.ToUpper(parts[0]), params, line[colon+1:], true
+}
+
+// parseTime reads a DATE or DATE-TIME value, and reports whether it was a date
+func parseTime(value string, params map[string]string) (time.Time, bool, error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.Parse("20060102", value)
+		if err != nil {
+			return t, true, fmt.Errorf("invalid date %q", value)
+		}
+		return t, true, nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return t, false, fmt.Errorf("invalid date-time %q", value)
+		}
+		return t, false, nil
+	}
+
+	// local times are in their TZID, floating times and unknown zones are read as UTC
+	location := time.UTC
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			location = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, location)
+	if err != nil {
+		return t, false, fmt.Errorf("invalid date-time %q", value)
+	}
+	return t, false, nil
+}
+
+// parseDuration reads a DURATION value such as P1D, PT12H or P1W, returning its days and the rest
+func parseDuration(value string) (int, time.Duration, error) {
+	invalid := fmt.Errorf("invalid duration %q", value)
+
+	v := strings.TrimPrefix(value, "+")
+	if strings.HasPrefix(v, "-") || !strings.HasPrefix(v, "P") {
+		return 0, 0, invalid
+	}
+	v = v[1:]
+
+	days := 0
+	var rest time.Duration
+	inTime := false
+	number := ""
+	for _, c := range v {
+		switch {
+		case c >= '0' && c <= '9':
+			number += string(c)
+			continue
+		case c == 'T':
+			inTime = true
+			continue
+		}
+
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, 0, invalid
+		}
+		number = ""
+
+		switch {
+		case c == 'W' && !inTime:
+			days += 7 * n
+		case c == 'D' && !inTime:
+			days += n
+		case c == 'H' && inTime:
+			rest += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			rest += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			rest += time.Duration(n) * time.Second
+		default:
+			return 0, 0, invalid
+		}
+	}
+	if number != "" {
+		return 0, 0, invalid
+	}
+
+	return days, rest, nil
+}
+
+// unescapeText reverses escapeText
+func unescapeText(s string) string {
+	var b strings.Builder
+	escaped := false
+	for _, c := range s {
+		if escaped {
+			switch c {
+			case 'n', 'N':
+				b.WriteRune('\n')
+			default:
+				b.WriteRune(c)
+			}
+			escaped = false
+			continue
+		}
+		if c == '\\' {
+			escaped = true
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Nights returns the first night and the day after the last night the event takes up, at midnight UTC.
+// An event ending during a day takes up the night before that day, an event shorter than a night takes
+// up the night it starts on.
+func (e Event) Nights() (time.Time, time.Time) {
+	start := time.Date(e.Start.Year(), e.Start.Month(), e.Start.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(e.End.Year(), e.End.Month(), e.End.Day(), 0, 0, 0, 0, time.UTC)
+	if !end.After(start) {
+		end = start.AddDate(0, 0, 1)
+	}
+	return start, end
+}
@@ -0,0 +1,121 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const channelFeed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Channel//Hosting Calendar//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20500105\r\n" +
+	"DTEND;VALUE=DATE:20500108\r\n" +
+	"UID:booking-1@channel\r\n" +
+	"SUMMARY:Reserved\\, guest\r\n" +
+	"DESCRIPTION:A long description that is folded over more than one content line by\r\n" +
+	"  the channel\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20500110T150000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20500112T110000\r\n" +
+	"UID:booking-2@channel\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20500115\r\n" +
+	"DURATION:P2D\r\n" +
+	"UID:booking-3@channel\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20500120\r\n" +
+	"UID:booking-4@channel\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(channelFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+
+	tests := []struct {
+		uid   string
+		start string
+		end   string
+	}{
+		{"booking-1@channel", "2050-01-05", "2050-01-08"},
+		{"booking-2@channel", "2050-01-10", "2050-01-12"},
+		{"booking-3@channel", "2050-01-15", "2050-01-17"},
+	}
+	for i, e := range tests {
+		start, end := events[i].Nights()
+		if events[i].UID != e.uid || start.Format("2006-01-02") != e.start || end.Format("2006-01-02") != e.end {
+			t.Errorf("expected %s from %s to %s, got %s from %s to %s", e.uid, e.start, e.end,
+				events[i].UID, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		}
+	}
+
+	if events[0].Summary != "Reserved, guest" {
+		t.Errorf("summary isn't unescaped: %q", events[0].Summary)
+	}
+	if !strings.HasSuffix(events[0].Description, "by the channel") {
+		t.Errorf("description isn't unfolded: %q", events[0].Description)
+	}
+	if !events[0].AllDay || events[1].AllDay {
+		t.Error("all day events aren't told apart")
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	calendar := Calendar{
+		ProdID: "-//Bookings//Rooms//EN",
+		Events: []Event{
+			{
+				UID:     "restriction-1@bookings",
+				Summary: "Blocked; painting, walls\nsecond line",
+				Start:   time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:     time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+				AllDay:  true,
+			},
+		},
+	}
+
+	events, err := Parse(strings.NewReader(string(calendar.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Summary != calendar.Events[0].Summary ||
+		!events[0].Start.Equal(calendar.Events[0].Start) || !events[0].End.Equal(calendar.Events[0].End) {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for name, input := range map[string]string{
+		"not a calendar": "<html></html>",
+		"cut off":        "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART:20500101\r\nEND:VEVENT\r\n",
+		"no uid":         "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20500101\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"bad date":       "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART:2050-01-01\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"bad duration":   "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART:20500101\r\nDURATION:2D\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEvent_Nights(t *testing.T) {
+	e := Event{
+		Start: time.Date(2050, 1, 1, 15, 0, 0, 0, time.UTC),
+		End:   time.Date(2050, 1, 1, 18, 0, 0, 0, time.UTC),
+	}
+	start, end := e.Nights()
+	if !end.Equal(start.AddDate(0, 0, 1)) {
+		t.Errorf("an event within a day has to take up its night, got %s to %s", start, end)
+	}
+}
@@ -0,0 +1,150 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+// MaxUploadSize is the largest image accepted from an upload, in bytes
+const MaxUploadSize = 10 << 20
+
+// maxPixels guards against small files that decode into huge images
+const maxPixels = 40_000_000
+
+// jpegQuality is used for every generated variant
+const jpegQuality = 82
+
+var (
+	// ErrTooLarge is returned for uploads over MaxUploadSize or images with too many pixels
+	ErrTooLarge = errors.New("the image is too large")
+
+	// ErrUnsupportedType is returned for files that aren't JPEG, PNG or GIF images
+	ErrUnsupportedType = errors.New("only JPEG, PNG and GIF images can be uploaded")
+)
+
+// allowedTypes are the content types accepted for uploads
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Variant is a resized copy of an uploaded image, fitting in MaxWidth x MaxHeight
+type Variant struct {
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+}
+
+// Variants generated for every room photo
+var (
+	Thumbnail = Variant{Name: "thumb", MaxWidth: 400, MaxHeight: 300}
+	Web       = Variant{Name: "web", MaxWidth: 1600, MaxHeight: 1200}
+)
+
+// Decode checks that data is a supported image of an acceptable size and decodes it
+func Decode(data []byte) (image.Image, error) {
+	if len(data) > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+	if !allowedTypes[http.DetectContentType(data)] {
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	return img, nil
+}
+
+// Generate resizes img to fit the variant and encodes it as a JPEG
+func Generate(img image.Image, variant Variant) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, Resize(img, variant.MaxWidth, variant.MaxHeight), &jpeg.Options{Quality: jpegQuality})
+	return buf.Bytes(), err
+}
+
+// Resize scales img down, keeping its aspect ratio, so that it fits in maxWidth x maxHeight.
+// Every pixel of the result is the average of the source pixels it covers. Images that
+// already fit are only flattened onto a white background, since JPEG has no transparency.
+func Resize(img image.Image, maxWidth, maxHeight int) *image.RGBA {
+	src := flatten(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	if width <= maxWidth && height <= maxHeight {
+		return src
+	}
+
+	scale := float64(maxWidth) / float64(width)
+	if s := float64(maxHeight) / float64(height); s < scale {
+		scale = s
+	}
+	dstWidth := int(float64(width)*scale + 0.5)
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	dstHeight := int(float64(height)*scale + 0.5)
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0, y1 := y*height/dstHeight, (y+1)*height/dstHeight
+		if y1 == y0 {
+			y1++
+		}
+
+		for x := 0; x < dstWidth; x++ {
+			x0, x1 := x*width/dstWidth, (x+1)*width/dstWidth
+			if x1 == x0 {
+				x1++
+			}
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				offset := sy*src.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[offset])
+					g += int(src.Pix[offset+1])
+					b += int(src.Pix[offset+2])
+					a += int(src.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// flatten copies img onto an opaque white canvas whose bounds start at 0, 0
+func flatten(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Rect, img, bounds.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,101 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	if _, err := Decode(encodePNG(t, 10, 10)); err != nil {
+		t.Errorf("a png has to be accepted, got %v", err)
+	}
+
+	if _, err := Decode([]byte("<html>not an image</html>")); err != ErrUnsupportedType {
+		t.Errorf("expected ErrUnsupportedType, got %v", err)
+	}
+
+	if _, err := Decode(make([]byte, MaxUploadSize+1)); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	var tests = []struct {
+		name           string
+		width          int
+		height         int
+		maxWidth       int
+		maxHeight      int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{"landscape", 800, 400, 400, 300, 400, 200},
+		{"portrait", 300, 900, 400, 300, 100, 300},
+		{"already small", 200, 100, 400, 300, 200, 100},
+		{"very thin", 4000, 1, 400, 300, 400, 1},
+	}
+
+	for _, e := range tests {
+		img := image.NewRGBA(image.Rect(0, 0, e.width, e.height))
+		resized := Resize(img, e.maxWidth, e.maxHeight)
+		if resized.Rect.Dx() != e.expectedWidth || resized.Rect.Dy() != e.expectedHeight {
+			t.Errorf("%s: expected %dx%d, got %dx%d", e.name, e.expectedWidth, e.expectedHeight,
+				resized.Rect.Dx(), resized.Rect.Dy())
+		}
+	}
+}
+
+func TestResize_Colors(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	img.Set(1, 0, color.RGBA{B: 255, A: 255})
+
+	resized := Resize(img, 1, 1)
+	if c := resized.RGBAAt(0, 0); c.R != 127 || c.B != 127 || c.A != 255 {
+		t.Errorf("expected the average of both pixels, got %v", c)
+	}
+
+	transparent := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	if c := Resize(transparent, 1, 1).RGBAAt(0, 0); c != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("transparent pixels have to become white, got %v", c)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	img, err := Decode(encodePNG(t, 1000, 500))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Generate(img, Thumbnail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 400 || config.Height != 200 {
+		t.Errorf("expected a 400x200 thumbnail, got %dx%d", config.Width, config.Height)
+	}
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/zahnah/study-app/internal/models"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// File writes every email as an .eml file to a directory, for developers to open in their mail client
+type File struct {
+	Dir     string
+	written atomic.Int64
+}
+
+// NewFile returns a mailer writing to dir, which is created when it doesn't exist
+func NewFile(dir string) (*File, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{Dir: dir}, nil
+}
+
+// Send writes the email to a file named after the time it was sent
+func (f *File) Send(m models.MailData) error {
+	email, err := message(m)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102-150405"), f.written.Add(1))
+	return os.WriteFile(filepath.Join(f.Dir, name), []byte(email.GetMessage()), 0644)
+}
+
+func (f *File) Close() error {
+	return nil
+}
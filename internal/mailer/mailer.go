@@ -0,0 +1,76 @@
+// Package mailer delivers the emails of the app
+package mailer
+
+import (
+	"fmt"
+	mail "github.com/xhit/go-simple-mail/v2"
+	"github.com/zahnah/study-app/internal/models"
+)
+
+// Mail drivers
+const (
+	DriverSMTP   = "smtp"
+	DriverFile   = "file"
+	DriverMemory = "memory"
+)
+
+// Mailer delivers emails
+type Mailer interface {
+	// Send returns once the email is handed over, or with the reason it couldn't be
+	Send(m models.MailData) error
+
+	// Close releases what the mailer keeps open between emails
+	Close() error
+}
+
+// Config selects the mailer. SMTP is used by the smtp driver and Dir by the file driver.
+type Config struct {
+	Driver string
+	SMTP   SMTPConfig
+	Dir    string
+}
+
+// New returns the mailer of the configured driver, SMTP when none is set
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case DriverSMTP, "":
+		smtp, err := NewSMTP(cfg.SMTP)
+		if err != nil {
+			return nil, err
+		}
+		return smtp, nil
+	case DriverFile:
+		file, err := NewFile(cfg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	case DriverMemory:
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// message builds the email of m, as multipart/alternative when it has a plain text version
+func message(m models.MailData) (*mail.Email, error) {
+	email := mail.NewMSG()
+	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+
+	if m.Text == "" {
+		email.SetBody(mail.TextHTML, m.Content)
+	} else {
+		email.SetBody(mail.TextPlain, m.Text)
+		email.AddAlternative(mail.TextHTML, m.Content)
+	}
+
+	for _, attachment := range m.Attachments {
+		email.Attach(&mail.File{
+			Name:     attachment.Name,
+			MimeType: attachment.ContentType,
+			Data:     attachment.Data,
+		})
+	}
+
+	return email, email.GetError()
+}
@@ -0,0 +1,89 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		driver   string
+		expected string
+		valid    bool
+	}{
+		{"", "*mailer.SMTP", true},
+		{DriverSMTP, "*mailer.SMTP", true},
+		{DriverFile, "*mailer.File", true},
+		{DriverMemory, "*mailer.Memory", true},
+		{"mailhog", "", false},
+	}
+
+	for _, e := range tests {
+		m, err := New(Config{Driver: e.driver, Dir: t.TempDir()})
+		if !e.valid {
+			if err == nil {
+				t.Errorf("%q: expected an unknown driver to be refused", e.driver)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", e.driver, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", m); got != e.expected {
+			t.Errorf("%q: expected a %s, got %s", e.driver, e.expected, got)
+		}
+	}
+}
+
+func TestFile_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	file, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, to := range []string{"john@smith.com", "jane@smith.com"} {
+		err = file.Send(testMail(to))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected a file for each email, got %d", len(files))
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Subject: Reservation Confirmation", "See you soon", "<html><body>", "reservation.ics"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("the file doesn't contain %q:\n%s", expected, data)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	memory := NewMemory()
+	_ = memory.Send(testMail("john@smith.com"))
+	_ = memory.Send(testMail("jane@smith.com"))
+
+	messages := memory.Messages()
+	if len(messages) != 2 || messages[0].To != "john@smith.com" || messages[1].To != "jane@smith.com" {
+		t.Errorf("expected both emails in order, got %+v", messages)
+	}
+
+	memory.Reset()
+	if len(memory.Messages()) != 0 {
+		t.Error("expected no emails after a reset")
+	}
+}
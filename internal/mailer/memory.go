@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"github.com/zahnah/study-app/internal/models"
+	"sync"
+)
+
+// Memory keeps the emails it is sent, for tests to check
+type Memory struct {
+	mu       sync.Mutex
+	messages []models.MailData
+}
+
+// NewMemory returns an empty mailbox
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Send(msg models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// Messages returns the emails sent so far, in the order they were sent
+func (m *Memory) Messages() []models.MailData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.MailData(nil), m.messages...)
+}
+
+// Reset forgets the emails sent so far
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
@@ -0,0 +1,130 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	mail "github.com/xhit/go-simple-mail/v2"
+	"github.com/zahnah/study-app/internal/models"
+	"time"
+)
+
+// SMTP encryptions
+const (
+	EncryptionNone     = "none"
+	EncryptionSTARTTLS = "starttls"
+	EncryptionTLS      = "tls"
+)
+
+// SMTPConfig is how to reach the mail server. Encryption is one of the SMTP encryptions, TLSConfig
+// defaults to verifying the certificate of Host.
+type SMTPConfig struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	Encryption string
+	TLSConfig  *tls.Config
+}
+
+const (
+	// smtpTimeout bounds connecting to the server and sending a message
+	smtpTimeout = 10 * time.Second
+	// smtpIdleConnections is how many connections are kept open between messages
+	smtpIdleConnections = 4
+)
+
+// SMTP sends the emails through an SMTP server. Connections are kept open after a message and reused
+// by the next ones, so it is safe to share between workers.
+type SMTP struct {
+	server *mail.SMTPServer
+	idle   chan *mail.SMTPClient
+}
+
+// NewSMTP returns a mailer for the server of cfg
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	server := mail.NewSMTPClient()
+	server.Host = cfg.Host
+	server.Port = cfg.Port
+	server.Username = cfg.Username
+	server.Password = cfg.Password
+	server.TLSConfig = cfg.TLSConfig
+	server.KeepAlive = true
+	server.ConnectTimeout = smtpTimeout
+	server.SendTimeout = smtpTimeout
+
+	switch cfg.Encryption {
+	case EncryptionNone, "":
+		server.Encryption = mail.EncryptionNone
+	case EncryptionSTARTTLS:
+		server.Encryption = mail.EncryptionSTARTTLS
+	case EncryptionTLS:
+		server.Encryption = mail.EncryptionSSLTLS
+	default:
+		return nil, fmt.Errorf("unknown SMTP encryption %q", cfg.Encryption)
+	}
+
+	return &SMTP{
+		server: server,
+		idle:   make(chan *mail.SMTPClient, smtpIdleConnections),
+	}, nil
+}
+
+// Send delivers the email. The connection is dropped when it fails, the next message gets a new one.
+func (s *SMTP) Send(m models.MailData) error {
+	email, err := message(m)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	err = email.Send(client)
+	if err != nil {
+		_ = client.Close()
+		return err
+	}
+
+	s.release(client)
+	return nil
+}
+
+// Close ends the connections that are open
+func (s *SMTP) Close() error {
+	for {
+		select {
+		case client := <-s.idle:
+			_ = client.Quit()
+			_ = client.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// client returns an open connection that still answers, or a new one
+func (s *SMTP) client() (*mail.SMTPClient, error) {
+	for {
+		select {
+		case client := <-s.idle:
+			if client.Noop() == nil {
+				return client, nil
+			}
+			_ = client.Close()
+		default:
+			return s.server.Connect()
+		}
+	}
+}
+
+// release keeps the connection for the next message, unless enough are open already
+func (s *SMTP) release(client *mail.SMTPClient) {
+	select {
+	case s.idle <- client:
+	default:
+		_ = client.Quit()
+		_ = client.Close()
+	}
+}
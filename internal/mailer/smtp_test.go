@@ -0,0 +1,354 @@
+package mailer
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"github.com/zahnah/study-app/internal/models"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMessage is a message the fake server accepted
+type fakeMessage struct {
+	From string
+	To   []string
+	Data string
+	User string
+	TLS  bool
+}
+
+// fakeSMTP is an SMTP server that keeps the messages it is sent. It offers STARTTLS when it has
+// startTLS, accepts the password "secret" for any user and rejects the recipient reject@example.com.
+type fakeSMTP struct {
+	listener net.Listener
+	startTLS *tls.Config
+
+	mu          sync.Mutex
+	messages    []fakeMessage
+	connections int
+}
+
+func newFakeSMTP(t *testing.T, implicitTLS, startTLS *tls.Config) *fakeSMTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if implicitTLS != nil {
+		listener = tls.NewListener(listener, implicitTLS)
+	}
+
+	s := &fakeSMTP{listener: listener, startTLS: startTLS}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.connections++
+			s.mu.Unlock()
+			go s.serve(conn, implicitTLS != nil)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	return s
+}
+
+func (s *fakeSMTP) config() SMTPConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return SMTPConfig{Host: "127.0.0.1", Port: addr.Port}
+}
+
+func (s *fakeSMTP) delivered() ([]fakeMessage, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeMessage(nil), s.messages...), s.connections
+}
+
+func (s *fakeSMTP) serve(conn net.Conn, secure bool) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			_, _ = fmt.Fprintf(conn, "%s\r\n", line)
+		}
+	}
+
+	var msg fakeMessage
+	var user string
+	reply("220 fake ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO":
+			lines := []string{"250-fake", "250-AUTH PLAIN"}
+			if s.startTLS != nil && !secure {
+				lines = append(lines, "250-STARTTLS")
+			}
+			reply(append(lines, "250 8BITMIME")...)
+		case "HELO", "NOOP":
+			reply("250 ok")
+		case "STARTTLS":
+			reply("220 go ahead")
+			tlsConn := tls.Server(conn, s.startTLS)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, reader, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case "AUTH":
+			fields := strings.Fields(line)
+			credentials, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			parts := strings.Split(string(credentials), "\x00")
+			if len(parts) != 3 || parts[2] != "secret" {
+				reply("535 authentication failed")
+				continue
+			}
+			user = parts[1]
+			reply("235 ok")
+		case "MAIL":
+			msg = fakeMessage{From: address(line), User: user, TLS: secure}
+			reply("250 ok")
+		case "RCPT":
+			to := address(line)
+			if to == "reject@example.com" {
+				reply("550 no such user")
+				continue
+			}
+			msg.To = append(msg.To, to)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg.Data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "RSET":
+			msg = fakeMessage{}
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// address returns the address between the angle brackets of a MAIL or RCPT command
+func address(line string) string {
+	start, end := strings.Index(line, "<"), strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+// testTLS returns a server configuration with a certificate for 127.0.0.1 and a client configuration trusting it
+func testTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+	return server, client
+}
+
+func testMail(to string) models.MailData {
+	return models.MailData{
+		To:      to,
+		From:    "me@local.local",
+		Subject: "Reservation Confirmation",
+		Content: "<html><body><b>See you soon</b></body></html>",
+		Text:    "See you soon\n",
+		Attachments: []models.MailAttachment{
+			{Name: "reservation.ics", ContentType: "text/calendar; charset=utf-8", Data: []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")},
+		},
+	}
+}
+
+func TestSMTP_Send(t *testing.T) {
+	server := newFakeSMTP(t, nil, nil)
+
+	smtp, err := NewSMTP(server.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(smtp *SMTP) {
+		_ = smtp.Close()
+	}(smtp)
+
+	for _, to := range []string{"john@smith.com", "jane@smith.com"} {
+		err = smtp.Send(testMail(to))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages, connections := server.delivered()
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, the server got %d", len(messages))
+	}
+	if connections != 1 {
+		t.Errorf("expected the messages to share a connection, got %d connections", connections)
+	}
+
+	msg := messages[0]
+	if msg.From != "me@local.local" || len(msg.To) != 1 || msg.To[0] != "john@smith.com" {
+		t.Errorf("unexpected envelope from %s to %v", msg.From, msg.To)
+	}
+	for _, expected := range []string{"Subject: Reservation Confirmation", "multipart/alternative", "text/plain", "<html><body>", "reservation.ics", "text/calendar"} {
+		if !strings.Contains(msg.Data, expected) {
+			t.Errorf("the message doesn't contain %q:\n%s", expected, msg.Data)
+		}
+	}
+	if msg.TLS || msg.User != "" {
+		t.Error("expected a plain connection without authentication")
+	}
+}
+
+func TestSMTP_SendEncrypted(t *testing.T) {
+	serverTLS, clientTLS := testTLS(t)
+
+	tests := []struct {
+		name       string
+		server     *fakeSMTP
+		encryption string
+	}{
+		{"starttls", newFakeSMTP(t, nil, serverTLS), EncryptionSTARTTLS},
+		{"implicit tls", newFakeSMTP(t, serverTLS, nil), EncryptionTLS},
+	}
+
+	for _, e := range tests {
+		cfg := e.server.config()
+		cfg.Encryption = e.encryption
+		cfg.TLSConfig = clientTLS
+		cfg.Username = "owner"
+		cfg.Password = "secret"
+
+		smtp, err := NewSMTP(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = smtp.Send(testMail("john@smith.com"))
+		_ = smtp.Close()
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+
+		messages, _ := e.server.delivered()
+		if len(messages) != 1 || !messages[0].TLS || messages[0].User != "owner" {
+			t.Errorf("%s: expected an authenticated message over TLS, got %+v", e.name, messages)
+		}
+	}
+}
+
+func TestSMTP_SendErrors(t *testing.T) {
+	server := newFakeSMTP(t, nil, nil)
+
+	smtp, err := NewSMTP(server.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(smtp *SMTP) {
+		_ = smtp.Close()
+	}(smtp)
+
+	err = smtp.Send(testMail("reject@example.com"))
+	if err == nil {
+		t.Error("expected the rejected recipient to fail the message")
+	}
+
+	// the failed connection is dropped, the next message gets a new one
+	err = smtp.Send(testMail("john@smith.com"))
+	if err != nil {
+		t.Errorf("expected the next message to be sent: %v", err)
+	}
+
+	cfg := server.config()
+	cfg.Username = "owner"
+	cfg.Password = "wrong"
+	wrongPassword, err := NewSMTP(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wrongPassword.Send(testMail("john@smith.com"))
+	if err == nil {
+		t.Error("expected a wrong password to fail the message")
+	}
+
+	closed := newFakeSMTP(t, nil, nil)
+	cfg = closed.config()
+	_ = closed.listener.Close()
+	unreachable, err := NewSMTP(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = unreachable.Send(testMail("john@smith.com"))
+	if err == nil {
+		t.Error("expected an unreachable server to fail the message")
+	}
+
+	_, err = NewSMTP(SMTPConfig{Host: "localhost", Port: 25, Encryption: "ssl"})
+	if err == nil {
+		t.Error("expected an unknown encryption to be refused")
+	}
+}
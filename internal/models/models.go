@@ -1,6 +1,11 @@
 package models
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -16,12 +21,273 @@ type User struct {
 }
 
 type Room struct {
+	ID                   int
+	RoomName             string
+	Description          string
+	Amenities            string
+	NightlyRate          int
+	CancellationPolicyID int
+	MaxAdults            int
+	MaxChildren          int
+	Active               bool
+	SortOrder            int
+	RoomTypeID           int
+	ICalToken            string
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	Photos               []RoomPhoto
+	RoomType             RoomType
+	FreeUnits            int
+}
+
+// RoomType groups interchangeable rooms, e.g. "Standard double". Every room of a type is one of its
+// units: guests book the type and are given whichever unit is free. Units is the number of active rooms
+// of the type.
+type RoomType struct {
+	ID          int
+	Name        string
+	Description string
+	Units       int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// RoomPhoto is a picture of a room, shown in SortOrder on the room page. Path is the
+// web-sized image and ThumbPath its thumbnail.
+type RoomPhoto struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	Path      string
+	ThumbPath string
+	SortOrder int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// Thumb returns the thumbnail of the photo, or the photo itself when it has none
+func (p RoomPhoto) Thumb() string {
+	if p.ThumbPath == "" {
+		return p.Path
+	}
+	return p.ThumbPath
+}
+
+// HasType reports whether the room is a unit of a room type rather than a room of its own
+func (r Room) HasType() bool {
+	return r.RoomTypeID > 0
+}
+
+// DisplayName is the name guests see: the name of the room's type for a unit, the room's own name otherwise
+func (r Room) DisplayName() string {
+	if r.HasType() && r.RoomType.Name != "" {
+		return r.RoomType.Name
+	}
+	return r.RoomName
+}
+
+// AmenityList splits the room's amenities, one per line, skipping blank lines
+func (r Room) AmenityList() []string {
+	var amenities []string
+	for _, line := range strings.Split(r.Amenities, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			amenities = append(amenities, line)
+		}
+	}
+	return amenities
+}
+
+// Cover returns the path of the room's first photo, or an empty string when it has none
+func (r Room) Cover() string {
+	if len(r.Photos) == 0 {
+		return ""
+	}
+	return r.Photos[0].Path
+}
+
+// Fits reports whether the room sleeps the given guests. Children may take an adult's bed,
+// adults can't take a child's.
+func (r Room) Fits(adults, children int) bool {
+	return adults <= r.MaxAdults && adults+children <= r.MaxAdults+r.MaxChildren
+}
+
+// Capacity describes how many guests the room sleeps
+func (r Room) Capacity() string {
+	return guestCount(r.MaxAdults, r.MaxChildren)
+}
+
+// guestCount spells out a number of adults and children, e.g. "2 adults, 1 child"
+func guestCount(adults, children int) string {
+	text := fmt.Sprintf("%d adult", adults)
+	if adults != 1 {
+		text += "s"
+	}
+	switch {
+	case children == 1:
+		text += ", 1 child"
+	case children > 1:
+		text += fmt.Sprintf(", %d children", children)
+	}
+	return text
+}
+
+// Cancellation penalty types
+const (
+	CancellationPenaltyPercent    = "percent"
+	CancellationPenaltyFirstNight = "first_night"
+)
+
+// CancellationPolicy lets guests cancel for free until FreeDays before arrival; later cancellations
+// are charged PenaltyPercent of the total price or the price of the first night
+type CancellationPolicy struct {
+	ID             int
+	Name           string
+	FreeDays       int
+	PenaltyType    string
+	PenaltyPercent int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Description explains the policy to guests
+func (p CancellationPolicy) Description() string {
+	if p.ID == 0 {
+		return "Free cancellation until arrival"
+	}
+
+	penalty := "the first night is charged"
+	if p.PenaltyType == CancellationPenaltyPercent {
+		penalty = fmt.Sprintf("%d%% of the total price is charged", p.PenaltyPercent)
+	}
+	return fmt.Sprintf("Free cancellation until %d days before arrival, after that %s", p.FreeDays, penalty)
+}
+
+// RoomRate is the base nightly rate of a room, in cents
+type RoomRate struct {
+	ID          int
+	RoomID      int
+	NightlyRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Rate rule amount types
+const (
+	RateRuleFixed   = "fixed"
+	RateRulePercent = "percent"
+)
+
+// AllWeekdays is the weekday mask matching every day of the week
+const AllWeekdays = 1<<7 - 1
+
+// RateRule overrides the base nightly rate for the nights it matches. A rule with RoomID 0
+// applies to every room, Weekdays is a bit mask indexed by time.Weekday and EndDate is inclusive.
+// Fixed rules replace the rate with Amount cents, percent rules adjust it by Amount percent.
+type RateRule struct {
+	ID         int
+	Name       string
+	RoomID     int
+	StartDate  time.Time
+	EndDate    time.Time
+	Weekdays   int
+	Priority   int
+	AmountType string
+	Amount     int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Room       Room
+}
+
+// Matches reports whether the rule applies to the night starting on date for the given room
+func (r RateRule) Matches(roomID int, date time.Time) bool {
+	if r.RoomID != 0 && r.RoomID != roomID {
+		return false
+	}
+	if date.Before(r.StartDate) || date.After(r.EndDate) {
+		return false
+	}
+	return r.Weekdays&(1<<uint(date.Weekday())) != 0
+}
+
+// Apply returns the nightly rate after the rule is applied to rate
+func (r RateRule) Apply(rate int) int {
+	if r.AmountType == RateRuleFixed {
+		return r.Amount
+	}
+	return rate * (100 + r.Amount) / 100
+}
+
+// StayRule limits the stays that may arrive (min/max nights, closed to arrival) or depart
+// (closed to departure) on the days it covers. RoomID 0 applies to every room, Weekdays is
+// a bit mask indexed by time.Weekday, EndDate is inclusive and zero nights means no limit.
+type StayRule struct {
+	ID                int
+	RoomID            int
+	StartDate         time.Time
+	EndDate           time.Time
+	Weekdays          int
+	MinNights         int
+	MaxNights         int
+	ClosedToArrival   bool
+	ClosedToDeparture bool
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Room              Room
+}
+
+// Covers reports whether the rule applies to the given room on date
+func (r StayRule) Covers(roomID int, date time.Time) bool {
+	if r.RoomID != 0 && r.RoomID != roomID {
+		return false
+	}
+	if date.Before(r.StartDate) || date.After(r.EndDate) {
+		return false
+	}
+	return r.Weekdays&(1<<uint(date.Weekday())) != 0
+}
+
+// Violation returns why a stay in the room from start to end breaks the rule, or an empty string
+func (r StayRule) Violation(roomID int, start, end time.Time) string {
+	nights := int(math.Round(end.Sub(start).Hours() / 24))
+
+	if r.Covers(roomID, start) {
+		if r.ClosedToArrival {
+			return fmt.Sprintf("Arrivals are not possible on %s", start.Format("2006-01-02"))
+		}
+		if r.MinNights > 0 && nights < r.MinNights {
+			return fmt.Sprintf("The minimum stay is %d nights", r.MinNights)
+		}
+		if r.MaxNights > 0 && nights > r.MaxNights {
+			return fmt.Sprintf("The maximum stay is %d nights", r.MaxNights)
+		}
+	}
+
+	if r.ClosedToDeparture && r.Covers(roomID, end) {
+		return fmt.Sprintf("Departures are not possible on %s", end.Format("2006-01-02"))
+	}
+
+	return ""
+}
+
+// NightPrice is the price of a single night, in cents
+type NightPrice struct {
+	Date  time.Time
+	Price int
+	Rule  string
+}
+
+// PriceBreakdown is the night by night price of a stay, in cents
+type PriceBreakdown struct {
+	Nights []NightPrice
+	Total  int
+}
+
+// Restriction IDs seeded by the restrictions table migration
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionHold        = 3
+)
+
 type Restriction struct {
 	ID              int
 	RestrictionName string
@@ -30,18 +296,214 @@ type Restriction struct {
 }
 
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Processed int
-	Room      Room
+	ID                  int
+	FirstName           string
+	LastName            string
+	Email               string
+	Phone               string
+	StartDate           time.Time
+	EndDate             time.Time
+	RoomID              int
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	Status              ReservationStatus
+	TotalPrice          int
+	ConfirmationCode    string
+	Adults              int
+	Children            int
+	CancelledAt         *time.Time
+	CancellationReason  string
+	CancellationPenalty int
+	Room                Room
+	Rooms               []ReservationRoom
+}
+
+// ReservationRoom is one of the rooms booked by a reservation, with its own dates and price
+type ReservationRoom struct {
+	ID            int
+	ReservationID int
+	RoomID        int
+	StartDate     time.Time
+	EndDate       time.Time
+	TotalPrice    int
+	Room          Room
+}
+
+// RoomStays returns the rooms the reservation books. Reservations that haven't been given
+// their rooms (e.g. loaded without them) book their own room for their own dates.
+func (r Reservation) RoomStays() []ReservationRoom {
+	if len(r.Rooms) > 0 {
+		return r.Rooms
+	}
+	return []ReservationRoom{
+		{
+			ReservationID: r.ID,
+			RoomID:        r.RoomID,
+			StartDate:     r.StartDate,
+			EndDate:       r.EndDate,
+			TotalPrice:    r.TotalPrice,
+			Room:          r.Room,
+		},
+	}
+}
+
+// Guests describes who the reservation is for, e.g. "2 adults, 1 child"
+func (r Reservation) Guests() string {
+	return guestCount(r.Adults, r.Children)
+}
+
+// Fits reports whether the rooms of the reservation sleep its party together
+func (r Reservation) Fits() bool {
+	return r.combinedRoom().Fits(r.Adults, r.Children)
+}
+
+// Capacity describes how many guests the rooms of the reservation sleep together
+func (r Reservation) Capacity() string {
+	return r.combinedRoom().Capacity()
+}
+
+// combinedRoom adds up the beds of all the rooms of the reservation
+func (r Reservation) combinedRoom() Room {
+	var combined Room
+	for _, stay := range r.RoomStays() {
+		combined.MaxAdults += stay.Room.MaxAdults
+		combined.MaxChildren += stay.Room.MaxChildren
+	}
+	return combined
+}
+
+// IsGroup reports whether the reservation books more than one room
+func (r Reservation) IsGroup() bool {
+	return len(r.Rooms) > 1
+}
+
+// SummarizeRooms derives the reservation's own room, dates and total price from its rooms:
+// the first room, the earliest arrival, the latest departure and the sum of the prices
+func (r *Reservation) SummarizeRooms() {
+	if len(r.Rooms) == 0 {
+		return
+	}
+
+	r.RoomID = r.Rooms[0].RoomID
+	r.Room = r.Rooms[0].Room
+	r.StartDate = r.Rooms[0].StartDate
+	r.EndDate = r.Rooms[0].EndDate
+	r.TotalPrice = 0
+
+	for _, room := range r.Rooms {
+		if room.StartDate.Before(r.StartDate) {
+			r.StartDate = room.StartDate
+		}
+		if room.EndDate.After(r.EndDate) {
+			r.EndDate = room.EndDate
+		}
+		r.TotalPrice += room.TotalPrice
+	}
+}
+
+// IsCancelled reports whether the reservation has been cancelled
+func (r Reservation) IsCancelled() bool {
+	return r.Status == ReservationCancelled
+}
+
+// IsUpcoming reports whether the guest hasn't arrived yet and can still change or cancel the stay
+func (r Reservation) IsUpcoming() bool {
+	return r.Status == ReservationPending || r.Status == ReservationConfirmed
+}
+
+// IsOpen reports whether the stay isn't over yet, so the reservation can still be moved
+func (r Reservation) IsOpen() bool {
+	for _, status := range OpenReservationStatuses {
+		if r.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStatuses returns the statuses the reservation can move to
+func (r Reservation) NextStatuses() []ReservationStatus {
+	return reservationTransitions[r.Status]
+}
+
+// ReservationStatus is a step in the reservation lifecycle
+type ReservationStatus string
+
+// Reservation statuses
+const (
+	ReservationPending    ReservationStatus = "pending"
+	ReservationConfirmed  ReservationStatus = "confirmed"
+	ReservationCheckedIn  ReservationStatus = "checked_in"
+	ReservationCheckedOut ReservationStatus = "checked_out"
+	ReservationNoShow     ReservationStatus = "no_show"
+	ReservationCancelled  ReservationStatus = "cancelled"
+)
+
+// ReservationStatuses lists every status in lifecycle order
+var ReservationStatuses = []ReservationStatus{
+	ReservationPending,
+	ReservationConfirmed,
+	ReservationCheckedIn,
+	ReservationCheckedOut,
+	ReservationNoShow,
+	ReservationCancelled,
+}
+
+// OpenReservationStatuses are the statuses of reservations whose stay isn't over yet
+var OpenReservationStatuses = []ReservationStatus{
+	ReservationPending,
+	ReservationConfirmed,
+	ReservationCheckedIn,
+}
+
+// reservationTransitions lists the statuses a reservation can move to from each status,
+// checked out, no-show and cancelled are final
+var reservationTransitions = map[ReservationStatus][]ReservationStatus{
+	ReservationPending:   {ReservationConfirmed, ReservationCancelled},
+	ReservationConfirmed: {ReservationCheckedIn, ReservationNoShow, ReservationCancelled},
+	ReservationCheckedIn: {ReservationCheckedOut},
+}
+
+var reservationStatusLabels = map[ReservationStatus]string{
+	ReservationPending:    "Pending",
+	ReservationConfirmed:  "Confirmed",
+	ReservationCheckedIn:  "Checked in",
+	ReservationCheckedOut: "Checked out",
+	ReservationNoShow:     "No-show",
+	ReservationCancelled:  "Cancelled",
+}
+
+// Valid reports whether s is a known status
+func (s ReservationStatus) Valid() bool {
+	_, ok := reservationStatusLabels[s]
+	return ok
+}
+
+// Label returns the status the way admins and guests read it
+func (s ReservationStatus) Label() string {
+	return reservationStatusLabels[s]
+}
+
+// CanTransitionTo reports whether a reservation in status s can move to next
+func (s ReservationStatus) CanTransitionTo(next ReservationStatus) bool {
+	for _, allowed := range reservationTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// ReservationStatusChange records a status change, UserID is 0 when the guest made the change
+type ReservationStatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    ReservationStatus
+	ToStatus      ReservationStatus
+	UserID        int
+	Note          string
+	CreatedAt     time.Time
+	User          User
 }
 
 type RoomRestriction struct {
@@ -49,8 +511,12 @@ type RoomRestriction struct {
 	RestrictionID int
 	ReservationID int
 	RoomID        int
+	OwnerBlockID  int
+	ICalImportID  int
+	ICalUID       string
 	StartDate     time.Time
 	EndDate       time.Time
+	Note          string
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
@@ -58,10 +524,204 @@ type RoomRestriction struct {
 	Restriction   Restriction
 }
 
+// RestrictionsVersion fingerprints the restrictions of a room, so an edit of the calendar can tell whether they
+// changed since the calendar was shown. Holds come and go with every search and are left out.
+func RestrictionsVersion(restrictions []RoomRestriction) string {
+	var lines []string
+	for _, r := range restrictions {
+		if r.RestrictionID == RestrictionHold {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%d:%d:%d:%s:%s:%d", r.ID, r.RestrictionID, r.ReservationID,
+			r.StartDate.Format("2006-01-02"), r.EndDate.Format("2006-01-02"), r.UpdatedAt.UnixMicro()))
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// CalendarChange is an edit of one room's calendar from Start to End, inclusive: the nights to block and
+// the nights to free, made on the calendar whose restrictions had Version
+type CalendarChange struct {
+	RoomID  int
+	Start   time.Time
+	End     time.Time
+	Version string
+	Add     []time.Time
+	Remove  []time.Time
+}
+
+// Owner block recurrences
+const (
+	BlockOnce   = "once"
+	BlockWeekly = "weekly"
+	BlockYearly = "yearly"
+)
+
+// OwnerBlock takes a room off sale with a note saying why. A block once covers the nights from StartDate
+// to EndDate (inclusive), a weekly block the nights on Weekdays (a bit mask indexed by time.Weekday) between
+// them, and a yearly block the same nights for Years years. It is stored as the room restrictions it expands to.
+type OwnerBlock struct {
+	ID         int
+	RoomID     int
+	Note       string
+	Recurrence string
+	Weekdays   int
+	StartDate  time.Time
+	EndDate    time.Time
+	Years      int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Room       Room
+}
+
+// Restrictions expands the block into the owner block restrictions of its nights, consecutive
+// nights sharing a restriction
+func (b OwnerBlock) Restrictions() []RoomRestriction {
+	var restrictions []RoomRestriction
+
+	add := func(start, end time.Time) {
+		restrictions = append(restrictions, RoomRestriction{
+			RestrictionID: RestrictionOwnerBlock,
+			RoomID:        b.RoomID,
+			OwnerBlockID:  b.ID,
+			StartDate:     start,
+			EndDate:       end,
+			Note:          b.Note,
+		})
+	}
+
+	switch b.Recurrence {
+	case BlockWeekly:
+		var start time.Time
+		for d := b.StartDate; !d.After(b.EndDate); d = d.AddDate(0, 0, 1) {
+			blocked := b.Weekdays&(1<<uint(d.Weekday())) != 0
+			if blocked && start.IsZero() {
+				start = d
+			} else if !blocked && !start.IsZero() {
+				add(start, d)
+				start = time.Time{}
+			}
+		}
+		if !start.IsZero() {
+			add(start, b.EndDate.AddDate(0, 0, 1))
+		}
+	case BlockYearly:
+		for year := 0; year < b.Years; year++ {
+			add(b.StartDate.AddDate(year, 0, 0), b.EndDate.AddDate(year, 0, 1))
+		}
+	default:
+		add(b.StartDate, b.EndDate.AddDate(0, 0, 1))
+	}
+
+	return restrictions
+}
+
+// Describe explains when the block applies, e.g. "every Monday from 2023-06-05 to 2023-12-25"
+func (b OwnerBlock) Describe() string {
+	dates := fmt.Sprintf("%s to %s", b.StartDate.Format("2006-01-02"), b.EndDate.Format("2006-01-02"))
+
+	switch b.Recurrence {
+	case BlockWeekly:
+		var days []string
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if b.Weekdays&(1<<uint(day)) != 0 {
+				days = append(days, day.String())
+			}
+		}
+		return fmt.Sprintf("every %s from %s", strings.Join(days, ", "), dates)
+	case BlockYearly:
+		return fmt.Sprintf("%s, every year for %d years", dates, b.Years)
+	default:
+		return dates
+	}
+}
+
+// ICalImport is a calendar of another channel the room is listed on. Its events are kept as owner block
+// restrictions of the room tagged with their UID, so that the room can't be booked twice. Calendars with a
+// URL are fetched again every so often, the others are uploaded as files.
+type ICalImport struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string
+	LastSyncedAt *time.Time
+	LastError    string
+	Blocks       int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
+// ICalSync counts what a sync of an imported calendar changed. Conflicts are the events whose nights
+// are already taken in this app, they aren't blocked.
+type ICalSync struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Conflicts []string
+}
+
+// Summary describes the sync, e.g. "2 added, 1 updated, 0 removed"
+func (s ICalSync) Summary() string {
+	summary := fmt.Sprintf("%d added, %d updated, %d removed", s.Added, s.Updated, s.Removed)
+	if len(s.Conflicts) > 0 {
+		summary += fmt.Sprintf(", %d on nights already taken", len(s.Conflicts))
+	}
+	return summary
+}
+
+// MailData is an email. Content is its HTML body and Text the plain text alternative.
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string
+	Text        string
+	Attachments []MailAttachment
+}
+
+// MailAttachment is a file sent along with an email
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// MailStatus is where an email is in the outbox
+type MailStatus string
+
+// Mail statuses
+const (
+	MailPending MailStatus = "pending"
+	MailSent    MailStatus = "sent"
+	MailDead    MailStatus = "dead"
+)
+
+// OutboxMail is an email in the outbox. Pending mail is sent when NextAttemptAt comes, mail that failed
+// too many times is dead until an admin resends it.
+type OutboxMail struct {
+	ID            int
+	Mail          MailData
+	Status        MailStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// EmailTemplate is the wording of an email as changed by the owner. Subject, HTML and Text are template
+// sources, the bodies are rendered inside the email layout. Emails that haven't been changed use the files
+// of the email-templates directory.
+type EmailTemplate struct {
+	ID        int
+	Name      string
+	Subject   string
+	HTML      string
+	Text      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
@@ -0,0 +1,329 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStayRule_Violation(t *testing.T) {
+	date := func(day int) time.Time {
+		return time.Date(2050, 1, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	rule := StayRule{
+		RoomID:    1,
+		StartDate: date(1),
+		EndDate:   date(31),
+		Weekdays:  AllWeekdays,
+		MinNights: 3,
+		MaxNights: 7,
+	}
+
+	var tests = []struct {
+		name     string
+		rule     StayRule
+		roomID   int
+		start    time.Time
+		end      time.Time
+		violated bool
+	}{
+		{"long enough", rule, 1, date(10), date(13), false},
+		{"too short", rule, 1, date(10), date(12), true},
+		{"too long", rule, 1, date(10), date(18), true},
+		{"other room", rule, 2, date(10), date(11), false},
+		{"arrival outside of the rule", rule, 1, date(31).AddDate(0, 0, 1), date(31).AddDate(0, 0, 2), false},
+		{"closed to arrival", StayRule{StartDate: date(5), EndDate: date(5), Weekdays: AllWeekdays, ClosedToArrival: true}, 1, date(5), date(6), true},
+		{"closed to departure", StayRule{StartDate: date(6), EndDate: date(6), Weekdays: AllWeekdays, ClosedToDeparture: true}, 1, date(5), date(6), true},
+		{"departure on another weekday", StayRule{StartDate: date(1), EndDate: date(31), Weekdays: 1 << time.Sunday, ClosedToDeparture: true}, 1, date(5), date(6), false},
+	}
+
+	for _, e := range tests {
+		reason := e.rule.Violation(e.roomID, e.start, e.end)
+		if (reason != "") != e.violated {
+			t.Errorf("%s: expected violation %t, got '%s'", e.name, e.violated, reason)
+		}
+	}
+}
+
+func TestCancellationPolicy_Description(t *testing.T) {
+	var tests = []struct {
+		name     string
+		policy   CancellationPolicy
+		expected string
+	}{
+		{"no policy", CancellationPolicy{}, "Free cancellation until arrival"},
+		{"first night", CancellationPolicy{ID: 1, FreeDays: 7, PenaltyType: CancellationPenaltyFirstNight},
+			"Free cancellation until 7 days before arrival, after that the first night is charged"},
+		{"percent", CancellationPolicy{ID: 2, FreeDays: 3, PenaltyType: CancellationPenaltyPercent, PenaltyPercent: 50},
+			"Free cancellation until 3 days before arrival, after that 50% of the total price is charged"},
+	}
+
+	for _, e := range tests {
+		if description := e.policy.Description(); description != e.expected {
+			t.Errorf("%s: expected '%s', got '%s'", e.name, e.expected, description)
+		}
+	}
+}
+
+func TestReservationStatus_CanTransitionTo(t *testing.T) {
+	var tests = []struct {
+		from     ReservationStatus
+		to       ReservationStatus
+		expected bool
+	}{
+		{ReservationPending, ReservationConfirmed, true},
+		{ReservationPending, ReservationCancelled, true},
+		{ReservationPending, ReservationCheckedIn, false},
+		{ReservationConfirmed, ReservationCheckedIn, true},
+		{ReservationConfirmed, ReservationNoShow, true},
+		{ReservationCheckedIn, ReservationCheckedOut, true},
+		{ReservationCheckedIn, ReservationCancelled, false},
+		{ReservationCheckedOut, ReservationCheckedIn, false},
+		{ReservationNoShow, ReservationConfirmed, false},
+		{ReservationCancelled, ReservationPending, false},
+		{ReservationPending, ReservationPending, false},
+	}
+
+	for _, e := range tests {
+		if result := e.from.CanTransitionTo(e.to); result != e.expected {
+			t.Errorf("%s -> %s: expected %t, got %t", e.from, e.to, e.expected, result)
+		}
+	}
+}
+
+func TestReservationStatus_Valid(t *testing.T) {
+	for _, status := range ReservationStatuses {
+		if !status.Valid() || status.Label() == "" {
+			t.Errorf("%s has to be valid and labelled", status)
+		}
+	}
+	if ReservationStatus("processed").Valid() {
+		t.Error("unknown status is reported as valid")
+	}
+}
+
+func TestReservation_IsOpen(t *testing.T) {
+	open := map[ReservationStatus]bool{
+		ReservationPending:    true,
+		ReservationConfirmed:  true,
+		ReservationCheckedIn:  true,
+		ReservationCheckedOut: false,
+		ReservationNoShow:     false,
+		ReservationCancelled:  false,
+	}
+	for status, expected := range open {
+		if (Reservation{Status: status}).IsOpen() != expected {
+			t.Errorf("%s: expected open to be %t", status, expected)
+		}
+	}
+}
+
+func TestReservation_SummarizeRooms(t *testing.T) {
+	date := func(day int) time.Time {
+		return time.Date(2050, 1, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	res := Reservation{
+		Rooms: []ReservationRoom{
+			{RoomID: 2, StartDate: date(3), EndDate: date(5), TotalPrice: 20000, Room: Room{ID: 2}},
+			{RoomID: 1, StartDate: date(1), EndDate: date(4), TotalPrice: 30000, Room: Room{ID: 1}},
+		},
+	}
+	res.SummarizeRooms()
+
+	if res.RoomID != 2 || res.Room.ID != 2 {
+		t.Errorf("expected the first room to be the reservation's room, got %d", res.RoomID)
+	}
+	if !res.StartDate.Equal(date(1)) || !res.EndDate.Equal(date(5)) {
+		t.Errorf("expected the stay to span 2050-01-01 - 2050-01-05, got %s - %s", res.StartDate, res.EndDate)
+	}
+	if res.TotalPrice != 50000 {
+		t.Errorf("expected a total of 50000, got %d", res.TotalPrice)
+	}
+	if !res.IsGroup() {
+		t.Error("a reservation with two rooms is a group")
+	}
+}
+
+func TestReservation_RoomStays(t *testing.T) {
+	res := Reservation{ID: 1, RoomID: 3, TotalPrice: 100}
+	stays := res.RoomStays()
+	if len(stays) != 1 || stays[0].RoomID != 3 || stays[0].TotalPrice != 100 {
+		t.Errorf("expected the reservation's own room, got %v", stays)
+	}
+}
+
+func TestRoom_Fits(t *testing.T) {
+	room := Room{MaxAdults: 2, MaxChildren: 1}
+
+	var tests = []struct {
+		adults   int
+		children int
+		fits     bool
+	}{
+		{1, 0, true},
+		{2, 1, true},
+		{1, 2, true},
+		{3, 0, false},
+		{2, 2, false},
+	}
+
+	for _, e := range tests {
+		if fits := room.Fits(e.adults, e.children); fits != e.fits {
+			t.Errorf("%d adults, %d children: expected fits %t, got %t", e.adults, e.children, e.fits, fits)
+		}
+	}
+}
+
+func TestReservation_Fits(t *testing.T) {
+	room := Room{MaxAdults: 2, MaxChildren: 1}
+
+	var tests = []struct {
+		name     string
+		rooms    int
+		adults   int
+		children int
+		fits     bool
+		capacity string
+	}{
+		{"one room", 1, 2, 1, true, "2 adults, 1 child"},
+		{"too many for one room", 1, 3, 0, false, "2 adults, 1 child"},
+		{"two rooms", 2, 4, 2, true, "4 adults, 2 children"},
+		{"children in the adults' beds", 2, 1, 5, true, "4 adults, 2 children"},
+		{"too many adults for two rooms", 2, 5, 0, false, "4 adults, 2 children"},
+		{"too many guests for two rooms", 2, 4, 3, false, "4 adults, 2 children"},
+	}
+
+	for _, e := range tests {
+		res := Reservation{Adults: e.adults, Children: e.children}
+		for i := 0; i < e.rooms; i++ {
+			res.Rooms = append(res.Rooms, ReservationRoom{Room: room})
+		}
+		if fits := res.Fits(); fits != e.fits {
+			t.Errorf("%s: expected fits %t, got %t", e.name, e.fits, fits)
+		}
+		if capacity := res.Capacity(); capacity != e.capacity {
+			t.Errorf("%s: expected capacity '%s', got '%s'", e.name, e.capacity, capacity)
+		}
+	}
+}
+
+func TestReservation_Guests(t *testing.T) {
+	var tests = []struct {
+		adults   int
+		children int
+		expected string
+	}{
+		{1, 0, "1 adult"},
+		{2, 1, "2 adults, 1 child"},
+		{2, 3, "2 adults, 3 children"},
+	}
+
+	for _, e := range tests {
+		if guests := (Reservation{Adults: e.adults, Children: e.children}).Guests(); guests != e.expected {
+			t.Errorf("expected '%s', got '%s'", e.expected, guests)
+		}
+	}
+}
+
+func TestRoom_AmenityList(t *testing.T) {
+	room := Room{Amenities: "Wi-Fi\n\n  Balcony \r\nSea view"}
+
+	amenities := room.AmenityList()
+	expected := []string{"Wi-Fi", "Balcony", "Sea view"}
+	if len(amenities) != len(expected) {
+		t.Fatalf("expected %d amenities, got %d", len(expected), len(amenities))
+	}
+	for i := range expected {
+		if amenities[i] != expected[i] {
+			t.Errorf("expected '%s', got '%s'", expected[i], amenities[i])
+		}
+	}
+}
+
+func TestRoom_DisplayName(t *testing.T) {
+	room := Room{RoomName: "Double 3"}
+	if name := room.DisplayName(); name != "Double 3" {
+		t.Errorf("expected a room without a type to show its own name, got %q", name)
+	}
+
+	room.RoomTypeID = 1
+	room.RoomType = RoomType{ID: 1, Name: "Standard double"}
+	if name := room.DisplayName(); name != "Standard double" {
+		t.Errorf("expected a unit to show the name of its type, got %q", name)
+	}
+}
+
+func TestRestrictionsVersion(t *testing.T) {
+	night := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	block := RoomRestriction{ID: 1, RestrictionID: RestrictionOwnerBlock, StartDate: night, EndDate: night.AddDate(0, 0, 1)}
+	reservation := RoomRestriction{ID: 2, RestrictionID: RestrictionReservation, ReservationID: 1, StartDate: night, EndDate: night.AddDate(0, 0, 2)}
+	hold := RoomRestriction{ID: 3, RestrictionID: RestrictionHold, StartDate: night, EndDate: night.AddDate(0, 0, 2)}
+
+	version := RestrictionsVersion([]RoomRestriction{block, reservation})
+	if RestrictionsVersion([]RoomRestriction{reservation, hold, block}) != version {
+		t.Error("the version depends on the order of the restrictions or on holds")
+	}
+	if RestrictionsVersion([]RoomRestriction{reservation}) == version {
+		t.Error("removing a block doesn't change the version")
+	}
+
+	moved := block
+	moved.UpdatedAt = night
+	if RestrictionsVersion([]RoomRestriction{moved, reservation}) == version {
+		t.Error("updating a block doesn't change the version")
+	}
+}
+
+func TestOwnerBlock_Restrictions(t *testing.T) {
+	// 2050-01-03 is a Monday
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2050, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	type nights struct {
+		start, end time.Time
+	}
+
+	tests := []struct {
+		name     string
+		block    OwnerBlock
+		expected []nights
+	}{
+		{
+			"once",
+			OwnerBlock{Recurrence: BlockOnce, StartDate: date(1, 3), EndDate: date(1, 5)},
+			[]nights{{date(1, 3), date(1, 6)}},
+		},
+		{
+			"every monday",
+			OwnerBlock{Recurrence: BlockWeekly, Weekdays: 1 << time.Monday, StartDate: date(1, 1), EndDate: date(1, 17)},
+			[]nights{{date(1, 3), date(1, 4)}, {date(1, 10), date(1, 11)}, {date(1, 17), date(1, 18)}},
+		},
+		{
+			"weekends merge",
+			OwnerBlock{Recurrence: BlockWeekly, Weekdays: 1<<time.Saturday | 1<<time.Sunday, StartDate: date(1, 1), EndDate: date(1, 9)},
+			[]nights{{date(1, 1), date(1, 3)}, {date(1, 8), date(1, 10)}},
+		},
+		{
+			"every year",
+			OwnerBlock{Recurrence: BlockYearly, Years: 2, StartDate: date(8, 1), EndDate: date(8, 7)},
+			[]nights{{date(8, 1), date(8, 8)}, {date(8, 1).AddDate(1, 0, 0), date(8, 8).AddDate(1, 0, 0)}},
+		},
+	}
+
+	for _, e := range tests {
+		restrictions := e.block.Restrictions()
+		if len(restrictions) != len(e.expected) {
+			t.Errorf("%s: expected %d restriction(s), got %d", e.name, len(e.expected), len(restrictions))
+			continue
+		}
+		for i, r := range restrictions {
+			if !r.StartDate.Equal(e.expected[i].start) || !r.EndDate.Equal(e.expected[i].end) {
+				t.Errorf("%s: expected %s - %s, got %s - %s", e.name, e.expected[i].start, e.expected[i].end, r.StartDate, r.EndDate)
+			}
+			if r.RestrictionID != RestrictionOwnerBlock {
+				t.Errorf("%s: expected an owner block restriction, got %d", e.name, r.RestrictionID)
+			}
+		}
+	}
+}
@@ -14,3 +14,17 @@ type TemplateData struct {
 	Form            *forms.Form
 	IsAuthenticated bool
 }
+
+// EmailData is what the email templates are rendered with. Stay is the room of the reservation the email
+// is about and PreviousStay what it was before a change. ManageURL is where the guest changes or cancels
+// the reservation, AdminURL where the owner sees it.
+type EmailData struct {
+	Reservation     Reservation
+	Stay            ReservationRoom
+	PreviousStay    ReservationRoom
+	CancellationFee int
+	PropertyName    string
+	PropertyAddress string
+	ManageURL       string
+	AdminURL        string
+}
@@ -0,0 +1,90 @@
+package pricing
+
+import (
+	"github.com/zahnah/study-app/internal/models"
+	"time"
+)
+
+// Calculate returns the price of every night between start and end (end is the departure day).
+// Each night is charged the room's base rate unless one of the rules matches it, in which case
+// the matching rule with the highest priority wins; room rules beat global ones on a tie.
+func Calculate(room models.Room, start, end time.Time, rules []models.RateRule) models.PriceBreakdown {
+	var breakdown models.PriceBreakdown
+
+	start, end = dateOnly(start), dateOnly(end)
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := models.NightPrice{
+			Date:  d,
+			Price: room.NightlyRate,
+		}
+
+		if rule, ok := resolve(room.ID, d, rules); ok {
+			night.Price = rule.Apply(room.NightlyRate)
+			night.Rule = rule.Name
+		}
+
+		breakdown.Nights = append(breakdown.Nights, night)
+		breakdown.Total += night.Price
+	}
+
+	return breakdown
+}
+
+// resolve picks the rule that prices the night starting on date
+func resolve(roomID int, date time.Time, rules []models.RateRule) (models.RateRule, bool) {
+	var best models.RateRule
+	found := false
+
+	for _, rule := range rules {
+		if !rule.Matches(roomID, date) {
+			continue
+		}
+		if !found || outranks(rule, best) {
+			best = rule
+			found = true
+		}
+	}
+
+	return best, found
+}
+
+func outranks(a, b models.RateRule) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if (a.RoomID != 0) != (b.RoomID != 0) {
+		return a.RoomID != 0
+	}
+	return a.ID > b.ID
+}
+
+// dateOnly drops the time of day so that a stay is counted in calendar nights
+func dateOnly(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// CancellationPenalty returns what a guest is charged for cancelling a stay that costs total and
+// whose first night costs firstNight. Cancelling at least FreeDays before arrival is free, and so
+// is any cancellation when the room has no policy.
+func CancellationPenalty(policy models.CancellationPolicy, arrival time.Time, total, firstNight int, now time.Time) int {
+	if policy.ID == 0 {
+		return 0
+	}
+
+	deadline := dateOnly(arrival).AddDate(0, 0, -policy.FreeDays)
+	if dateOnly(now).Before(deadline) {
+		return 0
+	}
+
+	switch policy.PenaltyType {
+	case models.CancellationPenaltyFirstNight:
+		if firstNight > total {
+			return total
+		}
+		return firstNight
+	case models.CancellationPenaltyPercent:
+		return total * policy.PenaltyPercent / 100
+	}
+	return 0
+}
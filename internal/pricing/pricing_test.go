@@ -0,0 +1,113 @@
+package pricing
+
+import (
+	"github.com/zahnah/study-app/internal/models"
+	"testing"
+	"time"
+)
+
+func TestCalculate(t *testing.T) {
+	room := models.Room{ID: 1, NightlyRate: 10000}
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC)
+
+	breakdown := Calculate(room, start, end, nil)
+	if len(breakdown.Nights) != 3 {
+		t.Errorf("expected 3 nights, got %d", len(breakdown.Nights))
+	}
+	if breakdown.Total != 30000 {
+		t.Errorf("expected total of 30000, got %d", breakdown.Total)
+	}
+	if !breakdown.Nights[2].Date.Equal(time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong date for the last night: %s", breakdown.Nights[2].Date)
+	}
+
+	breakdown = Calculate(room, start, start, nil)
+	if len(breakdown.Nights) != 0 || breakdown.Total != 0 {
+		t.Error("same day arrival and departure has to cost nothing")
+	}
+}
+
+func TestCalculate_Rules(t *testing.T) {
+	room := models.Room{ID: 1, NightlyRate: 10000}
+	// 2050-01-07 is a Friday
+	start := time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	rules := []models.RateRule{
+		{
+			ID: 1, Name: "Weekend", StartDate: start, EndDate: end.AddDate(1, 0, 0),
+			Weekdays:   1<<time.Friday | 1<<time.Saturday,
+			AmountType: models.RateRulePercent, Amount: 20,
+		},
+		{
+			ID: 2, Name: "Other room", RoomID: 2, StartDate: start, EndDate: end,
+			Weekdays: models.AllWeekdays, Priority: 10,
+			AmountType: models.RateRuleFixed, Amount: 1,
+		},
+		{
+			ID: 3, Name: "Holiday", RoomID: 1, StartDate: time.Date(2050, 1, 8, 0, 0, 0, 0, time.UTC),
+			EndDate:  time.Date(2050, 1, 8, 0, 0, 0, 0, time.UTC),
+			Weekdays: models.AllWeekdays, Priority: 5,
+			AmountType: models.RateRuleFixed, Amount: 25000,
+		},
+	}
+
+	breakdown := Calculate(room, start, end, rules)
+
+	expected := []int{10000, 12000, 25000, 10000}
+	for i, night := range breakdown.Nights {
+		if night.Price != expected[i] {
+			t.Errorf("night %s: expected %d, got %d", night.Date.Format("2006-01-02"), expected[i], night.Price)
+		}
+	}
+	if breakdown.Total != 57000 {
+		t.Errorf("expected total of 57000, got %d", breakdown.Total)
+	}
+	if breakdown.Nights[2].Rule != "Holiday" {
+		t.Errorf("expected the holiday rule to win, got %s", breakdown.Nights[2].Rule)
+	}
+}
+
+func TestCalculate_RoomRuleBeatsGlobalOnTie(t *testing.T) {
+	room := models.Room{ID: 1, NightlyRate: 10000}
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	rules := []models.RateRule{
+		{ID: 2, Name: "Global", StartDate: start, EndDate: start, Weekdays: models.AllWeekdays,
+			AmountType: models.RateRuleFixed, Amount: 5000},
+		{ID: 1, Name: "Room", RoomID: 1, StartDate: start, EndDate: start, Weekdays: models.AllWeekdays,
+			AmountType: models.RateRuleFixed, Amount: 7000},
+	}
+
+	breakdown := Calculate(room, start, start.AddDate(0, 0, 1), rules)
+	if breakdown.Total != 7000 {
+		t.Errorf("expected the room rule to win, got %d", breakdown.Total)
+	}
+}
+
+func TestCancellationPenalty(t *testing.T) {
+	arrival := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	firstNight := models.CancellationPolicy{ID: 1, FreeDays: 7, PenaltyType: models.CancellationPenaltyFirstNight}
+	percent := models.CancellationPolicy{ID: 2, FreeDays: 3, PenaltyType: models.CancellationPenaltyPercent, PenaltyPercent: 50}
+
+	var tests = []struct {
+		name     string
+		policy   models.CancellationPolicy
+		now      time.Time
+		expected int
+	}{
+		{"no policy", models.CancellationPolicy{}, arrival, 0},
+		{"before deadline", firstNight, time.Date(2050, 1, 2, 23, 0, 0, 0, time.UTC), 0},
+		{"on deadline", firstNight, time.Date(2050, 1, 3, 8, 0, 0, 0, time.UTC), 10000},
+		{"percent after deadline", percent, time.Date(2050, 1, 9, 0, 0, 0, 0, time.UTC), 15000},
+		{"percent before deadline", percent, time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC), 0},
+	}
+
+	for _, e := range tests {
+		penalty := CancellationPenalty(e.policy, arrival, 30000, 10000, e.now)
+		if penalty != e.expected {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expected, penalty)
+		}
+	}
+}
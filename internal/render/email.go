@@ -0,0 +1,231 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/zahnah/study-app/internal/models"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// CreateEmailTemplateCache parses the emails of dir. The wording of every email is in three files:
+// name.subject.txt, the HTML content name.content.html.gohtml and its plain text version
+// name.content.txt.gohtml. The contents are put in the layouts of their kind, *.layout.html.gohtml
+// and *.layout.txt.gohtml.
+func CreateEmailTemplateCache(dir string) (map[string]*template.Template, map[string]*texttemplate.Template, error) {
+	htmlCache := map[string]*template.Template{}
+	textCache := map[string]*texttemplate.Template{}
+
+	subjects, err := filepath.Glob(filepath.Join(dir, "*.subject.txt"))
+	if err != nil {
+		return htmlCache, textCache, err
+	}
+
+	for _, subject := range subjects {
+		name := strings.TrimSuffix(filepath.Base(subject), ".subject.txt")
+
+		email, err := emailFiles(dir, name)
+		if err != nil {
+			return htmlCache, textCache, err
+		}
+		ts, text, err := parseEmailTemplate(email, dir)
+		if err != nil {
+			return htmlCache, textCache, fmt.Errorf("the email %s: %w", name, err)
+		}
+
+		htmlCache[name] = ts
+		textCache[name] = text
+	}
+
+	return htmlCache, textCache, nil
+}
+
+// emailFiles reads the wording of the email called name from the files of dir
+func emailFiles(dir, name string) (models.EmailTemplate, error) {
+	email := models.EmailTemplate{Name: name}
+
+	for _, part := range []struct {
+		file   string
+		source *string
+	}{
+		{name + ".subject.txt", &email.Subject},
+		{name + ".content.html.gohtml", &email.HTML},
+		{name + ".content.txt.gohtml", &email.Text},
+	} {
+		source, err := os.ReadFile(filepath.Join(dir, part.file))
+		if err != nil {
+			return email, err
+		}
+		*part.source = string(source)
+	}
+
+	return email, nil
+}
+
+func parseLayouts(ts *template.Template, dir string) (*template.Template, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.layout.html.gohtml"))
+	if err != nil || len(matches) == 0 {
+		return ts, err
+	}
+	return ts.ParseFiles(matches...)
+}
+
+func parseTextLayouts(ts *texttemplate.Template, dir string) (*texttemplate.Template, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.layout.txt.gohtml"))
+	if err != nil || len(matches) == 0 {
+		return ts, err
+	}
+	return ts.ParseFiles(matches...)
+}
+
+// Email renders the email called name, e.g. "reservation-confirmation", into its subject, HTML body and
+// plain text alternative. The caller addresses it.
+func Email(name string, data *models.EmailData) (models.MailData, error) {
+	htmlCache, textCache, err := emailTemplateCaches()
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	ts, ok := htmlCache[name]
+	text, textOK := textCache[name]
+	if !ok || !textOK {
+		return models.MailData{}, fmt.Errorf("no email template %q", name)
+	}
+
+	return executeEmail(ts, text, data)
+}
+
+// emailTemplateCaches returns the parsed emails of the app, or parses them again when the cache is off
+func emailTemplateCaches() (map[string]*template.Template, map[string]*texttemplate.Template, error) {
+	if app.UseCache {
+		return app.EmailTemplateCache, app.EmailTextTemplateCache, nil
+	}
+	return CreateEmailTemplateCache(app.EmailTemplateDir)
+}
+
+// EmailNames returns the names of the emails, in order
+func EmailNames() ([]string, error) {
+	htmlCache, _, err := emailTemplateCaches()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(htmlCache))
+	for name := range htmlCache {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// EmailTemplateError is a part of an email, "subject", "html" or "text", that can't be parsed or rendered
+type EmailTemplateError struct {
+	Part string
+	Err  error
+}
+
+func (e *EmailTemplateError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Part, e.Err)
+}
+
+func (e *EmailTemplateError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultEmailTemplate returns the wording of the email called name as it is in its files
+func DefaultEmailTemplate(name string) (models.EmailTemplate, error) {
+	return emailFiles(app.EmailTemplateDir, name)
+}
+
+// ParseEmailTemplate parses the wording of an email with the layouts, like CreateEmailTemplateCache
+// does with the files. A part that can't be parsed is an *EmailTemplateError.
+func ParseEmailTemplate(email models.EmailTemplate) (*template.Template, *texttemplate.Template, error) {
+	return parseEmailTemplate(email, app.EmailTemplateDir)
+}
+
+// parseEmailTemplate parses the wording of an email with the layouts of dir
+func parseEmailTemplate(email models.EmailTemplate, dir string) (*template.Template, *texttemplate.Template, error) {
+	ts := template.Must(template.New(email.Name).Funcs(functions).Parse(`{{template "basic" .}}`))
+	_, err := ts.New("content").Parse(email.HTML)
+	if err != nil {
+		return nil, nil, &EmailTemplateError{Part: "html", Err: err}
+	}
+	ts, err = parseLayouts(ts, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	text := texttemplate.Must(texttemplate.New(email.Name).Funcs(texttemplate.FuncMap(functions)).Parse(`{{template "basic" .}}`))
+	_, err = text.New("subject").Parse(email.Subject)
+	if err != nil {
+		return nil, nil, &EmailTemplateError{Part: "subject", Err: err}
+	}
+	// the text layout expects the content to end its last line
+	_, err = text.New("content").Parse(strings.TrimSpace(email.Text) + "\n")
+	if err != nil {
+		return nil, nil, &EmailTemplateError{Part: "text", Err: err}
+	}
+	text, err = parseTextLayouts(text, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ts, text, nil
+}
+
+// EmailFromTemplate renders an email with the wording of email instead of its files
+func EmailFromTemplate(email models.EmailTemplate, data *models.EmailData) (models.MailData, error) {
+	ts, text, err := ParseEmailTemplate(email)
+	if err != nil {
+		return models.MailData{}, err
+	}
+	return executeEmail(ts, text, data)
+}
+
+// EmailInLayout puts content, an HTML body written without the layout, in the HTML layout of the emails.
+// The content is used as it is.
+func EmailInLayout(content string, data *models.EmailData) (string, error) {
+	ts := template.Must(template.New("wrap").Funcs(functions).Funcs(template.FuncMap{
+		"wrappedContent": func() template.HTML { return template.HTML(content) },
+	}).Parse(`{{template "basic" .}}{{define "content"}}{{wrappedContent}}{{end}}`))
+	ts, err := parseLayouts(ts, app.EmailTemplateDir)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = ts.Execute(&buf, data)
+	return buf.String(), err
+}
+
+// executeEmail renders an email from its parsed templates. A part that fails is an *EmailTemplateError.
+func executeEmail(ts *template.Template, text *texttemplate.Template, data *models.EmailData) (models.MailData, error) {
+	var mail models.MailData
+
+	var buf bytes.Buffer
+	err := text.ExecuteTemplate(&buf, "subject", data)
+	if err != nil {
+		return mail, &EmailTemplateError{Part: "subject", Err: err}
+	}
+	mail.Subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	err = text.Execute(&buf, data)
+	if err != nil {
+		return mail, &EmailTemplateError{Part: "text", Err: err}
+	}
+	mail.Text = strings.TrimSpace(buf.String()) + "\n"
+
+	buf.Reset()
+	err = ts.Execute(&buf, data)
+	if err != nil {
+		return mail, &EmailTemplateError{Part: "html", Err: err}
+	}
+	mail.Content = buf.String()
+
+	return mail, nil
+}
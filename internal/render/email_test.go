@@ -0,0 +1,242 @@
+package render
+
+import (
+	"errors"
+	"github.com/zahnah/study-app/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEmailData() *models.EmailData {
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	reservation := models.Reservation{
+		ID:               1,
+		FirstName:        "John",
+		LastName:         "Smith",
+		Email:            "john@smith.com",
+		StartDate:        start,
+		EndDate:          start.AddDate(0, 0, 2),
+		RoomID:           1,
+		TotalPrice:       12005,
+		ConfirmationCode: "ABC123",
+		Room:             models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+
+	return &models.EmailData{
+		Reservation:     reservation,
+		Stay:            models.ReservationRoom{RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 2), Room: reservation.Room},
+		PreviousStay:    models.ReservationRoom{RoomID: 1, StartDate: start.AddDate(0, 0, -3), EndDate: start.AddDate(0, 0, -1), Room: reservation.Room},
+		CancellationFee: 2500,
+		PropertyName:    "Fort Smythe Bed and Breakfast",
+		PropertyAddress: "1 Fort Road, Smythe",
+		ManageURL:       "http://localhost:8080/my-reservation",
+		AdminURL:        "http://localhost:8080/admin/reservations/all/1",
+	}
+}
+
+func TestEmail(t *testing.T) {
+	app.EmailTemplateDir = "./../../email-templates"
+	htmlCache, textCache, err := CreateEmailTemplateCache(app.EmailTemplateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.EmailTemplateCache = htmlCache
+	app.EmailTextTemplateCache = textCache
+
+	tests := []struct {
+		name            string
+		expectedSubject string
+		expected        []string
+	}{
+		{"reservation-confirmation", "Reservation Confirmation", []string{"Dear John", "ABC123", "$120.05", "http://localhost:8080/my-reservation"}},
+		{"reservation-new", "Reservation Confirmation", []string{"John Smith", "General's Quarters", "http://localhost:8080/admin/reservations/all/1"}},
+		{"reservation-changed", "Reservation changed", []string{"Dear John", "2049-12-29 - 2049-12-31", "2050-01-01 - 2050-01-03"}},
+		{"reservation-changed-owner", "Reservation changed", []string{"Reservation #1", "2050-01-01 - 2050-01-03"}},
+		{"reservation-cancelled", "Reservation cancelled", []string{"Dear John", "Cancellation fee: $25.00"}},
+		{"reservation-cancelled-owner", "Reservation cancelled", []string{"Reservation #1", "Cancellation fee: $25.00"}},
+	}
+
+	for _, e := range tests {
+		mail, err := Email(e.name, testEmailData())
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+
+		if mail.Subject != e.expectedSubject {
+			t.Errorf("%s: expected the subject %q, got %q", e.name, e.expectedSubject, mail.Subject)
+		}
+		if !strings.Contains(mail.Content, "<html") || !strings.Contains(mail.Content, "Fort Smythe Bed and Breakfast") {
+			t.Errorf("%s: the HTML body isn't in the layout:\n%s", e.name, mail.Content)
+		}
+		if strings.Contains(mail.Text, "<") || !strings.HasSuffix(mail.Text, "1 Fort Road, Smythe\n") {
+			t.Errorf("%s: unexpected plain text body:\n%s", e.name, mail.Text)
+		}
+		for _, expected := range e.expected {
+			if !strings.Contains(mail.Text, expected) {
+				t.Errorf("%s: the plain text body doesn't contain %q:\n%s", e.name, expected, mail.Text)
+			}
+			if !strings.Contains(mail.Content, strings.ReplaceAll(expected, "'", "&#39;")) {
+				t.Errorf("%s: the HTML body doesn't contain %q", e.name, expected)
+			}
+		}
+	}
+
+	_, err = Email("not-existent", testEmailData())
+	if err == nil {
+		t.Error("rendered an email that doesn't exist")
+	}
+}
+
+func TestEmail_Escaping(t *testing.T) {
+	app.EmailTemplateDir = "./../../email-templates"
+	app.UseCache = false
+
+	data := testEmailData()
+	data.Reservation.FirstName = "<script>John</script>"
+	mail, err := Email("reservation-confirmation", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(mail.Content, "<script>") {
+		t.Error("the guest's name isn't escaped in the HTML body")
+	}
+	if !strings.Contains(mail.Text, "Dear <script>John</script>") {
+		t.Error("the plain text body shouldn't be escaped")
+	}
+}
+
+func TestCreateEmailTemplateCache(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("basic.layout.html.gohtml", `{{define "basic"}}<p>{{block "content" .}}{{end}}</p>{{end}}`)
+	write("basic.layout.txt.gohtml", `{{define "basic"}}{{block "content" .}}{{end}}{{end}}`)
+	write("hello.subject.txt", "Hi")
+	write("hello.content.html.gohtml", "Hello")
+
+	_, _, err := CreateEmailTemplateCache(dir)
+	if err == nil {
+		t.Error("expected an email without a plain text version to be refused")
+	}
+
+	write("hello.content.txt.gohtml", "Hello")
+	htmlCache, textCache, err := CreateEmailTemplateCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(htmlCache) != 1 || len(textCache) != 1 {
+		t.Errorf("expected only the hello email to be cached, got %d and %d", len(htmlCache), len(textCache))
+	}
+
+	write("broken.subject.txt", "Hi")
+	write("broken.content.html.gohtml", "{{.Missing")
+	write("broken.content.txt.gohtml", "Hello")
+	_, _, err = CreateEmailTemplateCache(dir)
+	var templateErr *EmailTemplateError
+	if !errors.As(err, &templateErr) || templateErr.Part != "html" {
+		t.Errorf("expected a broken template to be refused, got %v", err)
+	}
+}
+
+func TestEmailFromTemplate(t *testing.T) {
+	app.EmailTemplateDir = "./../../email-templates"
+	app.UseCache = false
+
+	names, err := EmailNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 6 || names[0] != "reservation-cancelled" {
+		t.Errorf("unexpected emails %v", names)
+	}
+
+	// the default wording is the files as they are, and renders the same email
+	for _, name := range names {
+		email, err := DefaultEmailTemplate(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		onDisk, _ := os.ReadFile(filepath.Join(app.EmailTemplateDir, name+".content.html.gohtml"))
+		if email.HTML != string(onDisk) {
+			t.Errorf("%s: the default HTML isn't the file:\n%s", name, email.HTML)
+		}
+		fromFiles, _ := Email(name, testEmailData())
+		fromTemplate, err := EmailFromTemplate(email, testEmailData())
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if fromTemplate.Subject != fromFiles.Subject || fromTemplate.Text != fromFiles.Text || fromTemplate.Content != fromFiles.Content {
+			t.Errorf("%s: the default wording renders %q:\n%s", name, fromTemplate.Subject, fromTemplate.Text)
+		}
+	}
+
+	email := models.EmailTemplate{
+		Name:    "reservation-confirmation",
+		Subject: "See you soon, {{.Reservation.FirstName}}",
+		HTML:    "<p>Your code is {{.Reservation.ConfirmationCode}}</p>",
+		Text:    "Your code is {{.Reservation.ConfirmationCode}}",
+	}
+	mail, err := EmailFromTemplate(email, testEmailData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mail.Subject != "See you soon, John" || !strings.Contains(mail.Content, "<p>Your code is ABC123</p>") ||
+		!strings.HasPrefix(mail.Text, "Your code is ABC123\n\n--\n") {
+		t.Errorf("unexpected email %q:\n%s\n%s", mail.Subject, mail.Text, mail.Content)
+	}
+
+	tests := []struct {
+		name         string
+		subject      string
+		html         string
+		text         string
+		expectedPart string
+	}{
+		{"subject doesn't parse", "{{.Reservation.FirstName", email.HTML, email.Text, "subject"},
+		{"html doesn't parse", email.Subject, "{{if .Reservation.ID}}", email.Text, "html"},
+		{"text doesn't parse", email.Subject, email.HTML, "{{money}", "text"},
+		{"unknown function", email.Subject, email.HTML, "{{price .Reservation}}", "text"},
+		{"unknown field", email.Subject, "{{.Reservation.Missing}}", email.Text, "html"},
+		{"unsafe html", email.Subject, `<a href="{{.ManageURL}}>`, email.Text, "html"},
+	}
+
+	for _, e := range tests {
+		_, err := EmailFromTemplate(models.EmailTemplate{Name: email.Name, Subject: e.subject, HTML: e.html, Text: e.text}, testEmailData())
+		var templateErr *EmailTemplateError
+		if !errors.As(err, &templateErr) {
+			t.Errorf("%s: expected a template error, got %v", e.name, err)
+		} else if templateErr.Part != e.expectedPart {
+			t.Errorf("%s: expected the %s to fail, got %v", e.name, e.expectedPart, err)
+		}
+	}
+
+	_, err = DefaultEmailTemplate("not-existent")
+	if err == nil {
+		t.Error("found the default wording of an email that doesn't exist")
+	}
+}
+
+func TestEmailInLayout(t *testing.T) {
+	app.EmailTemplateDir = "./../../email-templates"
+
+	content, err := EmailInLayout(`<p>Reservation <strong>ABC123</strong> {{.Missing}}</p>`, testEmailData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content, "<html") || !strings.Contains(content, "Fort Smythe Bed and Breakfast") {
+		t.Errorf("the content isn't in the layout:\n%s", content)
+	}
+	if !strings.Contains(content, `<p>Reservation <strong>ABC123</strong> {{.Missing}}</p>`) {
+		t.Errorf("the content has to be kept as it is:\n%s", content)
+	}
+}
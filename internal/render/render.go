@@ -19,6 +19,7 @@ var functions = template.FuncMap{
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
 	"add":        Add,
+	"money":      Money,
 }
 
 var app *config.AppConfig
@@ -40,6 +41,11 @@ func Add(a, b int) int {
 	return a + b
 }
 
+// Money formats an amount in cents for display
+func Money(cents int) string {
+	return fmt.Sprintf("$%d.%02d", cents/100, cents%100)
+}
+
 func Iterate(count int) []int {
 	var i int
 	var items []int
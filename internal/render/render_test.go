@@ -50,6 +50,12 @@ func TestTemplate(t *testing.T) {
 
 }
 
+func TestMoney(t *testing.T) {
+	if m := Money(12005); m != "$120.05" {
+		t.Errorf("expected $120.05, got %s", m)
+	}
+}
+
 func TestNewTemplates(t *testing.T) {
 	NewRenderer(app)
 }
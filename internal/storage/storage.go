@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidKey is returned for keys that would escape the storage's directory
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage keeps uploaded files and tells where they can be downloaded from
+type Storage interface {
+	// Save stores the content under key, e.g. "rooms/1/photo.jpg", and returns its public URL
+	Save(key string, content io.Reader) (string, error)
+
+	// Delete removes the file behind a URL returned by Save. URLs the storage didn't hand out are ignored.
+	Delete(url string) error
+}
+
+// LocalDisk stores files in a directory that is served under URLPrefix
+type LocalDisk struct {
+	Dir       string
+	URLPrefix string
+}
+
+// NewLocalDisk returns a storage writing to dir, whose files are served at urlPrefix
+func NewLocalDisk(dir, urlPrefix string) *LocalDisk {
+	return &LocalDisk{
+		Dir:       dir,
+		URLPrefix: strings.TrimSuffix(urlPrefix, "/"),
+	}
+}
+
+func (s *LocalDisk) Save(key string, content io.Reader) (string, error) {
+	filename, err := s.filename(key)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(filename)
+		return "", err
+	}
+
+	return s.URLPrefix + "/" + path.Clean(key), nil
+}
+
+func (s *LocalDisk) Delete(url string) error {
+	if !strings.HasPrefix(url, s.URLPrefix+"/") {
+		return nil
+	}
+
+	filename, err := s.filename(strings.TrimPrefix(url, s.URLPrefix+"/"))
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// filename maps a key to its file inside Dir
+func (s *LocalDisk) filename(key string) (string, error) {
+	key = path.Clean("/" + key)
+	if key == "/" {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalDisk_Save(t *testing.T) {
+	dir := t.TempDir()
+	disk := NewLocalDisk(dir, "/static/uploads/")
+
+	url, err := disk.Save("rooms/1/photo.jpg", strings.NewReader("image"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "/static/uploads/rooms/1/photo.jpg" {
+		t.Errorf("wrong url, got %s", url)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "rooms", "1", "photo.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "image" {
+		t.Errorf("wrong content, got %s", content)
+	}
+}
+
+func TestLocalDisk_SaveOutsideDir(t *testing.T) {
+	dir := t.TempDir()
+	disk := NewLocalDisk(filepath.Join(dir, "uploads"), "/static/uploads")
+
+	_, err := disk.Save("../../escaped.jpg", strings.NewReader("image"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.jpg")); err == nil {
+		t.Error("the file has to stay inside the storage directory")
+	}
+
+	if _, err := disk.Save("..", strings.NewReader("image")); err != ErrInvalidKey {
+		t.Errorf("expected ErrInvalidKey, got %v", err)
+	}
+}
+
+func TestLocalDisk_Delete(t *testing.T) {
+	dir := t.TempDir()
+	disk := NewLocalDisk(dir, "/static/uploads")
+
+	url, err := disk.Save("rooms/1/photo.jpg", strings.NewReader("image"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = disk.Delete(url); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rooms", "1", "photo.jpg")); !os.IsNotExist(err) {
+		t.Error("the file has to be removed")
+	}
+
+	if err = disk.Delete(url); err != nil {
+		t.Errorf("deleting a missing file shouldn't fail, got %v", err)
+	}
+	if err = disk.Delete("/static/room-images/generals-quarters.png"); err != nil {
+		t.Errorf("foreign urls have to be ignored, got %v", err)
+	}
+}
@@ -0,0 +1 @@
+delete from public.room_rates;
\ No newline at end of file
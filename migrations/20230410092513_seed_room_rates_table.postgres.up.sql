@@ -0,0 +1,2 @@
+INSERT INTO public.room_rates (room_id, nightly_rate, created_at, updated_at) VALUES (1, 12000, '2023-04-10 09:25:13.000000', '2023-04-10 09:25:13.000000');
+INSERT INTO public.room_rates (room_id, nightly_rate, created_at, updated_at) VALUES (2, 9500, '2023-04-10 09:25:13.000000', '2023-04-10 09:25:13.000000');
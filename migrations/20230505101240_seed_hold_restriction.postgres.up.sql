@@ -0,0 +1 @@
+INSERT INTO public.restrictions (id, restriction_name, created_at, updated_at) VALUES (3, 'Hold', '2023-05-05 10:12:40.000000', '2023-05-05 10:12:40.000000');
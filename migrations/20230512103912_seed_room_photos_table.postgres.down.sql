@@ -0,0 +1 @@
+delete from public.room_photos where path in ('/static/room-images/generals-quarters.png', '/static/room-images/marjors-suite.png');
\ No newline at end of file
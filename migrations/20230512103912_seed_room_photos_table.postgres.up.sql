@@ -0,0 +1,2 @@
+INSERT INTO public.room_photos (room_id, path, sort_order, created_at, updated_at) VALUES (1, '/static/room-images/generals-quarters.png', 1, '2023-05-12 10:39:12.000000', '2023-05-12 10:39:12.000000');
+INSERT INTO public.room_photos (room_id, path, sort_order, created_at, updated_at) VALUES (2, '/static/room-images/marjors-suite.png', 1, '2023-05-12 10:39:12.000000', '2023-05-12 10:39:12.000000');
\ No newline at end of file
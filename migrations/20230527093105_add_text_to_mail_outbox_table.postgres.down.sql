@@ -0,0 +1,6 @@
+-- going back loses the plain text alternatives and keeps the layout the emails were wrapped in, only the
+-- emails that haven't been wrapped yet get their template back
+alter table mail_outbox add column template varchar(255) not null default '';
+update mail_outbox set template = 'basic' where wrap_in_layout;
+alter table mail_outbox drop column wrap_in_layout;
+alter table mail_outbox drop column text;
\ No newline at end of file
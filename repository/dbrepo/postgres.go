@@ -3,14 +3,22 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/zahnah/study-app/internal/config"
 	"github.com/zahnah/study-app/internal/models"
+	"github.com/zahnah/study-app/repository"
 	"golang.org/x/crypto/bcrypt"
 	"log"
+	"strings"
 	"time"
 )
 
+// exclusionViolationCode is the SQLSTATE postgres reports when an exclusion constraint fails
+const exclusionViolationCode = "23P01"
+
 type postgresDbRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
@@ -26,7 +34,7 @@ insert into room_restrictions (restriction_id, reservation_id, room_id,
                                created_at, updated_at)
 values ($1, $2, $3, $4, $5, $6, $7)`
 	_, err := m.DB.ExecContext(ctx, stmt,
-		2,
+		models.RestrictionOwnerBlock,
 		nil,
 		id,
 		startDate,
@@ -47,6 +55,331 @@ func (m *postgresDbRepo) DeleteRoomRestriction(id int) error {
 	return err
 }
 
+// InsertOwnerBlock stores an owner block and every restriction it expands to in a single transaction.
+// When one of its nights is already restricted nothing is blocked.
+func (m *postgresDbRepo) InsertOwnerBlock(b models.OwnerBlock) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	stmt := `
+insert into owner_blocks (room_id, note, recurrence, weekdays, start_date, end_date, years,
+                          created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		b.RoomID,
+		b.Note,
+		b.Recurrence,
+		b.Weekdays,
+		b.StartDate,
+		b.EndDate,
+		b.Years,
+		time.Now(),
+		time.Now(),
+	).Scan(&b.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = deleteExpiredHolds(ctx, tx, b.RoomID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `
+insert into room_restrictions (restriction_id, room_id, owner_block_id,
+                               start_date, end_date,
+                               created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7)`
+	for _, r := range b.Restrictions() {
+		_, err = tx.ExecContext(ctx, stmt,
+			r.RestrictionID,
+			r.RoomID,
+			r.OwnerBlockID,
+			r.StartDate,
+			r.EndDate,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			if isExclusionViolation(err) {
+				return 0, repository.ErrRoomNotAvailable
+			}
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return b.ID, nil
+}
+
+// AllOwnerBlocks returns the owner blocks that still block a night from today on, by start date
+func (m *postgresDbRepo) AllOwnerBlocks() ([]models.OwnerBlock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var blocks []models.OwnerBlock
+
+	stmt := `
+select ob.id, ob.room_id, ob.note, ob.recurrence, ob.weekdays,
+       ob.start_date, ob.end_date, ob.years,
+       ob.created_at, ob.updated_at,
+       r.id, r.room_name
+from owner_blocks ob
+join rooms r on r.id = ob.room_id
+where exists (
+	select 1 from room_restrictions rr where rr.owner_block_id = ob.id and rr.end_date > $1
+)
+order by ob.start_date, r.sort_order
+`
+	rows, err := m.DB.QueryContext(ctx, stmt, time.Now())
+	if err != nil {
+		return blocks, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var b models.OwnerBlock
+		err := rows.Scan(
+			&b.ID, &b.RoomID, &b.Note, &b.Recurrence, &b.Weekdays,
+			&b.StartDate, &b.EndDate, &b.Years,
+			&b.CreatedAt, &b.UpdatedAt,
+			&b.Room.ID, &b.Room.RoomName,
+		)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, b)
+	}
+
+	return blocks, rows.Err()
+}
+
+// DeleteOwnerBlock removes an owner block with all of its restrictions
+func (m *postgresDbRepo) DeleteOwnerBlock(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from owner_blocks where id = $1`, id)
+	return err
+}
+
+// DeleteBlocksByDate frees the nights from start to end (inclusive) of every owner block of the room, or of
+// every room when roomID is 0. Blocks reaching outside the dates are cut back rather than removed. It returns
+// the number of blocks that changed and the number of blocks of imported calendars that were left alone.
+func (m *postgresDbRepo) DeleteBlocksByDate(roomID int, start, end time.Time) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	freed, imported, err := freeBlockedNights(ctx, tx, roomID, start, end)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	return freed, imported, nil
+}
+
+// UpdateCalendarBlocks blocks and frees single nights of a room in one transaction, provided the room's
+// restrictions from change.Start to change.End still have change.Version. Otherwise nothing changes and
+// ErrCalendarChanged is returned, so that two edits of the same calendar don't undo each other. Freeing a
+// night of an imported calendar is refused with ErrImportedBlock.
+func (m *postgresDbRepo) UpdateCalendarBlocks(change models.CalendarChange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	// edits of the same room wait for each other, so only the first one sees the version it was made on
+	_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, change.RoomID)
+	if err != nil {
+		return err
+	}
+
+	var restrictions []models.RoomRestriction
+	stmt := `
+select id, restriction_id, coalesce(reservation_id, 0), start_date, end_date, updated_at
+from room_restrictions
+where room_id = $1 and end_date >= $2 and start_date <= $3`
+	rows, err := tx.QueryContext(ctx, stmt, change.RoomID, change.Start, change.End)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var r models.RoomRestriction
+		err = rows.Scan(&r.ID, &r.RestrictionID, &r.ReservationID, &r.StartDate, &r.EndDate, &r.UpdatedAt)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		restrictions = append(restrictions, r)
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if models.RestrictionsVersion(restrictions) != change.Version {
+		return repository.ErrCalendarChanged
+	}
+
+	for _, night := range change.Remove {
+		_, imported, err := freeBlockedNights(ctx, tx, change.RoomID, night, night)
+		if err != nil {
+			return err
+		}
+		if imported > 0 {
+			return repository.ErrImportedBlock
+		}
+	}
+
+	if len(change.Add) > 0 {
+		err = deleteExpiredHolds(ctx, tx, change.RoomID)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt = `
+insert into room_restrictions (restriction_id, room_id,
+                               start_date, end_date,
+                               created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6)`
+	for _, night := range change.Add {
+		_, err = tx.ExecContext(ctx, stmt,
+			models.RestrictionOwnerBlock,
+			change.RoomID,
+			night,
+			night.AddDate(0, 0, 1),
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			if isExclusionViolation(err) {
+				return repository.ErrRoomNotAvailable
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// freeBlockedNights frees the nights from start to end (inclusive) of the owner blocks of the room, or of every
+// room when roomID is 0, cutting back the blocks reaching outside the dates. The blocks of imported calendars
+// follow their feed, the next sync would block their nights again, so they are left alone. It returns the
+// number of blocks changed and the number of imported blocks left.
+func freeBlockedNights(ctx context.Context, tx *sql.Tx, roomID int, start, end time.Time) (int, int, error) {
+	// the first night that stays blocked after the freed nights
+	after := end.AddDate(0, 0, 1)
+
+	var blocks []models.RoomRestriction
+	stmt := `
+select id, room_id, coalesce(owner_block_id, 0), coalesce(ical_import_id, 0), start_date, end_date
+from room_restrictions
+where restriction_id = $1
+  and ($2 = 0 or room_id = $2)
+  and start_date < $4 and end_date > $3
+for update`
+	rows, err := tx.QueryContext(ctx, stmt, models.RestrictionOwnerBlock, roomID, start, after)
+	if err != nil {
+		return 0, 0, err
+	}
+	imported := 0
+	for rows.Next() {
+		var r models.RoomRestriction
+		err = rows.Scan(&r.ID, &r.RoomID, &r.OwnerBlockID, &r.ICalImportID, &r.StartDate, &r.EndDate)
+		if err != nil {
+			_ = rows.Close()
+			return 0, 0, err
+		}
+		if r.ICalImportID != 0 {
+			imported++
+			continue
+		}
+		blocks = append(blocks, r)
+	}
+	if err = rows.Close(); err != nil {
+		return 0, 0, err
+	}
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, r := range blocks {
+		switch {
+		case r.StartDate.Before(start) && r.EndDate.After(after):
+			// the freed nights are in the middle, the block is split in two
+			_, err = tx.ExecContext(ctx, `update room_restrictions set end_date = $2, updated_at = $3 where id = $1`,
+				r.ID, start, time.Now())
+			if err != nil {
+				return 0, 0, err
+			}
+			stmt = `
+insert into room_restrictions (restriction_id, room_id, owner_block_id,
+                               start_date, end_date,
+                               created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7)`
+			_, err = tx.ExecContext(ctx, stmt,
+				models.RestrictionOwnerBlock,
+				r.RoomID,
+				nullableID(r.OwnerBlockID),
+				after,
+				r.EndDate,
+				time.Now(),
+				time.Now(),
+			)
+		case r.StartDate.Before(start):
+			_, err = tx.ExecContext(ctx, `update room_restrictions set end_date = $2, updated_at = $3 where id = $1`,
+				r.ID, start, time.Now())
+		case r.EndDate.After(after):
+			_, err = tx.ExecContext(ctx, `update room_restrictions set start_date = $2, updated_at = $3 where id = $1`,
+				r.ID, after, time.Now())
+		default:
+			_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1`, r.ID)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return len(blocks), imported, nil
+}
+
 func (m *postgresDbRepo) GetRestrictionsForRoomByDate(roomID int, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -56,9 +389,12 @@ func (m *postgresDbRepo) GetRestrictionsForRoomByDate(roomID int, startDate, end
 	stmt := `
 select rr.id, rr.room_id,
        coalesce(rr.reservation_id, 0), rr.restriction_id,
+       coalesce(rr.owner_block_id, 0), coalesce(ob.note, ii.name, ''),
        rr.start_date, rr.end_date,
        rr.created_at, rr.updated_at
 from room_restrictions rr
+left join owner_blocks ob on ob.id = rr.owner_block_id
+left join ical_imports ii on ii.id = rr.ical_import_id
 where rr.room_id = $1 and rr.end_date >= $2 and rr.start_date <= $3
 `
 	rows, err := m.DB.QueryContext(ctx, stmt, roomID, startDate, endDate)
@@ -78,6 +414,7 @@ where rr.room_id = $1 and rr.end_date >= $2 and rr.start_date <= $3
 		err := rows.Scan(
 			&r.ID, &r.RoomID,
 			&r.ReservationID, &r.RestrictionID,
+			&r.OwnerBlockID, &r.Note,
 			&r.StartDate, &r.EndDate,
 			&r.CreatedAt, &r.UpdatedAt,
 		)
@@ -102,9 +439,14 @@ func (m *postgresDbRepo) AllRooms() ([]models.Room, error) {
 	var rooms []models.Room
 
 	stmt := `
-select r.id, r.room_name,
+select r.id, r.room_name, r.description, r.amenities, coalesce(rt.nightly_rate, 0),
+       coalesce(r.cancellation_policy_id, 0), r.max_adults, r.max_children,
+       r.active, r.sort_order, coalesce(r.room_type_id, 0), coalesce(t.name, ''),
        r.created_at, r.updated_at
 from rooms r
+left join room_rates rt on rt.room_id = r.id
+left join room_types t on t.id = r.room_type_id
+order by r.sort_order, r.id
 `
 	rows, err := m.DB.QueryContext(ctx, stmt)
 	defer func(rows *sql.Rows) {
@@ -121,9 +463,12 @@ from rooms r
 	for rows.Next() {
 		var r models.Room
 		err := rows.Scan(
-			&r.ID, &r.RoomName,
+			&r.ID, &r.RoomName, &r.Description, &r.Amenities, &r.NightlyRate,
+			&r.CancellationPolicyID, &r.MaxAdults, &r.MaxChildren,
+			&r.Active, &r.SortOrder, &r.RoomTypeID, &r.RoomType.Name,
 			&r.CreatedAt, &r.UpdatedAt,
 		)
+		r.RoomType.ID = r.RoomTypeID
 
 		if err != nil {
 			return rooms, err
@@ -138,22 +483,112 @@ from rooms r
 	return rooms, nil
 }
 
-func (m *postgresDbRepo) UpdateProcessedForReservations(id, processed int) error {
+// UpdateReservationStatus moves a reservation to status, refusing transitions the lifecycle doesn't allow.
+// userID is the admin making the change, 0 for the guest.
+func (m *postgresDbRepo) UpdateReservationStatus(id int, status models.ReservationStatus, userID int, note string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `update reservations set processed = $2 where id = $1`
-	_, err := m.DB.ExecContext(ctx, stmt, id, processed)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	from, err := lockReservationStatus(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if !from.CanTransitionTo(status) {
+		return repository.ErrInvalidStatusTransition
+	}
+
+	stmt := `update reservations set status = $2, updated_at = $3 where id = $1`
+	_, err = tx.ExecContext(ctx, stmt, id, string(status), time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = insertStatusChange(ctx, tx, id, from, status, userID, note)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockReservationStatus reads the reservation's status and locks the row until the transaction ends
+func lockReservationStatus(ctx context.Context, tx *sql.Tx, id int) (models.ReservationStatus, error) {
+	var status models.ReservationStatus
+	err := tx.QueryRowContext(ctx, `select status from reservations where id = $1 for update`, id).Scan(&status)
+	return status, err
+}
+
+// insertStatusChange adds a row to the reservation's status history
+func insertStatusChange(ctx context.Context, tx *sql.Tx, id int, from, to models.ReservationStatus, userID int, note string) error {
+	stmt := `
+insert into reservation_status_history (reservation_id, from_status, to_status, user_id, note,
+                                        created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := tx.ExecContext(ctx, stmt,
+		id,
+		string(from),
+		string(to),
+		nullableID(userID),
+		note,
+		time.Now(),
+		time.Now(),
+	)
 	return err
 }
 
-func (m *postgresDbRepo) DeleteReservation(id int) error {
+// GetReservationStatusHistory returns the status changes of a reservation, oldest first
+func (m *postgresDbRepo) GetReservationStatusHistory(reservationID int) ([]models.ReservationStatusChange, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `delete from reservations where id = $1`
-	_, err := m.DB.ExecContext(ctx, stmt, id)
-	return err
+	var changes []models.ReservationStatusChange
+
+	stmt := `
+select h.id, h.reservation_id, h.from_status, h.to_status, coalesce(h.user_id, 0), h.note, h.created_at,
+       coalesce(u.first_name, ''), coalesce(u.last_name, '')
+from reservation_status_history h
+left join users u on u.id = h.user_id
+where h.reservation_id = $1
+order by h.created_at, h.id
+`
+	rows, err := m.DB.QueryContext(ctx, stmt, reservationID)
+	if err != nil {
+		return changes, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var c models.ReservationStatusChange
+		err := rows.Scan(
+			&c.ID, &c.ReservationID, &c.FromStatus, &c.ToStatus, &c.UserID, &c.Note, &c.CreatedAt,
+			&c.User.FirstName, &c.User.LastName,
+		)
+
+		if err != nil {
+			return changes, err
+		}
+		c.User.ID = c.UserID
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+
+	return changes, nil
 }
 
 func (m *postgresDbRepo) UpdateReservation(r models.Reservation) error {
@@ -183,7 +618,9 @@ func (m *postgresDbRepo) GetReservationByID(id int) (models.Reservation, error)
 	stmt := `
 select res.id, res.first_name, res.last_name,
        res.email, res.phone, res.start_date, res.end_date, res.room_id,
-       res.created_at, res.updated_at, res.processed,
+       res.created_at, res.updated_at, res.status, res.total_price,
+       res.confirmation_code, res.adults, res.children,
+       res.cancelled_at, res.cancellation_reason, res.cancellation_penalty,
        r.id, r.room_name
 from reservations res
 left join rooms r on r.id = res.room_id
@@ -200,7 +637,14 @@ where res.id = $1`
 		&r.RoomID,
 		&r.CreatedAt,
 		&r.UpdatedAt,
-		&r.Processed,
+		&r.Status,
+		&r.TotalPrice,
+		&r.ConfirmationCode,
+		&r.Adults,
+		&r.Children,
+		&r.CancelledAt,
+		&r.CancellationReason,
+		&r.CancellationPenalty,
 		&r.Room.ID,
 		&r.Room.RoomName,
 	)
@@ -208,10 +652,58 @@ where res.id = $1`
 	if err != nil {
 		return r, err
 	}
+
+	r.Rooms, err = m.getReservationRooms(ctx, r.ID)
 	return r, err
 }
 
-func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
+// getReservationRooms returns the rooms booked by a reservation
+func (m *postgresDbRepo) getReservationRooms(ctx context.Context, reservationID int) ([]models.ReservationRoom, error) {
+	var rooms []models.ReservationRoom
+
+	stmt := `
+select rr.id, rr.reservation_id, rr.room_id, rr.start_date, rr.end_date, rr.total_price,
+       r.id, r.room_name, coalesce(rt.nightly_rate, 0), r.max_adults, r.max_children
+from reservation_rooms rr
+left join rooms r on r.id = rr.room_id
+left join room_rates rt on rt.room_id = rr.room_id
+where rr.reservation_id = $1
+order by rr.id
+`
+	rows, err := m.DB.QueryContext(ctx, stmt, reservationID)
+	if err != nil {
+		return rooms, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var r models.ReservationRoom
+		err := rows.Scan(
+			&r.ID, &r.ReservationID, &r.RoomID, &r.StartDate, &r.EndDate, &r.TotalPrice,
+			&r.Room.ID, &r.Room.RoomName, &r.Room.NightlyRate, &r.Room.MaxAdults, &r.Room.MaxChildren,
+		)
+
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// AllNewReservations returns the reservations that are still open (pending, confirmed or checked in),
+// narrowed down to one status unless status is empty
+func (m *postgresDbRepo) AllNewReservations(status models.ReservationStatus) ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -220,13 +712,17 @@ func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
 	stmt := `
 select res.id, res.first_name, res.last_name,
        res.email, res.phone, res.start_date, res.end_date, res.room_id,
-       res.created_at, res.updated_at, res.processed,
+       res.created_at, res.updated_at, res.status, res.total_price,
+       res.confirmation_code, res.adults, res.children,
+       res.cancelled_at, res.cancellation_reason, res.cancellation_penalty,
        r.id, r.room_name
 from reservations res
 left join rooms r on r.id = res.room_id
-where processed = 0
+where res.status in ('pending', 'confirmed', 'checked_in')
+  and ($1 = '' or res.status = $1)
+order by res.start_date
 `
-	rows, err := m.DB.QueryContext(ctx, stmt)
+	rows, err := m.DB.QueryContext(ctx, stmt, string(status))
 	defer func(rows *sql.Rows) {
 		err := rows.Close()
 		if err != nil {
@@ -249,7 +745,14 @@ where processed = 0
 			&r.RoomID,
 			&r.CreatedAt,
 			&r.UpdatedAt,
-			&r.Processed,
+			&r.Status,
+			&r.TotalPrice,
+			&r.ConfirmationCode,
+			&r.Adults,
+			&r.Children,
+			&r.CancelledAt,
+			&r.CancellationReason,
+			&r.CancellationPenalty,
 			&r.Room.ID,
 			&r.Room.RoomName,
 		)
@@ -267,7 +770,8 @@ where processed = 0
 	return reservations, nil
 }
 
-func (m *postgresDbRepo) AllReservations() ([]models.Reservation, error) {
+// AllReservations returns every reservation, or only those in status unless it is empty
+func (m *postgresDbRepo) AllReservations(status models.ReservationStatus) ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -276,12 +780,15 @@ func (m *postgresDbRepo) AllReservations() ([]models.Reservation, error) {
 	stmt := `
 select res.id, res.first_name, res.last_name,
        res.email, res.phone, res.start_date, res.end_date, res.room_id,
-       res.created_at, res.updated_at, res.processed,
+       res.created_at, res.updated_at, res.status, res.total_price,
+       res.confirmation_code, res.adults, res.children,
+       res.cancelled_at, res.cancellation_reason, res.cancellation_penalty,
        r.id, r.room_name
 from reservations res
 left join rooms r on r.id = res.room_id
+where $1 = '' or res.status = $1
 `
-	rows, err := m.DB.QueryContext(ctx, stmt)
+	rows, err := m.DB.QueryContext(ctx, stmt, string(status))
 	defer func(rows *sql.Rows) {
 		err := rows.Close()
 		if err != nil {
@@ -304,7 +811,14 @@ left join rooms r on r.id = res.room_id
 			&r.RoomID,
 			&r.CreatedAt,
 			&r.UpdatedAt,
-			&r.Processed,
+			&r.Status,
+			&r.TotalPrice,
+			&r.ConfirmationCode,
+			&r.Adults,
+			&r.Children,
+			&r.CancelledAt,
+			&r.CancellationReason,
+			&r.CancellationPenalty,
 			&r.Room.ID,
 			&r.Room.RoomName,
 		)
@@ -396,8 +910,10 @@ func (m *postgresDbRepo) InsertReservation(res models.Reservation) (int, error)
 	stmt := `
 insert into reservations (first_name, last_name, email,
                           phone, start_date, end_date,
-                          room_id, created_at, updated_at)
-values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+                          room_id, total_price, confirmation_code,
+                          adults, children,
+                          created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`
 	err := m.DB.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
@@ -406,6 +922,10 @@ values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.TotalPrice,
+		res.ConfirmationCode,
+		res.Adults,
+		res.Children,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -435,60 +955,483 @@ values ($1, $2, $3, $4, $5, $6, $7) returning id`
 	return newID, err
 }
 
-func (m *postgresDbRepo) SearchAvailabilityByRoomID(start, end time.Time, roomID int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	var numRows int
-	stmt := `
-select count(1)
-from room_restrictions rr
-where
-    rr.room_id = $1
-    AND ((rr.start_date <= $2 AND rr.end_date > $2) OR (rr.start_date <= $3 AND rr.end_date > $3)) limit 1`
-	err := m.DB.QueryRowContext(ctx, stmt,
-		roomID,
-		start,
-		end,
-	).Scan(&numRows)
-
-	return numRows == 0, err
-}
-
-func (m *postgresDbRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// InsertReservationWithRestriction stores a reservation, its rooms and their room restrictions in a single
+// transaction, so that either every room is booked or none is. holdIDs[i] is the hold the guest still has on
+// res.Rooms[i] (0 for none); a hold that is still there becomes the room's reservation restriction. Without
+// a hold, a room of a type that has been taken in the meantime is swapped for another free unit of the type.
+// The stay rules are checked again for the room each stay ends up in, so a rule added since the search still
+// applies. The mails go to the outbox in the same transaction, so they are sent exactly when the reservation is stored.
+func (m *postgresDbRepo) InsertReservationWithRestriction(res models.Reservation, holdIDs []int, mails []models.MailData) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var rooms []models.Room
-	stmt := `
-select r.id, r.room_name, r.created_at, r.updated_at
-from rooms r
-where r.id not in (
-	select distinct rr.room_id
-	from room_restrictions rr
-	where (rr.start_date <= $1 AND rr.end_date > $1) OR (rr.start_date <= $2 AND rr.end_date > $2)   
-)`
-	rows, err := m.DB.QueryContext(ctx, stmt,
-		start,
-		end,
-	)
-
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return rooms, err
+		return 0, err
 	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
 
-	for rows.Next() {
-		var room models.Room
+	res.SummarizeRooms()
 
-		err = rows.Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt)
-		if err != nil {
-			return rooms, err
-		}
-		rooms = append(rooms, room)
+	rules, err := stayRulesByDate(ctx, tx, res.StartDate, res.EndDate)
+	if err != nil {
+		return 0, err
 	}
 
-	return rooms, err
-}
+	var newID int
+
+	stmt := `
+insert into reservations (first_name, last_name, email,
+                          phone, start_date, end_date,
+                          room_id, total_price, confirmation_code,
+                          adults, children,
+                          created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		res.TotalPrice,
+		res.ConfirmationCode,
+		res.Adults,
+		res.Children,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertStatusChange(ctx, tx, newID, "", models.ReservationPending, 0, "")
+	if err != nil {
+		return 0, err
+	}
+
+	for i, room := range res.RoomStays() {
+		holdID := 0
+		if i < len(holdIDs) {
+			holdID = holdIDs[i]
+		}
+
+		unitID, err := insertReservationRestriction(ctx, tx, newID, room, holdID)
+		if err != nil {
+			return 0, err
+		}
+		if stayRuleViolation(rules, unitID, room.StartDate, room.EndDate) != "" {
+			return 0, repository.ErrStayRuleViolated
+		}
+
+		if i == 0 && unitID != res.RoomID {
+			_, err = tx.ExecContext(ctx, `update reservations set room_id = $2 where id = $1`, newID, unitID)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		stmt = `
+insert into reservation_rooms (reservation_id, room_id, start_date, end_date, total_price,
+                               created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7)`
+		_, err = tx.ExecContext(ctx, stmt,
+			newID,
+			unitID,
+			room.StartDate,
+			room.EndDate,
+			room.TotalPrice,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = insertMails(ctx, tx, mails)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// insertReservationRestriction restricts the room for the reservation, converting the guest's hold when it is still there
+// and the room is still active, and returns the room that was restricted
+func insertReservationRestriction(ctx context.Context, tx *sql.Tx, reservationID int, room models.ReservationRoom, holdID int) (int, error) {
+	stmt := `
+update room_restrictions
+set restriction_id = $2, reservation_id = $3, expires_at = null, updated_at = $4
+where id = $1 and restriction_id = $5 and room_id = $6 and start_date = $7 and end_date = $8
+  and exists (select 1 from rooms r where r.id = $6 and r.active)`
+	result, err := tx.ExecContext(ctx, stmt,
+		holdID,
+		models.RestrictionReservation,
+		reservationID,
+		time.Now(),
+		models.RestrictionHold,
+		room.RoomID,
+		room.StartDate,
+		room.EndDate,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	converted, err := result.RowsAffected()
+	if err != nil || converted > 0 {
+		return room.RoomID, err
+	}
+
+	// the hold is gone (or there never was one), take the room or another unit of its type if one is still free
+	unitID, err := assignUnit(ctx, tx, room.RoomID, room.StartDate, room.EndDate, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	err = deleteExpiredHolds(ctx, tx, unitID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `
+insert into room_restrictions (restriction_id, reservation_id, room_id,
+                               start_date, end_date,
+                               created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, stmt,
+		models.RestrictionReservation,
+		reservationID,
+		unitID,
+		room.StartDate,
+		room.EndDate,
+		time.Now(),
+		time.Now(),
+	)
+	if isExclusionViolation(err) {
+		return 0, repository.ErrRoomNotAvailable
+	}
+	return unitID, err
+}
+
+// assignUnit picks the room to restrict from start to end: roomID itself while it is free, otherwise the first
+// free unit of its type. Only active rooms are picked, and the restrictions of reservationID don't count. When
+// nothing is free ErrRoomNotAvailable is returned.
+func assignUnit(ctx context.Context, tx *sql.Tx, roomID int, start, end time.Time, reservationID int) (int, error) {
+	var unitID int
+	stmt := `
+select r.id
+from rooms r
+join rooms wanted on wanted.id = $1
+where r.active
+  and (r.id = wanted.id or r.room_type_id = wanted.room_type_id)
+  and not exists (
+	select 1
+	from room_restrictions rr
+	where rr.room_id = r.id
+	  and rr.start_date < $3 and rr.end_date > $2
+	  and coalesce(rr.reservation_id, 0) <> $4
+	  and (rr.expires_at is null or rr.expires_at > $5)
+)
+order by r.id = wanted.id desc, r.sort_order, r.id
+limit 1`
+	err := tx.QueryRowContext(ctx, stmt, roomID, start, end, reservationID, time.Now()).Scan(&unitID)
+	if err == sql.ErrNoRows {
+		return 0, repository.ErrRoomNotAvailable
+	}
+	return unitID, err
+}
+
+// InsertHold holds the room from start to end until expiresAt while the guest completes the booking form.
+// When the room is a unit of a type that is already taken, another free unit is held instead. The hold's
+// RoomID is the room that was held.
+func (m *postgresDbRepo) InsertHold(roomID int, start, end, expiresAt time.Time) (models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hold := models.RoomRestriction{
+		RestrictionID: models.RestrictionHold,
+		StartDate:     start,
+		EndDate:       end,
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return hold, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	hold.RoomID, err = assignUnit(ctx, tx, roomID, start, end, 0)
+	if err != nil {
+		return hold, err
+	}
+
+	err = deleteExpiredHolds(ctx, tx, hold.RoomID)
+	if err != nil {
+		return hold, err
+	}
+
+	stmt := `
+insert into room_restrictions (restriction_id, reservation_id, room_id,
+                               start_date, end_date, expires_at,
+                               created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		models.RestrictionHold,
+		nil,
+		hold.RoomID,
+		start,
+		end,
+		expiresAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&hold.ID)
+	if err != nil {
+		if isExclusionViolation(err) {
+			return hold, repository.ErrRoomNotAvailable
+		}
+		return hold, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return hold, err
+	}
+
+	return hold, nil
+}
+
+// deleteExpiredHolds releases the room's expired holds that the sweeper hasn't got to yet,
+// so that they don't trip the overlap guard
+func deleteExpiredHolds(ctx context.Context, tx *sql.Tx, roomID int) error {
+	stmt := `delete from room_restrictions where room_id = $1 and restriction_id = $2 and expires_at <= $3`
+	_, err := tx.ExecContext(ctx, stmt, roomID, models.RestrictionHold, time.Now())
+	return err
+}
+
+// DeleteHold releases a hold before it expires
+func (m *postgresDbRepo) DeleteHold(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `delete from room_restrictions where id = $1 and restriction_id = $2`
+	_, err := m.DB.ExecContext(ctx, stmt, id, models.RestrictionHold)
+	return err
+}
+
+// DeleteExpiredHolds releases every expired hold and returns how many there were
+func (m *postgresDbRepo) DeleteExpiredHolds() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `delete from room_restrictions where restriction_id = $1 and expires_at <= $2`
+	result, err := m.DB.ExecContext(ctx, stmt, models.RestrictionHold, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	released, err := result.RowsAffected()
+	return int(released), err
+}
+
+// isExclusionViolation reports whether err was raised by an exclusion constraint,
+// i.e. the room_restrictions overlap guard
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == exclusionViolationCode
+}
+
+// SearchAvailabilityByRoomID reports whether the room is free from start to end. When it isn't
+// because of a stay rule, the rule's reason is returned as well.
+func (m *postgresDbRepo) SearchAvailabilityByRoomID(start, end time.Time, roomID int) (bool, string, error) {
+	return m.searchAvailability(start, end, roomID, 0)
+}
+
+// SearchAvailabilityForReservation reports whether the reservation's room is free for its dates,
+// ignoring the restrictions the reservation itself holds
+func (m *postgresDbRepo) SearchAvailabilityForReservation(res models.Reservation) (bool, string, error) {
+	return m.searchAvailability(res.StartDate, res.EndDate, res.RoomID, res.ID)
+}
+
+func (m *postgresDbRepo) searchAvailability(start, end time.Time, roomID, reservationID int) (bool, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var active bool
+	err := m.DB.QueryRowContext(ctx, `select active from rooms where id = $1`, roomID).Scan(&active)
+	if err != nil {
+		return false, "", err
+	}
+	if !active {
+		return false, "This room can't be booked at the moment", nil
+	}
+
+	// a room of a type is free as long as one of the type's units is
+	var freeUnits int
+	stmt := `
+select count(1)
+from rooms r
+join rooms wanted on wanted.id = $1
+where (r.id = wanted.id or (r.active and r.room_type_id = wanted.room_type_id))
+  and not exists (
+	select 1
+	from room_restrictions rr
+	where rr.room_id = r.id
+	  AND rr.start_date < $3 AND rr.end_date > $2
+	  AND coalesce(rr.reservation_id, 0) <> $4
+	  AND (rr.expires_at is null or rr.expires_at > $5)
+)`
+	err = m.DB.QueryRowContext(ctx, stmt,
+		roomID,
+		start,
+		end,
+		reservationID,
+		time.Now(),
+	).Scan(&freeUnits)
+	if err != nil || freeUnits == 0 {
+		return false, "", err
+	}
+
+	rules, err := m.GetStayRulesByDate(start, end)
+	if err != nil {
+		return false, "", err
+	}
+
+	if reason := stayRuleViolation(rules, roomID, start, end); reason != "" {
+		return false, reason, nil
+	}
+
+	return true, "", nil
+}
+
+// SearchAvailabilityForAllRooms returns the rooms that are free from start to end, allowed by the stay
+// rules and big enough for the given number of adults and children. A room type is returned once, as its
+// first free unit, with FreeUnits telling how many of its units are free.
+func (m *postgresDbRepo) SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rooms []models.Room
+
+	rules, err := m.GetStayRulesByDate(start, end)
+	if err != nil {
+		return rooms, err
+	}
+
+	stmt := `
+select r.id, r.room_name, coalesce(rt.nightly_rate, 0), r.max_adults, r.max_children,
+       coalesce(r.room_type_id, 0), coalesce(t.name, ''),
+       r.created_at, r.updated_at
+from rooms r
+left join room_rates rt on rt.room_id = r.id
+left join room_types t on t.id = r.room_type_id
+where r.active
+  and r.max_adults >= $4
+  and r.max_adults + r.max_children >= $4 + $5
+  and r.id not in (
+	select distinct rr.room_id
+	from room_restrictions rr
+	where rr.start_date < $2 AND rr.end_date > $1
+	  AND (rr.expires_at is null or rr.expires_at > $3)
+)
+order by r.sort_order, r.id`
+	rows, err := m.DB.QueryContext(ctx, stmt,
+		start,
+		end,
+		time.Now(),
+		adults,
+		children,
+	)
+
+	if err != nil {
+		return rooms, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	// where each room type is in rooms
+	typeIndex := make(map[int]int)
+
+	for rows.Next() {
+		var room models.Room
+
+		err = rows.Scan(&room.ID, &room.RoomName, &room.NightlyRate, &room.MaxAdults, &room.MaxChildren,
+			&room.RoomTypeID, &room.RoomType.Name,
+			&room.CreatedAt, &room.UpdatedAt)
+		if err != nil {
+			return rooms, err
+		}
+		room.RoomType.ID = room.RoomTypeID
+
+		if stayRuleViolation(rules, room.ID, start, end) != "" {
+			continue
+		}
+
+		if room.HasType() {
+			if i, ok := typeIndex[room.RoomTypeID]; ok {
+				rooms[i].FreeUnits++
+				continue
+			}
+			typeIndex[room.RoomTypeID] = len(rooms)
+		}
+		room.FreeUnits = 1
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
+
+// stayRuleViolation returns the reason of the first rule the stay breaks, or an empty string
+func stayRuleViolation(rules []models.StayRule, roomID int, start, end time.Time) string {
+	for _, rule := range rules {
+		if reason := rule.Violation(roomID, start, end); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// GetRoomRate returns the base nightly rate of a room
+func (m *postgresDbRepo) GetRoomRate(roomID int) (models.RoomRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rate models.RoomRate
+	stmt := `
+select rt.id, rt.room_id, rt.nightly_rate, rt.created_at, rt.updated_at
+from room_rates rt
+where rt.room_id = $1`
+	row := m.DB.QueryRowContext(ctx, stmt, roomID)
+	err := row.Scan(&rate.ID, &rate.RoomID, &rate.NightlyRate, &rate.CreatedAt, &rate.UpdatedAt)
+	return rate, err
+}
+
+// UpdateRoomRate sets the base nightly rate of a room, creating it when missing
+func (m *postgresDbRepo) UpdateRoomRate(roomID, nightlyRate int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+insert into room_rates (room_id, nightly_rate, created_at, updated_at)
+values ($1, $2, $3, $4)
+on conflict (room_id) do update
+set nightly_rate = excluded.nightly_rate, updated_at = excluded.updated_at`
+	_, err := m.DB.ExecContext(ctx, stmt, roomID, nightlyRate, time.Now(), time.Now())
+	return err
+}
 
 func (m *postgresDbRepo) GetRoomById(roomID int) (models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -496,10 +1439,1795 @@ func (m *postgresDbRepo) GetRoomById(roomID int) (models.Room, error) {
 
 	var room models.Room
 	stmt := `
-select r.id, r.room_name, r.created_at, r.updated_at
+select r.id, r.room_name, r.description, r.amenities,
+       coalesce(rt.nightly_rate, 0), coalesce(r.cancellation_policy_id, 0),
+       r.max_adults, r.max_children, r.active, r.sort_order,
+       coalesce(r.room_type_id, 0), coalesce(t.name, ''), r.ical_token,
+       r.created_at, r.updated_at
 from rooms r
+left join room_rates rt on rt.room_id = r.id
+left join room_types t on t.id = r.room_type_id
 where r.id = $1`
 	row := m.DB.QueryRowContext(ctx, stmt, roomID)
-	err := row.Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt)
+	err := row.Scan(&room.ID, &room.RoomName, &room.Description, &room.Amenities,
+		&room.NightlyRate, &room.CancellationPolicyID,
+		&room.MaxAdults, &room.MaxChildren, &room.Active, &room.SortOrder,
+		&room.RoomTypeID, &room.RoomType.Name, &room.ICalToken,
+		&room.CreatedAt, &room.UpdatedAt)
+	if err != nil {
+		return room, err
+	}
+	room.RoomType.ID = room.RoomTypeID
+
+	room.Photos, err = m.getRoomPhotos(ctx, room.ID)
 	return room, err
 }
+
+// getRoomPhotos returns the photos of a room in display order
+func (m *postgresDbRepo) getRoomPhotos(ctx context.Context, roomID int) ([]models.RoomPhoto, error) {
+	var photos []models.RoomPhoto
+
+	stmt := `
+select p.id, p.room_id, p.path, p.thumb_path, p.sort_order, p.created_at, p.updated_at
+from room_photos p
+where p.room_id = $1
+order by p.sort_order, p.id`
+	rows, err := m.DB.QueryContext(ctx, stmt, roomID)
+	if err != nil {
+		return photos, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var p models.RoomPhoto
+		err := rows.Scan(&p.ID, &p.RoomID, &p.Path, &p.ThumbPath, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return photos, err
+		}
+		photos = append(photos, p)
+	}
+
+	return photos, rows.Err()
+}
+
+// InsertRoom creates a room with its base rate, placing it after the existing rooms
+func (m *postgresDbRepo) InsertRoom(room models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	var newID int
+	stmt := `
+insert into rooms (room_name, description, amenities, max_adults, max_children, active, room_type_id,
+                   sort_order, created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, (select coalesce(max(sort_order), 0) + 1 from rooms), $8, $9)
+returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Description,
+		room.Amenities,
+		room.MaxAdults,
+		room.MaxChildren,
+		room.Active,
+		nullableID(room.RoomTypeID),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = saveRoomRate(ctx, tx, newID, room.NightlyRate)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom saves a room's details and base rate
+func (m *postgresDbRepo) UpdateRoom(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	stmt := `
+update rooms
+set room_name = $2, description = $3, amenities = $4,
+    max_adults = $5, max_children = $6, active = $7, room_type_id = $8, updated_at = $9
+where id = $1`
+	_, err = tx.ExecContext(ctx, stmt,
+		room.ID,
+		room.RoomName,
+		room.Description,
+		room.Amenities,
+		room.MaxAdults,
+		room.MaxChildren,
+		room.Active,
+		nullableID(room.RoomTypeID),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = saveRoomRate(ctx, tx, room.ID, room.NightlyRate)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// saveRoomRate stores the room's base nightly rate
+func saveRoomRate(ctx context.Context, tx *sql.Tx, roomID, nightlyRate int) error {
+	stmt := `
+insert into room_rates (room_id, nightly_rate, created_at, updated_at)
+values ($1, $2, $3, $4)
+on conflict (room_id) do update
+set nightly_rate = excluded.nightly_rate, updated_at = excluded.updated_at`
+	_, err := tx.ExecContext(ctx, stmt, roomID, nightlyRate, time.Now(), time.Now())
+	return err
+}
+
+// InsertRoomPhoto adds a photo after the room's other photos
+func (m *postgresDbRepo) InsertRoomPhoto(photo models.RoomPhoto) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `
+insert into room_photos (room_id, path, thumb_path, sort_order, created_at, updated_at)
+values ($1, $2, $3, (select coalesce(max(sort_order), 0) + 1 from room_photos where room_id = $1), $4, $5)
+returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		photo.RoomID,
+		photo.Path,
+		photo.ThumbPath,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+// DeleteRoomPhoto removes a photo of a room and returns it, so that its files can be deleted too
+func (m *postgresDbRepo) DeleteRoomPhoto(roomID, photoID int) (models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p models.RoomPhoto
+	stmt := `
+delete from room_photos
+where id = $1 and room_id = $2
+returning id, room_id, path, thumb_path, sort_order, created_at, updated_at`
+	err := m.DB.QueryRowContext(ctx, stmt, photoID, roomID).Scan(
+		&p.ID, &p.RoomID, &p.Path, &p.ThumbPath, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt,
+	)
+	return p, err
+}
+
+// DeactivateRoom takes a room off the website and out of the availability search. Its reservations are kept.
+func (m *postgresDbRepo) DeactivateRoom(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update rooms set active = false, updated_at = $2 where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, id, time.Now())
+	return err
+}
+
+// UpdateRoomICalToken sets the token of the room's calendar feed, an empty token turns the feed off
+func (m *postgresDbRepo) UpdateRoomICalToken(roomID int, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update rooms set ical_token = $2, updated_at = $3 where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, roomID, token, time.Now())
+	return err
+}
+
+// ReorderRooms sorts the rooms in the order of ids
+func (m *postgresDbRepo) ReorderRooms(ids []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	stmt := `update rooms set sort_order = $2, updated_at = $3 where id = $1`
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, stmt, id, i+1, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AllRoomTypes returns the room types by name, with the number of their active units
+func (m *postgresDbRepo) AllRoomTypes() ([]models.RoomType, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var types []models.RoomType
+
+	stmt := `
+select t.id, t.name, t.description, count(r.id) filter (where r.active),
+       t.created_at, t.updated_at
+from room_types t
+left join rooms r on r.room_type_id = t.id
+group by t.id
+order by t.name
+`
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return types, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var t models.RoomType
+		err := rows.Scan(&t.ID, &t.Name, &t.Description, &t.Units, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return types, err
+		}
+		types = append(types, t)
+	}
+
+	return types, rows.Err()
+}
+
+func (m *postgresDbRepo) GetRoomTypeByID(id int) (models.RoomType, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t models.RoomType
+	stmt := `
+select t.id, t.name, t.description,
+       (select count(1) from rooms r where r.room_type_id = t.id and r.active),
+       t.created_at, t.updated_at
+from room_types t
+where t.id = $1`
+	row := m.DB.QueryRowContext(ctx, stmt, id)
+	err := row.Scan(&t.ID, &t.Name, &t.Description, &t.Units, &t.CreatedAt, &t.UpdatedAt)
+	return t, err
+}
+
+func (m *postgresDbRepo) InsertRoomType(t models.RoomType) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `
+insert into room_types (name, description, created_at, updated_at)
+values ($1, $2, $3, $4) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt, t.Name, t.Description, time.Now(), time.Now()).Scan(&newID)
+	return newID, err
+}
+
+func (m *postgresDbRepo) UpdateRoomType(t models.RoomType) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update room_types set name = $2, description = $3, updated_at = $4 where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, t.ID, t.Name, t.Description, time.Now())
+	return err
+}
+
+// DeleteRoomType removes a room type, its units become rooms of their own
+func (m *postgresDbRepo) DeleteRoomType(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_types where id = $1`, id)
+	return err
+}
+
+// GetFreeUnits returns the other active units of the room's type that are free from start to end,
+// not counting the restrictions of reservationID. A room without a type has none.
+func (m *postgresDbRepo) GetFreeUnits(roomID int, start, end time.Time, reservationID int) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rooms []models.Room
+
+	stmt := `
+select r.id, r.room_name, coalesce(r.room_type_id, 0), r.sort_order
+from rooms r
+join rooms wanted on wanted.id = $1
+where r.id <> wanted.id
+  and r.active
+  and r.room_type_id = wanted.room_type_id
+  and not exists (
+	select 1
+	from room_restrictions rr
+	where rr.room_id = r.id
+	  and rr.start_date < $3 and rr.end_date > $2
+	  and coalesce(rr.reservation_id, 0) <> $4
+	  and (rr.expires_at is null or rr.expires_at > $5)
+)
+order by r.sort_order, r.id`
+	rows, err := m.DB.QueryContext(ctx, stmt, roomID, start, end, reservationID, time.Now())
+	if err != nil {
+		return rooms, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var r models.Room
+		err := rows.Scan(&r.ID, &r.RoomName, &r.RoomTypeID, &r.SortOrder)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, r)
+	}
+
+	return rooms, rows.Err()
+}
+
+// ReassignReservationRoom moves one room of a reservation, with its restriction, to another room
+// for the same dates
+func (m *postgresDbRepo) ReassignReservationRoom(reservationRoomID, roomID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	var stay models.ReservationRoom
+	stmt := `
+select id, reservation_id, room_id, start_date, end_date
+from reservation_rooms
+where id = $1
+for update`
+	err = tx.QueryRowContext(ctx, stmt, reservationRoomID).Scan(
+		&stay.ID, &stay.ReservationID, &stay.RoomID, &stay.StartDate, &stay.EndDate,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = deleteExpiredHolds(ctx, tx, roomID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `
+update room_restrictions
+set room_id = $5, updated_at = $6
+where reservation_id = $1 and room_id = $2 and start_date = $3 and end_date = $4`
+	_, err = tx.ExecContext(ctx, stmt,
+		stay.ReservationID, stay.RoomID, stay.StartDate, stay.EndDate,
+		roomID,
+		time.Now(),
+	)
+	if err != nil {
+		if isExclusionViolation(err) {
+			return repository.ErrRoomNotAvailable
+		}
+		return err
+	}
+
+	stmt = `update reservation_rooms set room_id = $2, updated_at = $3 where id = $1`
+	_, err = tx.ExecContext(ctx, stmt, stay.ID, roomID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// the reservation's own room follows its first room
+	stmt = `
+update reservations
+set room_id = $3, updated_at = $4
+where id = $1 and room_id = $2`
+	_, err = tx.ExecContext(ctx, stmt, stay.ReservationID, stay.RoomID, roomID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MoveReservationRoom moves one room of a reservation, with its restriction, to stay.RoomID from stay.StartDate
+// to stay.EndDate at stay.TotalPrice, and updates the reservation's own room, dates and total price. The
+// overlap guard checks the new room and dates against every other restriction within the transaction.
+// The mails go to the outbox in the same transaction.
+func (m *postgresDbRepo) MoveReservationRoom(stay models.ReservationRoom, mails []models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	status, err := lockReservationStatus(ctx, tx, stay.ReservationID)
+	if err != nil {
+		return err
+	}
+	if !(models.Reservation{Status: status}).IsOpen() {
+		return repository.ErrReservationClosed
+	}
+
+	var old models.ReservationRoom
+	stmt := `
+select id, room_id, start_date, end_date
+from reservation_rooms
+where id = $1 and reservation_id = $2
+for update`
+	err = tx.QueryRowContext(ctx, stmt, stay.ID, stay.ReservationID).Scan(
+		&old.ID, &old.RoomID, &old.StartDate, &old.EndDate,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = deleteExpiredHolds(ctx, tx, stay.RoomID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `
+update room_restrictions
+set room_id = $5, start_date = $6, end_date = $7, updated_at = $8
+where reservation_id = $1 and room_id = $2 and start_date = $3 and end_date = $4`
+	result, err := tx.ExecContext(ctx, stmt,
+		stay.ReservationID, old.RoomID, old.StartDate, old.EndDate,
+		stay.RoomID, stay.StartDate, stay.EndDate,
+		time.Now(),
+	)
+	if err == nil {
+		var moved int64
+		moved, err = result.RowsAffected()
+		if err == nil && moved == 0 {
+			// the stay had lost its restriction, restrict the new room and dates
+			_, err = insertReservationRestriction(ctx, tx, stay.ReservationID, stay, 0)
+		}
+	}
+	if err != nil {
+		if isExclusionViolation(err) {
+			return repository.ErrRoomNotAvailable
+		}
+		return err
+	}
+
+	stmt = `
+update reservation_rooms
+set room_id = $2, start_date = $3, end_date = $4, total_price = $5, updated_at = $6
+where id = $1`
+	_, err = tx.ExecContext(ctx, stmt,
+		stay.ID,
+		stay.RoomID, stay.StartDate, stay.EndDate,
+		stay.TotalPrice,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// the reservation's own room, dates and price are those of its first room, its stays and their sum
+	stmt = `
+update reservations res
+set room_id = s.room_id, start_date = s.start_date, end_date = s.end_date, total_price = s.total_price,
+    updated_at = $2
+from (
+	select (array_agg(room_id order by id))[1] as room_id,
+	       min(start_date) as start_date, max(end_date) as end_date, sum(total_price) as total_price
+	from reservation_rooms
+	where reservation_id = $1
+) s
+where res.id = $1`
+	_, err = tx.ExecContext(ctx, stmt, stay.ReservationID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = insertMails(ctx, tx, mails)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nullableID maps the zero id to NULL for optional foreign keys
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func (m *postgresDbRepo) AllRateRules() ([]models.RateRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rules []models.RateRule
+
+	stmt := `
+select rr.id, rr.name, coalesce(rr.room_id, 0),
+       rr.start_date, rr.end_date, rr.weekdays, rr.priority,
+       rr.amount_type, rr.amount,
+       rr.created_at, rr.updated_at,
+       coalesce(r.room_name, '')
+from rate_rules rr
+left join rooms r on r.id = rr.room_id
+order by rr.priority desc, rr.start_date
+`
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return rules, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var r models.RateRule
+		err := rows.Scan(
+			&r.ID, &r.Name, &r.RoomID,
+			&r.StartDate, &r.EndDate, &r.Weekdays, &r.Priority,
+			&r.AmountType, &r.Amount,
+			&r.CreatedAt, &r.UpdatedAt,
+			&r.Room.RoomName,
+		)
+
+		if err != nil {
+			return rules, err
+		}
+		r.Room.ID = r.RoomID
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// GetRateRulesForRoomByDate returns the room's own and the global rate rules overlapping the period
+func (m *postgresDbRepo) GetRateRulesForRoomByDate(roomID int, startDate, endDate time.Time) ([]models.RateRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rules []models.RateRule
+
+	stmt := `
+select rr.id, rr.name, coalesce(rr.room_id, 0),
+       rr.start_date, rr.end_date, rr.weekdays, rr.priority,
+       rr.amount_type, rr.amount,
+       rr.created_at, rr.updated_at
+from rate_rules rr
+where (rr.room_id = $1 or rr.room_id is null)
+  and rr.start_date <= $3 and rr.end_date >= $2
+`
+	rows, err := m.DB.QueryContext(ctx, stmt, roomID, startDate, endDate)
+	if err != nil {
+		return rules, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var r models.RateRule
+		err := rows.Scan(
+			&r.ID, &r.Name, &r.RoomID,
+			&r.StartDate, &r.EndDate, &r.Weekdays, &r.Priority,
+			&r.AmountType, &r.Amount,
+			&r.CreatedAt, &r.UpdatedAt,
+		)
+
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+func (m *postgresDbRepo) GetRateRuleByID(id int) (models.RateRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+select rr.id, rr.name, coalesce(rr.room_id, 0),
+       rr.start_date, rr.end_date, rr.weekdays, rr.priority,
+       rr.amount_type, rr.amount,
+       rr.created_at, rr.updated_at
+from rate_rules rr
+where rr.id = $1`
+	row := m.DB.QueryRowContext(ctx, stmt, id)
+
+	var r models.RateRule
+	err := row.Scan(
+		&r.ID, &r.Name, &r.RoomID,
+		&r.StartDate, &r.EndDate, &r.Weekdays, &r.Priority,
+		&r.AmountType, &r.Amount,
+		&r.CreatedAt, &r.UpdatedAt,
+	)
+	return r, err
+}
+
+func (m *postgresDbRepo) InsertRateRule(r models.RateRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `
+insert into rate_rules (name, room_id, start_date, end_date,
+                        weekdays, priority, amount_type, amount,
+                        created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.Name,
+		nullableID(r.RoomID),
+		r.StartDate,
+		r.EndDate,
+		r.Weekdays,
+		r.Priority,
+		r.AmountType,
+		r.Amount,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	return newID, err
+}
+
+func (m *postgresDbRepo) UpdateRateRule(r models.RateRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+update rate_rules
+set
+    name = $2, room_id = $3,
+    start_date = $4, end_date = $5,
+    weekdays = $6, priority = $7,
+    amount_type = $8, amount = $9,
+    updated_at = $10
+where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt,
+		r.ID,
+		r.Name, nullableID(r.RoomID),
+		r.StartDate, r.EndDate,
+		r.Weekdays, r.Priority,
+		r.AmountType, r.Amount,
+		time.Now(),
+	)
+	return err
+}
+
+func (m *postgresDbRepo) DeleteRateRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `delete from rate_rules where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, id)
+	return err
+}
+
+func (m *postgresDbRepo) AllStayRules() ([]models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rules []models.StayRule
+
+	stmt := `
+select sr.id, coalesce(sr.room_id, 0),
+       sr.start_date, sr.end_date, sr.weekdays,
+       sr.min_nights, sr.max_nights,
+       sr.closed_to_arrival, sr.closed_to_departure,
+       sr.created_at, sr.updated_at,
+       coalesce(r.room_name, '')
+from stay_rules sr
+left join rooms r on r.id = sr.room_id
+order by sr.start_date`
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return rules, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var r models.StayRule
+		err := rows.Scan(
+			&r.ID, &r.RoomID,
+			&r.StartDate, &r.EndDate, &r.Weekdays,
+			&r.MinNights, &r.MaxNights,
+			&r.ClosedToArrival, &r.ClosedToDeparture,
+			&r.CreatedAt, &r.UpdatedAt,
+			&r.Room.RoomName,
+		)
+		if err != nil {
+			return rules, err
+		}
+		r.Room.ID = r.RoomID
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// stayRulesByDateQuery selects the stay rules of every room covering any day from $1 to $2
+const stayRulesByDateQuery = `
+select sr.id, coalesce(sr.room_id, 0),
+       sr.start_date, sr.end_date, sr.weekdays,
+       sr.min_nights, sr.max_nights,
+       sr.closed_to_arrival, sr.closed_to_departure,
+       sr.created_at, sr.updated_at
+from stay_rules sr
+where sr.start_date <= $2 and sr.end_date >= $1`
+
+// GetStayRulesByDate returns the stay rules of every room covering any day from start to end
+func (m *postgresDbRepo) GetStayRulesByDate(start, end time.Time) ([]models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stayRulesByDateQuery, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return scanStayRules(rows)
+}
+
+// stayRulesByDate is GetStayRulesByDate within a transaction
+func stayRulesByDate(ctx context.Context, tx *sql.Tx, start, end time.Time) ([]models.StayRule, error) {
+	rows, err := tx.QueryContext(ctx, stayRulesByDateQuery, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return scanStayRules(rows)
+}
+
+// scanStayRules reads the stay rules of the rows and closes them
+func scanStayRules(rows *sql.Rows) ([]models.StayRule, error) {
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	var rules []models.StayRule
+	for rows.Next() {
+		var r models.StayRule
+		err := rows.Scan(
+			&r.ID, &r.RoomID,
+			&r.StartDate, &r.EndDate, &r.Weekdays,
+			&r.MinNights, &r.MaxNights,
+			&r.ClosedToArrival, &r.ClosedToDeparture,
+			&r.CreatedAt, &r.UpdatedAt,
+		)
+
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, rows.Err()
+}
+
+func (m *postgresDbRepo) GetStayRuleByID(id int) (models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+select sr.id, coalesce(sr.room_id, 0),
+       sr.start_date, sr.end_date, sr.weekdays,
+       sr.min_nights, sr.max_nights,
+       sr.closed_to_arrival, sr.closed_to_departure,
+       sr.created_at, sr.updated_at
+from stay_rules sr
+where sr.id = $1`
+	row := m.DB.QueryRowContext(ctx, stmt, id)
+
+	var r models.StayRule
+	err := row.Scan(
+		&r.ID, &r.RoomID,
+		&r.StartDate, &r.EndDate, &r.Weekdays,
+		&r.MinNights, &r.MaxNights,
+		&r.ClosedToArrival, &r.ClosedToDeparture,
+		&r.CreatedAt, &r.UpdatedAt,
+	)
+	return r, err
+}
+
+func (m *postgresDbRepo) InsertStayRule(r models.StayRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `
+insert into stay_rules (room_id, start_date, end_date, weekdays,
+                        min_nights, max_nights,
+                        closed_to_arrival, closed_to_departure,
+                        created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		nullableID(r.RoomID),
+		r.StartDate,
+		r.EndDate,
+		r.Weekdays,
+		r.MinNights,
+		r.MaxNights,
+		r.ClosedToArrival,
+		r.ClosedToDeparture,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	return newID, err
+}
+
+func (m *postgresDbRepo) UpdateStayRule(r models.StayRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+update stay_rules
+set
+    room_id = $2,
+    start_date = $3, end_date = $4, weekdays = $5,
+    min_nights = $6, max_nights = $7,
+    closed_to_arrival = $8, closed_to_departure = $9,
+    updated_at = $10
+where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt,
+		r.ID,
+		nullableID(r.RoomID),
+		r.StartDate, r.EndDate, r.Weekdays,
+		r.MinNights, r.MaxNights,
+		r.ClosedToArrival, r.ClosedToDeparture,
+		time.Now(),
+	)
+	return err
+}
+
+func (m *postgresDbRepo) DeleteStayRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `delete from stay_rules where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, id)
+	return err
+}
+
+// GetReservationByCode finds a reservation by its confirmation code and the guest's email
+func (m *postgresDbRepo) GetReservationByCode(code, email string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+select res.id, res.first_name, res.last_name,
+       res.email, res.phone, res.start_date, res.end_date, res.room_id,
+       res.created_at, res.updated_at, res.status, res.total_price,
+       res.confirmation_code, res.adults, res.children,
+       res.cancelled_at, res.cancellation_reason, res.cancellation_penalty,
+       r.id, r.room_name, coalesce(rt.nightly_rate, 0)
+from reservations res
+left join rooms r on r.id = res.room_id
+left join room_rates rt on rt.room_id = res.room_id
+where res.confirmation_code = $1 and lower(res.email) = lower($2)`
+	row := m.DB.QueryRowContext(ctx, stmt, code, email)
+
+	var r models.Reservation
+	err := row.Scan(
+		&r.ID, &r.FirstName, &r.LastName,
+		&r.Email,
+		&r.Phone,
+		&r.StartDate,
+		&r.EndDate,
+		&r.RoomID,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+		&r.Status,
+		&r.TotalPrice,
+		&r.ConfirmationCode,
+		&r.Adults,
+		&r.Children,
+		&r.CancelledAt,
+		&r.CancellationReason,
+		&r.CancellationPenalty,
+		&r.Room.ID,
+		&r.Room.RoomName,
+		&r.Room.NightlyRate,
+	)
+	if err != nil {
+		return r, err
+	}
+
+	r.Rooms, err = m.getReservationRooms(ctx, r.ID)
+	return r, err
+}
+
+// UpdateReservationDates moves a single room reservation and its restriction to new dates in a single transaction.
+// When its room is a unit of a type and is taken on the new dates, the reservation moves to another free unit.
+// A reservation of several rooms is refused with ErrGroupReservation. The mails go to the outbox in the same transaction.
+func (m *postgresDbRepo) UpdateReservationDates(res models.Reservation, mails []models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	// the rooms of a group reservation have their own dates and prices, moving them all alike would lose them
+	var rooms int
+	err = tx.QueryRowContext(ctx, `select count(*) from reservation_rooms where reservation_id = $1`, res.ID).Scan(&rooms)
+	if err != nil {
+		return err
+	}
+	if rooms > 1 {
+		return repository.ErrGroupReservation
+	}
+
+	unitID, err := assignUnit(ctx, tx, res.RoomID, res.StartDate, res.EndDate, res.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+update reservations
+set start_date = $2, end_date = $3, total_price = $4, room_id = $5, updated_at = $6
+where id = $1`
+	_, err = tx.ExecContext(ctx, stmt,
+		res.ID,
+		res.StartDate, res.EndDate,
+		res.TotalPrice,
+		unitID,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt = `
+update reservation_rooms
+set start_date = $2, end_date = $3, total_price = $4, room_id = $5, updated_at = $6
+where reservation_id = $1`
+	_, err = tx.ExecContext(ctx, stmt,
+		res.ID,
+		res.StartDate, res.EndDate,
+		res.TotalPrice,
+		unitID,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt = `
+update room_restrictions
+set start_date = $2, end_date = $3, room_id = $4, updated_at = $5
+where reservation_id = $1`
+	_, err = tx.ExecContext(ctx, stmt,
+		res.ID,
+		res.StartDate, res.EndDate,
+		unitID,
+		time.Now(),
+	)
+	if err != nil {
+		if isExclusionViolation(err) {
+			return repository.ErrRoomNotAvailable
+		}
+		return err
+	}
+
+	err = insertMails(ctx, tx, mails)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelReservation marks a reservation as cancelled and frees the room it held.
+// userID is the admin cancelling it, 0 for the guest. The mails go to the outbox in the same transaction.
+func (m *postgresDbRepo) CancelReservation(id int, reason string, penalty int, userID int, mails []models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	from, err := lockReservationStatus(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if !from.CanTransitionTo(models.ReservationCancelled) {
+		return repository.ErrInvalidStatusTransition
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+update reservations
+set status = $2, cancelled_at = $3, cancellation_reason = $4, cancellation_penalty = $5, updated_at = $6
+where id = $1`
+	_, err = tx.ExecContext(ctx, stmt, id, string(models.ReservationCancelled), time.Now(), reason, penalty, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = insertStatusChange(ctx, tx, id, from, models.ReservationCancelled, userID, reason)
+	if err != nil {
+		return err
+	}
+
+	err = insertMails(ctx, tx, mails)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetCancellationPolicyForRoom returns the room's cancellation policy, or the zero policy
+// (always free) when the room has none
+func (m *postgresDbRepo) GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+select cp.id, cp.name, cp.free_days, cp.penalty_type, cp.penalty_percent,
+       cp.created_at, cp.updated_at
+from rooms r
+join cancellation_policies cp on cp.id = r.cancellation_policy_id
+where r.id = $1`
+	row := m.DB.QueryRowContext(ctx, stmt, roomID)
+
+	var p models.CancellationPolicy
+	err := row.Scan(
+		&p.ID, &p.Name, &p.FreeDays, &p.PenaltyType, &p.PenaltyPercent,
+		&p.CreatedAt, &p.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.CancellationPolicy{}, nil
+	}
+	return p, err
+}
+
+func (m *postgresDbRepo) AllCancellationPolicies() ([]models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var policies []models.CancellationPolicy
+
+	stmt := `
+select cp.id, cp.name, cp.free_days, cp.penalty_type, cp.penalty_percent,
+       cp.created_at, cp.updated_at
+from cancellation_policies cp
+order by cp.name
+`
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return policies, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var p models.CancellationPolicy
+		err := rows.Scan(
+			&p.ID, &p.Name, &p.FreeDays, &p.PenaltyType, &p.PenaltyPercent,
+			&p.CreatedAt, &p.UpdatedAt,
+		)
+
+		if err != nil {
+			return policies, err
+		}
+		policies = append(policies, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return policies, err
+	}
+
+	return policies, nil
+}
+
+func (m *postgresDbRepo) GetCancellationPolicyByID(id int) (models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+select cp.id, cp.name, cp.free_days, cp.penalty_type, cp.penalty_percent,
+       cp.created_at, cp.updated_at
+from cancellation_policies cp
+where cp.id = $1`
+	row := m.DB.QueryRowContext(ctx, stmt, id)
+
+	var p models.CancellationPolicy
+	err := row.Scan(
+		&p.ID, &p.Name, &p.FreeDays, &p.PenaltyType, &p.PenaltyPercent,
+		&p.CreatedAt, &p.UpdatedAt,
+	)
+	return p, err
+}
+
+func (m *postgresDbRepo) InsertCancellationPolicy(p models.CancellationPolicy) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `
+insert into cancellation_policies (name, free_days, penalty_type, penalty_percent,
+                                   created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.Name,
+		p.FreeDays,
+		p.PenaltyType,
+		p.PenaltyPercent,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	return newID, err
+}
+
+func (m *postgresDbRepo) UpdateCancellationPolicy(p models.CancellationPolicy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+update cancellation_policies
+set
+    name = $2, free_days = $3,
+    penalty_type = $4, penalty_percent = $5,
+    updated_at = $6
+where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt,
+		p.ID,
+		p.Name, p.FreeDays,
+		p.PenaltyType, p.PenaltyPercent,
+		time.Now(),
+	)
+	return err
+}
+
+func (m *postgresDbRepo) DeleteCancellationPolicy(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `delete from cancellation_policies where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, id)
+	return err
+}
+
+// UpdateRoomCancellationPolicy attaches a policy to a room, policyID 0 detaches it
+func (m *postgresDbRepo) UpdateRoomCancellationPolicy(roomID, policyID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update rooms set cancellation_policy_id = $2, updated_at = $3 where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, roomID, nullableID(policyID), time.Now())
+	return err
+}
+
+// AllICalImports returns the imported calendars with the number of nights ranges they block, by room
+func (m *postgresDbRepo) AllICalImports() ([]models.ICalImport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var imports []models.ICalImport
+
+	stmt := `
+select ii.id, ii.room_id, ii.name, ii.url, ii.last_synced_at, ii.last_error,
+       (select count(*) from room_restrictions rr where rr.ical_import_id = ii.id),
+       ii.created_at, ii.updated_at,
+       r.id, r.room_name
+from ical_imports ii
+join rooms r on r.id = ii.room_id
+order by r.sort_order, ii.name
+`
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return imports, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var imp models.ICalImport
+		err := rows.Scan(
+			&imp.ID, &imp.RoomID, &imp.Name, &imp.URL, &imp.LastSyncedAt, &imp.LastError,
+			&imp.Blocks,
+			&imp.CreatedAt, &imp.UpdatedAt,
+			&imp.Room.ID, &imp.Room.RoomName,
+		)
+		if err != nil {
+			return imports, err
+		}
+		imports = append(imports, imp)
+	}
+
+	return imports, rows.Err()
+}
+
+// GetICalImportByID returns an imported calendar
+func (m *postgresDbRepo) GetICalImportByID(id int) (models.ICalImport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var imp models.ICalImport
+	stmt := `
+select ii.id, ii.room_id, ii.name, ii.url, ii.last_synced_at, ii.last_error,
+       ii.created_at, ii.updated_at
+from ical_imports ii
+where ii.id = $1`
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(
+		&imp.ID, &imp.RoomID, &imp.Name, &imp.URL, &imp.LastSyncedAt, &imp.LastError,
+		&imp.CreatedAt, &imp.UpdatedAt,
+	)
+	return imp, err
+}
+
+// InsertICalImport registers a calendar to import for a room
+func (m *postgresDbRepo) InsertICalImport(imp models.ICalImport) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	stmt := `
+insert into ical_imports (room_id, name, url, created_at, updated_at)
+values ($1, $2, $3, $4, $5) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		imp.RoomID,
+		imp.Name,
+		imp.URL,
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+	return id, err
+}
+
+// DeleteICalImport removes an imported calendar with the blocks of its events
+func (m *postgresDbRepo) DeleteICalImport(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from ical_imports where id = $1`, id)
+	return err
+}
+
+// SyncICalImport makes the blocks of an imported calendar match blocks, one per event UID: new events are
+// blocked, moved ones are updated and the ones no longer in the calendar are freed, so running it again with
+// the same events changes nothing. Events on nights already taken in the app are reported as conflicts.
+// Events that are over are left alone: they aren't blocked when new, and their blocks are kept, even once
+// the calendar drops them.
+func (m *postgresDbRepo) SyncICalImport(importID int, blocks []models.RoomRestriction) (models.ICalSync, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sync models.ICalSync
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return sync, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	// syncs of the same calendar wait for each other
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select room_id from ical_imports where id = $1 for update`, importID).Scan(&roomID)
+	if err != nil {
+		return sync, err
+	}
+
+	existing := make(map[string]models.RoomRestriction)
+	var stale []int
+
+	rows, err := tx.QueryContext(ctx, `
+select id, ical_uid, start_date, end_date
+from room_restrictions
+where ical_import_id = $1`, importID)
+	if err != nil {
+		return sync, err
+	}
+	for rows.Next() {
+		var r models.RoomRestriction
+		err = rows.Scan(&r.ID, &r.ICalUID, &r.StartDate, &r.EndDate)
+		if err != nil {
+			_ = rows.Close()
+			return sync, err
+		}
+		if _, ok := existing[r.ICalUID]; ok {
+			stale = append(stale, r.ID)
+			continue
+		}
+		existing[r.ICalUID] = r
+	}
+	if err = rows.Close(); err != nil {
+		return sync, err
+	}
+	if err = rows.Err(); err != nil {
+		return sync, err
+	}
+
+	err = deleteExpiredHolds(ctx, tx, roomID)
+	if err != nil {
+		return sync, err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	seen := make(map[string]bool)
+	for _, b := range blocks {
+		if seen[b.ICalUID] {
+			continue
+		}
+		seen[b.ICalUID] = true
+
+		if !b.EndDate.After(today) {
+			continue
+		}
+
+		old, ok := existing[b.ICalUID]
+		if ok && old.StartDate.Equal(b.StartDate) && old.EndDate.Equal(b.EndDate) {
+			continue
+		}
+
+		// a conflicting event must not abort the transaction, so each change can be rolled back on its own
+		_, err = tx.ExecContext(ctx, `savepoint ical_event`)
+		if err != nil {
+			return sync, err
+		}
+
+		if ok {
+			_, err = tx.ExecContext(ctx,
+				`update room_restrictions set start_date = $2, end_date = $3, updated_at = $4 where id = $1`,
+				old.ID, b.StartDate, b.EndDate, time.Now())
+		} else {
+			stmt := `
+insert into room_restrictions (restriction_id, room_id, ical_import_id, ical_uid,
+                               start_date, end_date,
+                               created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8)`
+			_, err = tx.ExecContext(ctx, stmt,
+				models.RestrictionOwnerBlock,
+				roomID,
+				importID,
+				b.ICalUID,
+				b.StartDate,
+				b.EndDate,
+				time.Now(),
+				time.Now(),
+			)
+		}
+
+		if isExclusionViolation(err) {
+			_, err = tx.ExecContext(ctx, `rollback to savepoint ical_event`)
+			if err != nil {
+				return sync, err
+			}
+			sync.Conflicts = append(sync.Conflicts, b.ICalUID)
+			// the event moved off its old nights, they aren't blocked any longer
+			if ok {
+				stale = append(stale, old.ID)
+				sync.Removed++
+			}
+			continue
+		} else if err != nil {
+			return sync, err
+		}
+
+		if ok {
+			sync.Updated++
+		} else {
+			sync.Added++
+		}
+	}
+
+	for uid, old := range existing {
+		if !seen[uid] && old.EndDate.After(today) {
+			stale = append(stale, old.ID)
+			sync.Removed++
+		}
+	}
+	for _, id := range stale {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1`, id)
+		if err != nil {
+			return sync, err
+		}
+	}
+
+	lastError := ""
+	if len(sync.Conflicts) > 0 {
+		lastError = fmt.Sprintf("Events on nights already taken: %s", strings.Join(sync.Conflicts, ", "))
+	}
+	_, err = tx.ExecContext(ctx,
+		`update ical_imports set last_synced_at = $2, last_error = $3, updated_at = $2 where id = $1`,
+		importID, time.Now(), lastError)
+	if err != nil {
+		return sync, err
+	}
+
+	return sync, tx.Commit()
+}
+
+// UpdateICalImportError records why the last sync of an imported calendar failed
+func (m *postgresDbRepo) UpdateICalImportError(id int, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update ical_imports set last_error = $2, updated_at = $3 where id = $1`,
+		id, message, time.Now())
+	return err
+}
+
+// insertMails puts emails in the outbox, so they are sent only if the transaction commits
+func insertMails(ctx context.Context, tx *sql.Tx, mails []models.MailData) error {
+	stmt := `
+insert into mail_outbox (to_address, from_address, subject, content, text, attachments,
+                         status, attempts, next_attempt_at, created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, 0, $8, $8, $8)`
+	for _, mail := range mails {
+		attachments, err := json.Marshal(mail.Attachments)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, stmt,
+			mail.To,
+			mail.From,
+			mail.Subject,
+			mail.Content,
+			mail.Text,
+			string(attachments),
+			models.MailPending,
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ClaimMail takes up to limit pending emails that are due and counts the attempt to send them. They aren't
+// due again until the lease is over, so another worker only picks them up if this one never reports back.
+func (m *postgresDbRepo) ClaimMail(limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mails []models.OutboxMail
+
+	now := time.Now()
+	stmt := `
+update mail_outbox
+set attempts = attempts + 1, next_attempt_at = $3, updated_at = $2
+where id in (select id
+             from mail_outbox
+             where status = $4 and next_attempt_at <= $2 and not wrap_in_layout
+             order by next_attempt_at
+             limit $1 for update skip locked)
+returning id, to_address, from_address, subject, content, text, attachments,
+          status, attempts, next_attempt_at, last_error, sent_at, created_at, updated_at`
+	rows, err := m.DB.QueryContext(ctx, stmt, limit, now, now.Add(lease), models.MailPending)
+	if err != nil {
+		return mails, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		mail, err := scanOutboxMail(rows)
+		if err != nil {
+			return mails, err
+		}
+		mails = append(mails, mail)
+	}
+
+	return mails, rows.Err()
+}
+
+// scanOutboxMail reads an email of the outbox from a row
+func scanOutboxMail(rows *sql.Rows) (models.OutboxMail, error) {
+	var mail models.OutboxMail
+	var attachments string
+	err := rows.Scan(
+		&mail.ID, &mail.Mail.To, &mail.Mail.From, &mail.Mail.Subject, &mail.Mail.Content, &mail.Mail.Text,
+		&attachments,
+		&mail.Status, &mail.Attempts, &mail.NextAttemptAt, &mail.LastError, &mail.SentAt,
+		&mail.CreatedAt, &mail.UpdatedAt,
+	)
+	if err != nil {
+		return mail, err
+	}
+
+	err = json.Unmarshal([]byte(attachments), &mail.Mail.Attachments)
+	return mail, err
+}
+
+// MarkMailSent records that an email of the outbox was sent
+func (m *postgresDbRepo) MarkMailSent(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update mail_outbox set status = $2, last_error = '', sent_at = $3, updated_at = $3 where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, id, models.MailSent, time.Now())
+	return err
+}
+
+// RetryMail records why an email couldn't be sent and when to try again
+func (m *postgresDbRepo) RetryMail(id int, lastError string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update mail_outbox set last_error = $2, next_attempt_at = $3, updated_at = $4 where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, id, lastError, at, time.Now())
+	return err
+}
+
+// UnwrappedMail returns the unsent emails queued before the outbox stored whole emails, their content still
+// has to be put in the layout
+func (m *postgresDbRepo) UnwrappedMail() ([]models.OutboxMail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mails []models.OutboxMail
+
+	rows, err := m.DB.QueryContext(ctx, `select id, content from mail_outbox where wrap_in_layout order by id`)
+	if err != nil {
+		return mails, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var mail models.OutboxMail
+		err := rows.Scan(&mail.ID, &mail.Mail.Content)
+		if err != nil {
+			return mails, err
+		}
+		mails = append(mails, mail)
+	}
+
+	return mails, rows.Err()
+}
+
+// WrapMail stores the content of an unwrapped email of the outbox once it is in the layout
+func (m *postgresDbRepo) WrapMail(id int, content string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update mail_outbox set content = $2, wrap_in_layout = false, updated_at = $3 where id = $1 and wrap_in_layout`
+	_, err := m.DB.ExecContext(ctx, stmt, id, content, time.Now())
+	return err
+}
+
+// DeadLetterMail gives up on an email, it stays in the outbox until an admin resends it
+func (m *postgresDbRepo) DeadLetterMail(id int, lastError string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update mail_outbox set status = $2, last_error = $3, updated_at = $4 where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, id, models.MailDead, lastError, time.Now())
+	return err
+}
+
+// AllOutboxMail returns the emails of the outbox with the status, newest first
+func (m *postgresDbRepo) AllOutboxMail(status models.MailStatus) ([]models.OutboxMail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mails []models.OutboxMail
+
+	stmt := `
+select id, to_address, from_address, subject, content, text, attachments,
+       status, attempts, next_attempt_at, last_error, sent_at, created_at, updated_at
+from mail_outbox
+where status = $1
+order by created_at desc, id desc
+`
+	rows, err := m.DB.QueryContext(ctx, stmt, status)
+	if err != nil {
+		return mails, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		mail, err := scanOutboxMail(rows)
+		if err != nil {
+			return mails, err
+		}
+		mails = append(mails, mail)
+	}
+
+	return mails, rows.Err()
+}
+
+// ResendMail gives a dead email a new set of attempts, starting now. It returns sql.ErrNoRows when
+// there is no such dead email.
+func (m *postgresDbRepo) ResendMail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+update mail_outbox
+set status = $3, attempts = 0, next_attempt_at = $4, updated_at = $4
+where id = $1 and status = $2
+returning id`
+	return m.DB.QueryRowContext(ctx, stmt, id, models.MailDead, models.MailPending, time.Now()).Scan(&id)
+}
+
+// AllEmailTemplates returns the emails the owner has reworded
+func (m *postgresDbRepo) AllEmailTemplates() ([]models.EmailTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var templates []models.EmailTemplate
+
+	stmt := `
+select id, name, subject, html, text, created_at, updated_at
+from email_templates
+order by name`
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return templates, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var t models.EmailTemplate
+		err = rows.Scan(&t.ID, &t.Name, &t.Subject, &t.HTML, &t.Text, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, rows.Err()
+}
+
+// GetEmailTemplate returns the reworded email called name, sql.ErrNoRows when it uses its default wording
+func (m *postgresDbRepo) GetEmailTemplate(name string) (models.EmailTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+select id, name, subject, html, text, created_at, updated_at
+from email_templates
+where name = $1`
+	row := m.DB.QueryRowContext(ctx, stmt, name)
+
+	var t models.EmailTemplate
+	err := row.Scan(&t.ID, &t.Name, &t.Subject, &t.HTML, &t.Text, &t.CreatedAt, &t.UpdatedAt)
+	return t, err
+}
+
+// SaveEmailTemplate stores the wording of an email, replacing the one it had
+func (m *postgresDbRepo) SaveEmailTemplate(t models.EmailTemplate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+insert into email_templates (name, subject, html, text, created_at, updated_at)
+values ($1, $2, $3, $4, $5, $5)
+on conflict (name) do update
+    set subject = excluded.subject, html = excluded.html, text = excluded.text, updated_at = excluded.updated_at`
+	_, err := m.DB.ExecContext(ctx, stmt, t.Name, t.Subject, t.HTML, t.Text, time.Now())
+	return err
+}
+
+// DeleteEmailTemplate puts an email back to its default wording
+func (m *postgresDbRepo) DeleteEmailTemplate(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `delete from email_templates where name = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, name)
+	return err
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"github.com/zahnah/study-app/internal/config"
 	"github.com/zahnah/study-app/internal/models"
+	"github.com/zahnah/study-app/repository"
+	"strings"
 	"time"
 )
 
@@ -17,40 +19,303 @@ func (t testDbRepo) InsertBlockForRoom(id int, startDate time.Time) error {
 	return nil
 }
 
+func (t testDbRepo) InsertOwnerBlock(b models.OwnerBlock) (int, error) {
+	if b.RoomID == 2 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+	return 1, nil
+}
+
+func (t testDbRepo) AllOwnerBlocks() ([]models.OwnerBlock, error) {
+	return []models.OwnerBlock{
+		{
+			ID:         1,
+			RoomID:     1,
+			Note:       "Maintenance",
+			Recurrence: models.BlockWeekly,
+			Weekdays:   1 << time.Monday,
+			StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:    time.Date(2050, 3, 31, 0, 0, 0, 0, time.UTC),
+			Years:      1,
+			Room:       models.Room{ID: 1, RoomName: "General's Quarters"},
+		},
+	}, nil
+}
+
+func (t testDbRepo) DeleteOwnerBlock(id int) error {
+	return nil
+}
+
+func (t testDbRepo) DeleteBlocksByDate(roomID int, start, end time.Time) (int, int, error) {
+	if roomID == 2 {
+		return 0, 1, nil
+	}
+	return 1, 0, nil
+}
+
+func (t testDbRepo) UpdateCalendarBlocks(change models.CalendarChange) error {
+	restrictions, _ := t.GetRestrictionsForRoomByDate(change.RoomID, change.Start, change.End)
+	if change.Version != models.RestrictionsVersion(restrictions) {
+		return repository.ErrCalendarChanged
+	}
+	if change.RoomID == 2 && len(change.Add) > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+	// room 2 has the block of an imported calendar over the nights of its change
+	if change.RoomID == 2 && len(change.Remove) > 0 {
+		return repository.ErrImportedBlock
+	}
+	return nil
+}
+
 func (t testDbRepo) DeleteRoomRestriction(id int) error {
 	return nil
 }
 
 func (t testDbRepo) GetRestrictionsForRoomByDate(roomID int, startDate, rndDate time.Time) ([]models.RoomRestriction, error) {
 	var restrictions []models.RoomRestriction
+	if roomID == 1 && startDate.Year() == 2050 {
+		restrictions = append(restrictions,
+			models.RoomRestriction{
+				ID: 1, RoomID: 1, ReservationID: 1, RestrictionID: models.RestrictionReservation,
+				StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+				EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			},
+			models.RoomRestriction{
+				ID: 2, RoomID: 1, RestrictionID: models.RestrictionOwnerBlock, Note: "Painting",
+				StartDate: time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+				EndDate:   time.Date(2050, 1, 11, 0, 0, 0, 0, time.UTC),
+			},
+		)
+	}
 	return restrictions, nil
 }
 
 func (t testDbRepo) AllRooms() ([]models.Room, error) {
-	return make([]models.Room, 1), nil
+	return []models.Room{
+		{ID: 1, RoomName: "General's Quarters", Active: true, SortOrder: 1},
+		{ID: 2, RoomName: "Major's Suite", Active: true, SortOrder: 2},
+	}, nil
+}
+
+func (t testDbRepo) InsertRoom(room models.Room) (int, error) {
+	return 3, nil
+}
+
+func (t testDbRepo) UpdateRoom(room models.Room) error {
+	if room.ID > 2 {
+		return errors.New("can't find the room")
+	}
+	return nil
+}
+
+func (t testDbRepo) DeactivateRoom(id int) error {
+	if id > 2 {
+		return errors.New("can't find the room")
+	}
+	return nil
+}
+
+func (t testDbRepo) UpdateRoomICalToken(roomID int, token string) error {
+	if roomID > 2 {
+		return errors.New("can't find the room")
+	}
+	return nil
+}
+
+func (t testDbRepo) ReorderRooms(ids []int) error {
+	return nil
+}
+
+func (t testDbRepo) InsertRoomPhoto(photo models.RoomPhoto) (int, error) {
+	if photo.RoomID > 2 {
+		return 0, errors.New("can't find the room")
+	}
+	return 2, nil
+}
+
+func (t testDbRepo) DeleteRoomPhoto(roomID, photoID int) (models.RoomPhoto, error) {
+	if roomID > 2 || photoID > 1 {
+		return models.RoomPhoto{}, sql.ErrNoRows
+	}
+	return models.RoomPhoto{
+		ID:        photoID,
+		RoomID:    roomID,
+		Path:      "/static/uploads/rooms/1/photo-web.jpg",
+		ThumbPath: "/static/uploads/rooms/1/photo-thumb.jpg",
+	}, nil
+}
+
+func (t testDbRepo) AllRoomTypes() ([]models.RoomType, error) {
+	return []models.RoomType{
+		{ID: 1, Name: "Standard double", Units: 2},
+	}, nil
+}
+
+func (t testDbRepo) GetRoomTypeByID(id int) (models.RoomType, error) {
+	if id > 1 {
+		return models.RoomType{}, sql.ErrNoRows
+	}
+	return models.RoomType{ID: id, Name: "Standard double", Units: 2}, nil
+}
+
+func (t testDbRepo) InsertRoomType(rt models.RoomType) (int, error) {
+	return 2, nil
+}
+
+func (t testDbRepo) UpdateRoomType(rt models.RoomType) error {
+	return nil
+}
+
+func (t testDbRepo) DeleteRoomType(id int) error {
+	return nil
+}
+
+func (t testDbRepo) GetFreeUnits(roomID int, start, end time.Time, reservationID int) ([]models.Room, error) {
+	if roomID != 1 {
+		return nil, nil
+	}
+	return []models.Room{
+		{ID: 2, RoomName: "Major's Suite", RoomTypeID: 1},
+	}, nil
+}
+
+func (t testDbRepo) MoveReservationRoom(stay models.ReservationRoom, mails []models.MailData) error {
+	if stay.RoomID == 2 {
+		return repository.ErrRoomNotAvailable
+	}
+	return t.deliverMail(mails)
 }
 
-func (t testDbRepo) UpdateProcessedForReservations(id, processed int) error {
+func (t testDbRepo) ReassignReservationRoom(reservationRoomID, roomID int) error {
+	if roomID > 2 {
+		return repository.ErrRoomNotAvailable
+	}
 	return nil
 }
 
-func (t testDbRepo) DeleteReservation(id int) error {
+func (t testDbRepo) UpdateReservationStatus(id int, status models.ReservationStatus, userID int, note string) error {
+	reservation, err := t.GetReservationByID(id)
+	if err != nil {
+		return err
+	}
+	if !reservation.Status.CanTransitionTo(status) {
+		return repository.ErrInvalidStatusTransition
+	}
 	return nil
 }
 
+func (t testDbRepo) GetReservationStatusHistory(reservationID int) ([]models.ReservationStatusChange, error) {
+	return []models.ReservationStatusChange{
+		{ReservationID: reservationID, ToStatus: models.ReservationPending},
+	}, nil
+}
+
 func (t testDbRepo) UpdateReservation(r models.Reservation) error {
 	return nil
 }
 
 func (t testDbRepo) GetReservationByID(id int) (models.Reservation, error) {
-	return models.Reservation{}, nil
+	if id > 2 {
+		return models.Reservation{}, errors.New("can't find the reservation")
+	}
+	reservation := models.Reservation{
+		ID:         id,
+		RoomID:     1,
+		StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:    time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		TotalPrice: 20000,
+		Status:     models.ReservationPending,
+	}
+	reservation.Rooms = []models.ReservationRoom{
+		{
+			ID:            id,
+			ReservationID: id,
+			RoomID:        reservation.RoomID,
+			StartDate:     reservation.StartDate,
+			EndDate:       reservation.EndDate,
+			TotalPrice:    reservation.TotalPrice,
+			Room:          models.Room{ID: 1, RoomName: "General's Quarters", MaxAdults: 2, MaxChildren: 1},
+		},
+	}
+	if id == 2 {
+		cancelledAt := time.Date(2049, 12, 1, 0, 0, 0, 0, time.UTC)
+		reservation.CancelledAt = &cancelledAt
+		reservation.Status = models.ReservationCancelled
+	}
+	return reservation, nil
+}
+
+func (t testDbRepo) GetReservationByCode(code, email string) (models.Reservation, error) {
+	if code != "ABCDEFGHJKLM" || email != "guest@email.local" {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+	return models.Reservation{
+		ID:               1,
+		Email:            email,
+		RoomID:           1,
+		StartDate:        time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:          time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		ConfirmationCode: code,
+	}, nil
 }
 
-func (t testDbRepo) AllNewReservations() ([]models.Reservation, error) {
+func (t testDbRepo) UpdateReservationDates(res models.Reservation, mails []models.MailData) error {
+	if res.RoomID == 2 {
+		return repository.ErrRoomNotAvailable
+	}
+	return t.deliverMail(mails)
+}
+
+func (t testDbRepo) CancelReservation(id int, reason string, penalty int, userID int, mails []models.MailData) error {
+	return t.deliverMail(mails)
+}
+
+func (t testDbRepo) GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error) {
+	if roomID == 1 {
+		return models.CancellationPolicy{
+			ID:             1,
+			Name:           "Moderate",
+			FreeDays:       7,
+			PenaltyType:    models.CancellationPenaltyFirstNight,
+			PenaltyPercent: 0,
+		}, nil
+	}
+	return models.CancellationPolicy{}, nil
+}
+
+func (t testDbRepo) AllCancellationPolicies() ([]models.CancellationPolicy, error) {
+	return make([]models.CancellationPolicy, 1), nil
+}
+
+func (t testDbRepo) GetCancellationPolicyByID(id int) (models.CancellationPolicy, error) {
+	if id > 2 {
+		return models.CancellationPolicy{}, errors.New("can't find the policy")
+	}
+	return models.CancellationPolicy{ID: id, PenaltyType: models.CancellationPenaltyPercent}, nil
+}
+
+func (t testDbRepo) InsertCancellationPolicy(p models.CancellationPolicy) (int, error) {
+	return 1, nil
+}
+
+func (t testDbRepo) UpdateCancellationPolicy(p models.CancellationPolicy) error {
+	return nil
+}
+
+func (t testDbRepo) DeleteCancellationPolicy(id int) error {
+	return nil
+}
+
+func (t testDbRepo) UpdateRoomCancellationPolicy(roomID, policyID int) error {
+	return nil
+}
+
+func (t testDbRepo) AllNewReservations(status models.ReservationStatus) ([]models.Reservation, error) {
 	return make([]models.Reservation, 1), nil
 }
 
-func (t testDbRepo) AllReservations() ([]models.Reservation, error) {
+func (t testDbRepo) AllReservations(status models.ReservationStatus) ([]models.Reservation, error) {
 	return make([]models.Reservation, 1), nil
 }
 
@@ -84,21 +349,308 @@ func (t testDbRepo) InsertRoomRestriction(res models.RoomRestriction) (int, erro
 	return 1, nil
 }
 
-func (t testDbRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (t testDbRepo) InsertReservationWithRestriction(res models.Reservation, holdIDs []int, mails []models.MailData) (int, error) {
+	for _, room := range res.RoomStays() {
+		if room.RoomID > 2 {
+			return 0, errors.New("can't find the room")
+		}
+		if room.RoomID == 2 {
+			return 0, repository.ErrRoomNotAvailable
+		}
+		if room.EndDate.Sub(room.StartDate) > 28*24*time.Hour {
+			return 0, repository.ErrStayRuleViolated
+		}
+	}
+	return 1, t.deliverMail(mails)
+}
+
+func (t testDbRepo) InsertHold(roomID int, start, end, expiresAt time.Time) (models.RoomRestriction, error) {
+	if roomID > 2 {
+		return models.RoomRestriction{}, errors.New("can't find the room")
+	}
+	if roomID == 2 {
+		return models.RoomRestriction{}, repository.ErrRoomNotAvailable
+	}
+	return models.RoomRestriction{
+		ID:            1,
+		RestrictionID: models.RestrictionHold,
+		RoomID:        roomID,
+		StartDate:     start,
+		EndDate:       end,
+	}, nil
+}
+
+func (t testDbRepo) DeleteHold(id int) error {
+	return nil
+}
+
+func (t testDbRepo) DeleteExpiredHolds() (int, error) {
+	return 0, nil
+}
+
+func (t testDbRepo) SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error) {
 	return nil, nil
 }
 
-func (t testDbRepo) SearchAvailabilityByRoomID(start, end time.Time, roomID int) (bool, error) {
+func (t testDbRepo) SearchAvailabilityByRoomID(start, end time.Time, roomID int) (bool, string, error) {
 	if roomID == 3 {
-		return false, errors.New("can't find the room")
+		return false, "", errors.New("can't find the room")
+	}
+	if roomID == 2 {
+		return false, "The minimum stay is 3 nights", nil
+	}
+	if start.Year() > 2050 {
+		return true, "", nil
+	}
+	return false, "", nil
+}
+
+func (t testDbRepo) SearchAvailabilityForReservation(res models.Reservation) (bool, string, error) {
+	if res.StartDate.Year() > 2050 {
+		return false, "", nil
+	}
+	return true, "", nil
+}
+
+func (t testDbRepo) AllStayRules() ([]models.StayRule, error) {
+	return make([]models.StayRule, 1), nil
+}
+
+func (t testDbRepo) GetStayRulesByDate(start, end time.Time) ([]models.StayRule, error) {
+	var rules []models.StayRule
+	return rules, nil
+}
+
+func (t testDbRepo) GetStayRuleByID(id int) (models.StayRule, error) {
+	if id > 2 {
+		return models.StayRule{}, errors.New("can't find the stay rule")
 	}
-	return false, nil
+	return models.StayRule{ID: id, Weekdays: models.AllWeekdays}, nil
+}
+
+func (t testDbRepo) InsertStayRule(r models.StayRule) (int, error) {
+	return 1, nil
+}
+
+func (t testDbRepo) UpdateStayRule(r models.StayRule) error {
+	return nil
+}
+
+func (t testDbRepo) DeleteStayRule(id int) error {
+	return nil
+}
+
+func (t testDbRepo) GetRoomRate(roomID int) (models.RoomRate, error) {
+	if roomID > 2 {
+		return models.RoomRate{}, errors.New("can't find the room")
+	}
+	return models.RoomRate{RoomID: roomID, NightlyRate: 10000}, nil
+}
+
+func (t testDbRepo) UpdateRoomRate(roomID, nightlyRate int) error {
+	return nil
+}
+
+func (t testDbRepo) AllRateRules() ([]models.RateRule, error) {
+	return make([]models.RateRule, 1), nil
+}
+
+func (t testDbRepo) GetRateRulesForRoomByDate(roomID int, startDate, endDate time.Time) ([]models.RateRule, error) {
+	var rules []models.RateRule
+	return rules, nil
+}
+
+func (t testDbRepo) GetRateRuleByID(id int) (models.RateRule, error) {
+	if id > 2 {
+		return models.RateRule{}, errors.New("can't find the rate rule")
+	}
+	return models.RateRule{ID: id, Weekdays: models.AllWeekdays, AmountType: models.RateRulePercent}, nil
+}
+
+func (t testDbRepo) InsertRateRule(r models.RateRule) (int, error) {
+	return 1, nil
+}
+
+func (t testDbRepo) UpdateRateRule(r models.RateRule) error {
+	return nil
+}
+
+func (t testDbRepo) DeleteRateRule(id int) error {
+	return nil
 }
 
 func (t testDbRepo) GetRoomById(roomID int) (models.Room, error) {
 	var room models.Room
 	if roomID > 2 {
-		return room, errors.New("can't find the room")
+		return room, sql.ErrNoRows
+	}
+	room.ID = roomID
+	room.RoomName = "General's Quarters"
+	room.Amenities = "Wi-Fi\nGarden view"
+	room.NightlyRate = 10000
+	room.MaxAdults = 2
+	room.MaxChildren = 1
+	room.Active = true
+	if roomID == 1 {
+		room.ICalToken = "feed-token"
+	}
+	room.Photos = []models.RoomPhoto{
+		{ID: 1, RoomID: roomID, Path: "/static/room-images/generals-quarters.png", SortOrder: 1},
 	}
 	return room, nil
 }
+
+func (t testDbRepo) AllICalImports() ([]models.ICalImport, error) {
+	syncedAt := time.Date(2049, 12, 1, 10, 0, 0, 0, time.UTC)
+	return []models.ICalImport{
+		{ID: 1, RoomID: 1, Name: "Uploaded", Blocks: 2, LastSyncedAt: &syncedAt, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+		{ID: 2, RoomID: 2, Name: "Channel", URL: "http://127.0.0.1:1/calendar.ics", LastError: "connection refused", Room: models.Room{ID: 2, RoomName: "Major's Suite"}},
+	}, nil
+}
+
+func (t testDbRepo) GetICalImportByID(id int) (models.ICalImport, error) {
+	imports, _ := t.AllICalImports()
+	for _, imp := range imports {
+		if imp.ID == id {
+			return imp, nil
+		}
+	}
+	return models.ICalImport{}, sql.ErrNoRows
+}
+
+func (t testDbRepo) InsertICalImport(imp models.ICalImport) (int, error) {
+	return 3, nil
+}
+
+func (t testDbRepo) DeleteICalImport(id int) error {
+	return nil
+}
+
+func (t testDbRepo) SyncICalImport(importID int, blocks []models.RoomRestriction) (models.ICalSync, error) {
+	var sync models.ICalSync
+	for _, b := range blocks {
+		if b.EndDate.Year() < 2050 {
+			continue
+		}
+		if strings.Contains(b.ICalUID, "taken") {
+			sync.Conflicts = append(sync.Conflicts, b.ICalUID)
+		} else {
+			sync.Added++
+		}
+	}
+	return sync, nil
+}
+
+func (t testDbRepo) UpdateICalImportError(id int, message string) error {
+	return nil
+}
+
+// deliverMail stands in for the outbox and its workers, the mails go straight to the app's mailer
+func (t testDbRepo) deliverMail(mails []models.MailData) error {
+	if t.App.Mailer == nil {
+		return nil
+	}
+	for _, mail := range mails {
+		err := t.App.Mailer.Send(mail)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t testDbRepo) ClaimMail(limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	return nil, nil
+}
+
+func (t testDbRepo) MarkMailSent(id int) error {
+	return nil
+}
+
+func (t testDbRepo) RetryMail(id int, lastError string, at time.Time) error {
+	return nil
+}
+
+func (t testDbRepo) DeadLetterMail(id int, lastError string) error {
+	return nil
+}
+
+func (t testDbRepo) UnwrappedMail() ([]models.OutboxMail, error) {
+	return nil, nil
+}
+
+func (t testDbRepo) WrapMail(id int, content string) error {
+	return nil
+}
+
+func (t testDbRepo) AllOutboxMail(status models.MailStatus) ([]models.OutboxMail, error) {
+	mail := models.OutboxMail{
+		Mail: models.MailData{
+			To:      "john@smith.com",
+			From:    "me@local.local",
+			Subject: "Reservation Confirmation",
+		},
+		Status:        status,
+		NextAttemptAt: time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC),
+		LastError:     "dial tcp 127.0.0.1:1025: connect: connection refused",
+		CreatedAt:     time.Date(2050, 1, 1, 10, 0, 0, 0, time.UTC),
+	}
+	switch status {
+	case models.MailDead:
+		mail.ID = 1
+		mail.Attempts = 10
+	case models.MailPending:
+		mail.ID = 2
+		mail.Attempts = 2
+	default:
+		return nil, nil
+	}
+	return []models.OutboxMail{mail}, nil
+}
+
+func (t testDbRepo) ResendMail(id int) error {
+	if id != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// testEmailTemplates are the emails the owner reworded, the owner's cancellation refers to a field
+// that doesn't exist to fall back on its default wording
+var testEmailTemplates = []models.EmailTemplate{
+	{
+		ID:      1,
+		Name:    "reservation-cancelled-owner",
+		Subject: "Reservation cancelled",
+		HTML:    "<p>{{.Reservation.Missing}}</p>",
+		Text:    "{{.Reservation.Missing}}",
+	},
+	{
+		ID:      2,
+		Name:    "reservation-changed",
+		Subject: "Your stay has changed",
+		HTML:    "<p>Dear {{.Reservation.FirstName}}, your stay now starts on {{humanDate .Stay.StartDate}}.</p>",
+		Text:    "Dear {{.Reservation.FirstName}}, your stay now starts on {{humanDate .Stay.StartDate}}.",
+	},
+}
+
+func (t testDbRepo) AllEmailTemplates() ([]models.EmailTemplate, error) {
+	return testEmailTemplates, nil
+}
+
+func (t testDbRepo) GetEmailTemplate(name string) (models.EmailTemplate, error) {
+	for _, tmpl := range testEmailTemplates {
+		if tmpl.Name == name {
+			return tmpl, nil
+		}
+	}
+	return models.EmailTemplate{}, sql.ErrNoRows
+}
+
+func (t testDbRepo) SaveEmailTemplate(tmpl models.EmailTemplate) error {
+	return nil
+}
+
+func (t testDbRepo) DeleteEmailTemplate(name string) error {
+	return nil
+}
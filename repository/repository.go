@@ -1,10 +1,32 @@
 package repository
 
 import (
+	"errors"
 	"github.com/zahnah/study-app/internal/models"
 	"time"
 )
 
+// ErrRoomNotAvailable is returned when a room is already restricted for the requested dates
+var ErrRoomNotAvailable = errors.New("room is no longer available for the selected dates")
+
+// ErrStayRuleViolated is returned when a reservation breaks a stay rule of one of its rooms
+var ErrStayRuleViolated = errors.New("stay breaks the stay rules of the room")
+
+// ErrInvalidStatusTransition is returned when a reservation can't move from its current status to the requested one
+var ErrInvalidStatusTransition = errors.New("reservation can't move to the requested status")
+
+// ErrCalendarChanged is returned when a room's calendar changed since the edit of it was started
+var ErrCalendarChanged = errors.New("calendar changed since it was loaded")
+
+// ErrImportedBlock is returned when nights blocked by an imported calendar are freed, they follow the calendar's feed
+var ErrImportedBlock = errors.New("nights are blocked by an imported calendar")
+
+// ErrReservationClosed is returned when a reservation that is cancelled or whose stay is over is changed
+var ErrReservationClosed = errors.New("reservation is cancelled or its stay is over")
+
+// ErrGroupReservation is returned when a change that applies to a single room is made to a reservation of several rooms
+var ErrGroupReservation = errors.New("reservation books several rooms")
+
 type DatabaseRepo interface {
 	AllUsers() bool
 
@@ -12,35 +34,169 @@ type DatabaseRepo interface {
 
 	InsertRoomRestriction(res models.RoomRestriction) (int, error)
 
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	InsertReservationWithRestriction(res models.Reservation, holdIDs []int, mails []models.MailData) (int, error)
+
+	InsertHold(roomID int, start, end, expiresAt time.Time) (models.RoomRestriction, error)
+
+	DeleteHold(id int) error
+
+	DeleteExpiredHolds() (int, error)
 
-	SearchAvailabilityByRoomID(start, end time.Time, roomID int) (bool, error)
+	SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error)
+
+	SearchAvailabilityByRoomID(start, end time.Time, roomID int) (bool, string, error)
+
+	SearchAvailabilityForReservation(res models.Reservation) (bool, string, error)
 
 	GetRoomById(roomID int) (models.Room, error)
 
+	GetRoomRate(roomID int) (models.RoomRate, error)
+
+	UpdateRoomRate(roomID, nightlyRate int) error
+
+	AllRateRules() ([]models.RateRule, error)
+
+	GetRateRulesForRoomByDate(roomID int, startDate, endDate time.Time) ([]models.RateRule, error)
+
+	GetRateRuleByID(id int) (models.RateRule, error)
+
+	InsertRateRule(r models.RateRule) (int, error)
+
+	UpdateRateRule(r models.RateRule) error
+
+	DeleteRateRule(id int) error
+
+	AllStayRules() ([]models.StayRule, error)
+
+	GetStayRulesByDate(start, end time.Time) ([]models.StayRule, error)
+
+	GetStayRuleByID(id int) (models.StayRule, error)
+
+	InsertStayRule(r models.StayRule) (int, error)
+
+	UpdateStayRule(r models.StayRule) error
+
+	DeleteStayRule(id int) error
+
 	GetUserByID(id int) (models.User, error)
 
 	UpdateUser(u models.User) error
 
 	Authenticate(email, password string) (int, string, error)
 
-	AllReservations() ([]models.Reservation, error)
+	AllReservations(status models.ReservationStatus) ([]models.Reservation, error)
 
-	AllNewReservations() ([]models.Reservation, error)
+	AllNewReservations(status models.ReservationStatus) ([]models.Reservation, error)
 
 	GetReservationByID(id int) (models.Reservation, error)
 
+	GetReservationByCode(code, email string) (models.Reservation, error)
+
+	UpdateReservationDates(res models.Reservation, mails []models.MailData) error
+
+	CancelReservation(id int, reason string, penalty int, userID int, mails []models.MailData) error
+
+	GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error)
+
+	AllCancellationPolicies() ([]models.CancellationPolicy, error)
+
+	GetCancellationPolicyByID(id int) (models.CancellationPolicy, error)
+
+	InsertCancellationPolicy(p models.CancellationPolicy) (int, error)
+
+	UpdateCancellationPolicy(p models.CancellationPolicy) error
+
+	DeleteCancellationPolicy(id int) error
+
+	UpdateRoomCancellationPolicy(roomID, policyID int) error
+
 	GetRestrictionsForRoomByDate(roomID int, startDate, rndDate time.Time) ([]models.RoomRestriction, error)
 
 	UpdateReservation(r models.Reservation) error
 
-	UpdateProcessedForReservations(id, processed int) error
+	UpdateReservationStatus(id int, status models.ReservationStatus, userID int, note string) error
 
-	DeleteReservation(id int) error
+	GetReservationStatusHistory(reservationID int) ([]models.ReservationStatusChange, error)
 
 	AllRooms() ([]models.Room, error)
 
+	InsertRoom(room models.Room) (int, error)
+
+	UpdateRoom(room models.Room) error
+
+	DeactivateRoom(id int) error
+
+	UpdateRoomICalToken(roomID int, token string) error
+
+	ReorderRooms(ids []int) error
+
+	InsertRoomPhoto(photo models.RoomPhoto) (int, error)
+
+	DeleteRoomPhoto(roomID, photoID int) (models.RoomPhoto, error)
+
+	AllRoomTypes() ([]models.RoomType, error)
+
+	GetRoomTypeByID(id int) (models.RoomType, error)
+
+	InsertRoomType(t models.RoomType) (int, error)
+
+	UpdateRoomType(t models.RoomType) error
+
+	DeleteRoomType(id int) error
+
+	GetFreeUnits(roomID int, start, end time.Time, reservationID int) ([]models.Room, error)
+
+	ReassignReservationRoom(reservationRoomID, roomID int) error
+
+	MoveReservationRoom(stay models.ReservationRoom, mails []models.MailData) error
+
 	InsertBlockForRoom(id int, startDate time.Time) error
 
+	InsertOwnerBlock(b models.OwnerBlock) (int, error)
+
+	AllOwnerBlocks() ([]models.OwnerBlock, error)
+
+	DeleteOwnerBlock(id int) error
+
+	DeleteBlocksByDate(roomID int, start, end time.Time) (int, int, error)
+
 	DeleteRoomRestriction(id int) error
+
+	UpdateCalendarBlocks(change models.CalendarChange) error
+
+	AllICalImports() ([]models.ICalImport, error)
+
+	GetICalImportByID(id int) (models.ICalImport, error)
+
+	InsertICalImport(imp models.ICalImport) (int, error)
+
+	DeleteICalImport(id int) error
+
+	SyncICalImport(importID int, blocks []models.RoomRestriction) (models.ICalSync, error)
+
+	UpdateICalImportError(id int, message string) error
+
+	ClaimMail(limit int, lease time.Duration) ([]models.OutboxMail, error)
+
+	MarkMailSent(id int) error
+
+	RetryMail(id int, lastError string, at time.Time) error
+
+	DeadLetterMail(id int, lastError string) error
+
+	UnwrappedMail() ([]models.OutboxMail, error)
+
+	WrapMail(id int, content string) error
+
+	AllOutboxMail(status models.MailStatus) ([]models.OutboxMail, error)
+
+	ResendMail(id int) error
+
+	AllEmailTemplates() ([]models.EmailTemplate, error)
+
+	GetEmailTemplate(name string) (models.EmailTemplate, error)
+
+	SaveEmailTemplate(t models.EmailTemplate) error
+
+	DeleteEmailTemplate(name string) error
 }